@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"github.com/Shopify/sarama"
+	"github.com/ozoncp/ocp-request-api/internal/consumer"
+	"github.com/ozoncp/ocp-request-api/internal/metrics"
+	"github.com/rs/zerolog/log"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// buildEventHandlers creates handlers listed in consumer.handlers setting.
+// Returns a function closing resources held by the handlers.
+func buildEventHandlers() ([]consumer.Handler, func()) {
+	handlers := make([]consumer.Handler, 0, len(serviceConfig.Consumer.Handlers))
+	closers := make([]func() error, 0)
+
+	for _, name := range serviceConfig.Consumer.Handlers {
+		switch name {
+		case "log":
+			handlers = append(handlers, consumer.NewLogHandler())
+		case "counters":
+			handlers = append(handlers, consumer.NewCountingHandler(metrics.NewConsumerMetricsReporter()))
+		case "file":
+			sink, err := os.OpenFile(serviceConfig.Consumer.SinkPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+			if err != nil {
+				log.Panic().Msgf("failed to open events sink: %v", err)
+			}
+			closers = append(closers, sink.Close)
+			handlers = append(handlers, consumer.NewFileSink(sink))
+		default:
+			log.Panic().Msgf("unknown event handler %q, expected log, counters or file", name)
+		}
+	}
+
+	return handlers, func() {
+		for _, closer := range closers {
+			if err := closer(); err != nil {
+				log.Error().Err(err).Msg("failed to close event handler")
+			}
+		}
+	}
+}
+
+// runConsumer reads events topic with a consumer group until SIGTERM or SIGINT
+func runConsumer() {
+	cfg := sarama.NewConfig()
+	cfg.Version = kafkaVersion
+	cfg.Consumer.Offsets.Initial = sarama.OffsetOldest
+	cfg.Consumer.Return.Errors = true
+	group, err := sarama.NewConsumerGroup(kafkaBrokers(), serviceConfig.Consumer.Group, cfg)
+	if err != nil {
+		log.Panic().Msgf("failed to connect to Kafka brokers: %v", err)
+	}
+	defer group.Close()
+
+	handlers, closeHandlers := buildEventHandlers()
+	defer closeHandlers()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGTERM, syscall.SIGINT)
+	go func() {
+		<-sig
+		log.Info().Msgf("Got signal. Stopping consumer...")
+		cancel()
+	}()
+
+	log.Info().Msgf("consuming %v as %v", kafkaTopic, serviceConfig.Consumer.Group)
+	consumer.Run(
+		ctx, group, []string{kafkaTopic}, consumer.NewGroupHandler(handlers...), serviceConfig.Consumer.RetryInterval,
+	)
+}
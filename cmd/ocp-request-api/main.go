@@ -2,40 +2,54 @@ package main
 
 import (
 	"context"
+	"crypto/rsa"
+	"crypto/x509"
 	"flag"
 	"fmt"
 	"github.com/Shopify/sarama"
 	"github.com/grpc-ecosystem/grpc-gateway/runtime"
-	"github.com/opentracing/opentracing-go"
+	"github.com/jmoiron/sqlx"
 	"github.com/ozoncp/ocp-request-api/internal/api"
+	"github.com/ozoncp/ocp-request-api/internal/auth"
+	"github.com/ozoncp/ocp-request-api/internal/certs"
 	"github.com/ozoncp/ocp-request-api/internal/db"
+	"github.com/ozoncp/ocp-request-api/internal/flusher"
+	"github.com/ozoncp/ocp-request-api/internal/health"
+	"github.com/ozoncp/ocp-request-api/internal/idempotency"
+	"github.com/ozoncp/ocp-request-api/internal/interceptors"
+	"github.com/ozoncp/ocp-request-api/internal/lifecycle"
 	"github.com/ozoncp/ocp-request-api/internal/metrics"
+	"github.com/ozoncp/ocp-request-api/internal/outbox"
 	prod "github.com/ozoncp/ocp-request-api/internal/producer"
+	"github.com/ozoncp/ocp-request-api/internal/ratelimit"
 	repository "github.com/ozoncp/ocp-request-api/internal/repo"
+	"github.com/ozoncp/ocp-request-api/internal/saver"
 	"github.com/ozoncp/ocp-request-api/internal/search"
+	"github.com/ozoncp/ocp-request-api/internal/stats"
+	"github.com/ozoncp/ocp-request-api/internal/tracing"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/viper"
-	"github.com/uber/jaeger-client-go"
-	jaegercfg "github.com/uber/jaeger-client-go/config"
-	jaegerlog "github.com/uber/jaeger-client-go/log"
-	jaegermetrics "github.com/uber/jaeger-lib/metrics"
+	"go.opentelemetry.io/otel"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	grpcHealth "google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"strings"
 	"syscall"
+	"time"
 
 	desc "github.com/ozoncp/ocp-request-api/pkg/ocp-request-api"
 )
 
-const (
-	grpcPort           = ":82"
-	grpcServerEndpoint = "localhost:82"
-	kafkaTopic         = "ocp_request_events"
-)
+const kafkaTopic = "ocp_request_events"
+
+// kafkaVersion is the lowest Kafka version supporting record headers, which are used to pass trace context
+var kafkaVersion = sarama.V0_11_0_0
 
 var (
 	serviceConfig config
@@ -44,20 +58,100 @@ var (
 
 type config struct {
 	General struct {
-		tWriteBatchSize uint `mapstructure:"write_batch_size"`
+		WriteBatchSize  uint          `mapstructure:"write_batch_size"`
+		ShutdownTimeout time.Duration `mapstructure:"shutdown_timeout"`
 	}
 
+	Server struct {
+		GRPCAddress    string `mapstructure:"grpc_address"`
+		GatewayAddress string `mapstructure:"gateway_address"`
+		MetricsAddress string `mapstructure:"metrics_address"`
+	} `mapstructure:"server"`
+
+	TLS struct {
+		CertFile     string `mapstructure:"cert_file"`
+		KeyFile      string `mapstructure:"key_file"`
+		ClientCAFile string `mapstructure:"client_ca_file"`
+		CAFile       string `mapstructure:"ca_file"`
+		ServerName   string `mapstructure:"server_name"`
+	} `mapstructure:"tls"`
+
+	Saver struct {
+		Capacity      uint          `mapstructure:"capacity"`
+		FlushInterval time.Duration `mapstructure:"flush_interval"`
+		FlushWorkers  uint          `mapstructure:"flush_workers"`
+	} `mapstructure:"saver"`
+
+	Outbox struct {
+		BatchSize    uint64        `mapstructure:"batch_size"`
+		PollInterval time.Duration `mapstructure:"poll_interval"`
+	} `mapstructure:"outbox"`
+
+	Stats struct {
+		RefreshInterval  time.Duration `mapstructure:"refresh_interval"`
+		StatementTimeout time.Duration `mapstructure:"statement_timeout"`
+	} `mapstructure:"stats"`
+
+	Health struct {
+		CheckInterval time.Duration `mapstructure:"check_interval"`
+		CheckTimeout  time.Duration `mapstructure:"check_timeout"`
+		DrainDelay    time.Duration `mapstructure:"drain_delay"`
+	} `mapstructure:"health"`
+
+	Idempotency struct {
+		TTL           time.Duration `mapstructure:"ttl"`
+		PurgeInterval time.Duration `mapstructure:"purge_interval"`
+	} `mapstructure:"idempotency"`
+
 	Db struct {
 		DSN string `mapstructure:"dsn"`
 	}
 
 	Kafka struct {
-		Brokers []string `mapstructure:"brokers"`
+		Brokers      []string `mapstructure:"brokers"`
+		Async        bool     `mapstructure:"async"`
+		BufferSize   int      `mapstructure:"buffer_size"`
+		PartitionKey string   `mapstructure:"partition_key"`
+		Encoding     string   `mapstructure:"encoding"`
 	} `mapstructure:"kafka"`
 
-	Jaeger struct {
-		AgentHostPort string `mapstructure:"agent_host_port"`
-	} `mapstructure:"jaeger"`
+	Events struct {
+		Sink           string   `mapstructure:"sink"`
+		SinkPath       string   `mapstructure:"sink_path"`
+		MemoryCapacity int      `mapstructure:"memory_capacity"`
+		Types          []string `mapstructure:"types"`
+		ReadSampleRate float64  `mapstructure:"read_sample_rate"`
+		AggregateList  bool     `mapstructure:"aggregate_list"`
+	} `mapstructure:"events"`
+
+	Consumer struct {
+		Group         string        `mapstructure:"group"`
+		Handlers      []string      `mapstructure:"handlers"`
+		SinkPath      string        `mapstructure:"sink_path"`
+		RetryInterval time.Duration `mapstructure:"retry_interval"`
+	} `mapstructure:"consumer"`
+
+	Auth struct {
+		Enabled          bool   `mapstructure:"enabled"`
+		HMACSecret       string `mapstructure:"hmac_secret"`
+		RSAPublicKeyFile string `mapstructure:"rsa_public_key_file"`
+		JWKSFile         string `mapstructure:"jwks_file"`
+		Issuer           string `mapstructure:"issuer"`
+		Audience         string `mapstructure:"audience"`
+	} `mapstructure:"auth"`
+
+	RateLimit struct {
+		Default          ratelimit.Limit            `mapstructure:"default"`
+		Methods          map[string]ratelimit.Limit `mapstructure:"methods"`
+		DailyCreateQuota uint64                     `mapstructure:"daily_create_quota"`
+	} `mapstructure:"rate_limit"`
+
+	Tracing struct {
+		Exporter     string  `mapstructure:"exporter"`
+		OTLPEndpoint string  `mapstructure:"otlp_endpoint"`
+		OTLPInsecure bool    `mapstructure:"otlp_insecure"`
+		SampleRatio  float64 `mapstructure:"sample_ratio"`
+	} `mapstructure:"tracing"`
 }
 
 func init() {
@@ -69,7 +163,66 @@ func readConfig(path string) {
 	viper.SetConfigType("yaml")
 	viper.AddConfigPath(".")
 	viper.SetDefault("general.write_batch_size", 1000)
-	for _, param := range []string{"jaeger.agent_host_port", "kafka.brokers", "db.dsn", "general"} {
+	viper.SetDefault("general.shutdown_timeout", 10*time.Second)
+	viper.SetDefault("server.grpc_address", ":82")
+	viper.SetDefault("server.gateway_address", ":8081")
+	viper.SetDefault("server.metrics_address", ":9100")
+	viper.SetDefault("saver.capacity", 1000)
+	viper.SetDefault("saver.flush_interval", time.Second)
+	viper.SetDefault("saver.flush_workers", 1)
+	viper.SetDefault("outbox.batch_size", 100)
+	viper.SetDefault("kafka.async", false)
+	viper.SetDefault("kafka.buffer_size", 256)
+	viper.SetDefault("kafka.partition_key", string(prod.KeyByRequestId))
+	viper.SetDefault("kafka.encoding", string(prod.EncodingProtobuf))
+	viper.SetDefault("outbox.poll_interval", time.Second)
+	viper.SetDefault("idempotency.ttl", 24*time.Hour)
+	viper.SetDefault("idempotency.purge_interval", 10*time.Minute)
+	viper.SetDefault("stats.refresh_interval", time.Minute)
+	viper.SetDefault("stats.statement_timeout", 5*time.Second)
+	viper.SetDefault("health.check_interval", 5*time.Second)
+	viper.SetDefault("health.check_timeout", 2*time.Second)
+	viper.SetDefault("health.drain_delay", 3*time.Second)
+	viper.SetDefault("auth.enabled", true)
+	viper.SetDefault("rate_limit.default.rate", 50)
+	viper.SetDefault("rate_limit.default.burst", 100)
+	viper.SetDefault("rate_limit.methods", map[string]interface{}{
+		"MultiCreateRequestV1": map[string]interface{}{"rate": 1, "burst": 5},
+	})
+	viper.SetDefault("rate_limit.daily_create_quota", 10000)
+	viper.SetDefault("tracing.exporter", string(tracing.ExporterOTLP))
+	viper.SetDefault("tracing.otlp_endpoint", "localhost:4317")
+	viper.SetDefault("tracing.otlp_insecure", true)
+	viper.SetDefault("tracing.sample_ratio", 1.0)
+	viper.SetDefault("events.sink", "kafka")
+	viper.SetDefault("events.sink_path", "events.jsonl")
+	viper.SetDefault("events.memory_capacity", 1000)
+	viper.SetDefault("events.types", []string{"create", "read", "update", "delete", "list"})
+	viper.SetDefault("events.read_sample_rate", 1.0)
+	viper.SetDefault("events.aggregate_list", true)
+	viper.SetDefault("consumer.group", "ocp-request-api-consumer")
+	viper.SetDefault("consumer.handlers", []string{"log", "counters"})
+	viper.SetDefault("consumer.sink_path", "events.jsonl")
+	viper.SetDefault("consumer.retry_interval", 5*time.Second)
+	for _, param := range []string{
+		"kafka.brokers", "kafka.async", "kafka.buffer_size", "kafka.partition_key", "kafka.encoding",
+		"db.dsn",
+		"general",
+		"general.shutdown_timeout",
+		"server.grpc_address", "server.gateway_address", "server.metrics_address",
+		"tls.cert_file", "tls.key_file", "tls.client_ca_file", "tls.ca_file", "tls.server_name",
+		"saver.capacity", "saver.flush_interval", "saver.flush_workers",
+		"outbox.batch_size", "outbox.poll_interval",
+		"idempotency.ttl", "idempotency.purge_interval",
+		"stats.refresh_interval", "stats.statement_timeout",
+		"health.check_interval", "health.check_timeout", "health.drain_delay",
+		"auth.enabled", "auth.hmac_secret", "auth.rsa_public_key_file", "auth.jwks_file", "auth.issuer", "auth.audience",
+		"rate_limit.default.rate", "rate_limit.default.burst", "rate_limit.daily_create_quota",
+		"tracing.exporter", "tracing.otlp_endpoint", "tracing.otlp_insecure", "tracing.sample_ratio",
+		"events.sink", "events.sink_path", "events.memory_capacity",
+		"events.types", "events.read_sample_rate", "events.aggregate_list",
+		"consumer.group", "consumer.handlers", "consumer.sink_path", "consumer.retry_interval",
+	} {
 		viper.BindEnv(param,
 			fmt.Sprintf("OCP_REQUEST_%v", strings.ToUpper(strings.Replace(param, ".", "_", -1))))
 	}
@@ -79,7 +232,7 @@ func readConfig(path string) {
 	}
 
 	// check for required settings
-	for _, s := range []string{"jaeger.agent_host_port", "kafka.brokers", "db.dsn"} {
+	for _, s := range []string{"db.dsn"} {
 		if !viper.IsSet(s) {
 			log.Panic().Msgf("%v setting is not set", s)
 		}
@@ -88,109 +241,425 @@ func readConfig(path string) {
 	if err := viper.Unmarshal(&serviceConfig); err != nil {
 		log.Panic().Msgf("failed to load config: %v", err)
 	}
+
+	for setting, address := range map[string]string{
+		"server.grpc_address":    serviceConfig.Server.GRPCAddress,
+		"server.gateway_address": serviceConfig.Server.GatewayAddress,
+		"server.metrics_address": serviceConfig.Server.MetricsAddress,
+	} {
+		if _, _, err := net.SplitHostPort(address); err != nil {
+			log.Panic().Msgf("invalid %v setting: %v", setting, err)
+		}
+	}
+	if (serviceConfig.TLS.CertFile == "") != (serviceConfig.TLS.KeyFile == "") {
+		log.Panic().Msg("tls.cert_file and tls.key_file settings must be set together")
+	}
+	if serviceConfig.TLS.CertFile == "" && serviceConfig.TLS.ClientCAFile != "" {
+		log.Panic().Msg("tls.client_ca_file setting requires tls.cert_file and tls.key_file")
+	}
+	if _, err := prod.ParsePartitionKey(serviceConfig.Kafka.PartitionKey); err != nil {
+		log.Panic().Msgf("invalid kafka.partition_key setting: %v", err)
+	}
+	if _, err := prod.ParseEncoding(serviceConfig.Kafka.Encoding); err != nil {
+		log.Panic().Msgf("invalid kafka.encoding setting: %v", err)
+	}
+	if rate := serviceConfig.Events.ReadSampleRate; rate < 0 || rate > 1 {
+		log.Panic().Msgf("invalid events.read_sample_rate setting: %v is not in [0, 1] range", rate)
+	}
+	if _, err := tracing.ParseExporter(serviceConfig.Tracing.Exporter); err != nil {
+		log.Panic().Msgf("invalid tracing.exporter setting: %v", err)
+	}
+	if ratio := serviceConfig.Tracing.SampleRatio; ratio < 0 || ratio > 1 {
+		log.Panic().Msgf("invalid tracing.sample_ratio setting: %v is not in [0, 1] range", ratio)
+	}
+	// the delay is a part of the shutdown, running calls need time to complete after it
+	if serviceConfig.Health.DrainDelay >= serviceConfig.General.ShutdownTimeout {
+		log.Panic().Msg("health.drain_delay setting must be less than general.shutdown_timeout")
+	}
+}
+
+// authKeys loads keys verifying bearer tokens from auth.* settings
+func authKeys() auth.Keys {
+	keys := auth.Keys{HMACSecret: []byte(serviceConfig.Auth.HMACSecret), RSA: map[string]*rsa.PublicKey{}}
+	if path := serviceConfig.Auth.RSAPublicKeyFile; path != "" {
+		key, err := auth.LoadRSAPublicKey(path)
+		if err != nil {
+			log.Panic().Msgf("failed to load auth.rsa_public_key_file: %v", err)
+		}
+		keys.RSA[""] = key
+	}
+	if path := serviceConfig.Auth.JWKSFile; path != "" {
+		jwks, err := auth.LoadJWKS(path)
+		if err != nil {
+			log.Panic().Msgf("failed to load auth.jwks_file: %v", err)
+		}
+		for kid, key := range jwks {
+			keys.RSA[kid] = key
+		}
+	}
+	if keys.Empty() {
+		log.Panic().Msg("auth is enabled, but none of auth.hmac_secret, auth.rsa_public_key_file and auth.jwks_file is set")
+	}
+	return keys
+}
+
+// serverOptions returns options of the gRPC server, calls are reported to `reporter`.
+// Connections are secured with certificates of `store` if it is not nil.
+// Repeated create calls with idempotency `keys` do not spend the daily quota.
+func serverOptions(reporter metrics.GrpcMetricsReporter, store certs.Store, keys idempotency.Store) []grpc.ServerOption {
+	// rejected and panicked calls are logged, traced and counted as well
+	chain := []grpc.UnaryServerInterceptor{
+		interceptors.Logging(),
+		interceptors.Tracing(otel.Tracer(tracing.InstrumentationName)),
+		interceptors.Metrics(reporter),
+		interceptors.Recovery(),
+	}
+	if serviceConfig.Auth.Enabled {
+		verifier := auth.NewVerifier(authKeys(), serviceConfig.Auth.Issuer, serviceConfig.Auth.Audience)
+		// probes of orchestrators carry no tokens
+		chain = append(chain, auth.UnaryServerInterceptor(verifier, healthpb.Health_ServiceDesc.ServiceName))
+	} else {
+		log.Warn().Msg("authentication is disabled, any caller can access all requests")
+	}
+	// limits are applied after authentication to tell callers apart by identity
+	chain = append(chain, ratelimit.UnaryServerInterceptor(ratelimit.Config{
+		Default:          serviceConfig.RateLimit.Default,
+		Methods:          serviceConfig.RateLimit.Methods,
+		DailyCreateQuota: serviceConfig.RateLimit.DailyCreateQuota,
+		Keys:             keys,
+	}))
+	opts := []grpc.ServerOption{grpc.ChainUnaryInterceptor(chain...)}
+	if store != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(store.MutualServerConfig())))
+	}
+	return opts
+}
+
+// eventsPolicy returns configured policy of sending events
+func eventsPolicy() prod.Policy {
+	policy := prod.Policy{
+		ReadSampleRate: serviceConfig.Events.ReadSampleRate,
+		AggregateList:  serviceConfig.Events.AggregateList,
+	}
+	for _, name := range serviceConfig.Events.Types {
+		eventType, err := prod.ParseEventType(name)
+		if err != nil {
+			log.Panic().Msgf("invalid events.types setting: %v", err)
+		}
+		policy.Types = append(policy.Types, eventType)
+	}
+	return policy
+}
+
+// eventsFormat returns configured format of Kafka messages
+func eventsFormat() prod.Format {
+	return prod.Format{
+		KeyBy:    prod.PartitionKey(serviceConfig.Kafka.PartitionKey),
+		Encoding: prod.Encoding(serviceConfig.Kafka.Encoding),
+	}
+}
+
+// kafkaBrokers returns configured Kafka brokers. The setting is required only if Kafka is used.
+func kafkaBrokers() []string {
+	if len(serviceConfig.Kafka.Brokers) == 0 {
+		log.Panic().Msgf("kafka.brokers setting is not set")
+	}
+	return serviceConfig.Kafka.Brokers
+}
+
+// buildSink returns a producer of the configured events sink sending all events
+func buildSink() prod.Producer {
+	var sink prod.Producer
+	switch serviceConfig.Events.Sink {
+	case "kafka":
+		sink = buildKafkaProducer()
+	case "noop":
+		sink = prod.NewNoopProducer()
+	case "stdout":
+		sink = prod.NewWriterProducer(os.Stdout)
+	case "file":
+		var err error
+		if sink, err = prod.NewFileProducer(serviceConfig.Events.SinkPath); err != nil {
+			log.Panic().Msgf("failed to open events sink: %v", err)
+		}
+	case "memory":
+		sink = prod.NewMemoryProducer(serviceConfig.Events.MemoryCapacity)
+	default:
+		log.Panic().Msgf("unknown events sink %q, expected kafka, noop, stdout, file or memory", serviceConfig.Events.Sink)
+	}
+	return sink
 }
 
 func buildKafkaProducer() prod.Producer {
-	brokers := serviceConfig.Kafka.Brokers
+	brokers := kafkaBrokers()
 
 	cfg := sarama.NewConfig()
-	cfg.Producer.Partitioner = sarama.NewRandomPartitioner
+	cfg.Version = kafkaVersion
+	cfg.Producer.Partitioner = sarama.NewHashPartitioner
 	cfg.Producer.RequiredAcks = sarama.WaitForAll
 	cfg.Producer.Return.Successes = true
-	producer, err := sarama.NewSyncProducer(brokers, cfg)
-
+	// a retried batch can't overtake the next one, so events of a request are kept in order
+	cfg.Net.MaxOpenRequests = 1
+	client, err := sarama.NewClient(brokers, cfg)
 	if err != nil {
 		log.Panic().Msgf("failed to connect to Kafka brokers: %v", err)
 	}
 
-	return prod.NewProducer(kafkaTopic, producer)
+	producer, err := prod.NewClientProducer(kafkaTopic, eventsFormat(), client)
+	if err != nil {
+		log.Panic().Msgf("failed to create Kafka producer: %v", err)
+	}
+	return producer
 }
 
-func initTracing() {
-	cfg := jaegercfg.Configuration{
-		ServiceName: "ocp-request-api",
-		Sampler: &jaegercfg.SamplerConfig{
-			Type:  jaeger.SamplerTypeConst,
-			Param: 1,
-		},
-		Reporter: &jaegercfg.ReporterConfig{
-			LocalAgentHostPort: serviceConfig.Jaeger.AgentHostPort,
-			LogSpans:           true,
-		},
+// buildEventsProducer returns a producer for events sent directly by API handlers.
+// Unlike outbox relay, handlers do not need delivery guarantees, so they may use a non-blocking producer.
+func buildEventsProducer(syncProducer prod.Producer) prod.Producer {
+	if serviceConfig.Events.Sink != "kafka" || !serviceConfig.Kafka.Async {
+		return syncProducer
+	}
+
+	cfg := sarama.NewConfig()
+	cfg.Version = kafkaVersion
+	cfg.Producer.Partitioner = sarama.NewHashPartitioner
+	cfg.Producer.RequiredAcks = sarama.WaitForAll
+	cfg.Producer.Return.Successes = true
+	producer, err := sarama.NewAsyncProducer(kafkaBrokers(), cfg)
+
+	if err != nil {
+		log.Panic().Msgf("failed to connect to Kafka brokers: %v", err)
 	}
 
-	jLogger := jaegerlog.StdLogger
-	jMetricsFactory := jaegermetrics.NullFactory
-	tracer, _, err := cfg.NewTracer(
-		jaegercfg.Logger(jLogger),
-		jaegercfg.Metrics(jMetricsFactory),
+	return prod.NewPolicyProducer(
+		eventsPolicy(),
+		prod.NewAsyncProducer(
+			kafkaTopic, eventsFormat(), producer, serviceConfig.Kafka.BufferSize, metrics.NewProducerMetricsReporter(),
+		),
 	)
+}
 
+// buildFlusher returns a Flusher of the Saver reporting flushes to `reporter`.
+// Chunks are written in parallel if saver.flush_workers is above one.
+func buildFlusher(repo repository.Repo, reporter metrics.FlushMetricsReporter) flusher.Flusher {
+	if workers := serviceConfig.Saver.FlushWorkers; workers > 1 {
+		return flusher.NewConcurrentFlusher(serviceConfig.General.WriteBatchSize, workers, repo, reporter)
+	}
+	return flusher.NewFlusher(serviceConfig.General.WriteBatchSize, repo, reporter)
+}
+
+// certificateStore returns a store of certificates set by tls.* settings, nil if TLS is disabled
+func certificateStore() certs.Store {
+	if serviceConfig.TLS.CertFile == "" {
+		return nil
+	}
+	store, err := certs.NewStore(serviceConfig.TLS.CertFile, serviceConfig.TLS.KeyFile, serviceConfig.TLS.ClientCAFile)
 	if err != nil {
-		log.Panic().Msgf("failed to initialize jaeger: %v", err)
+		log.Panic().Msgf("failed to load tls settings: %v", err)
+	}
+	return store
+}
+
+// grpcEndpoint returns an address the gateway dials the gRPC server at
+func grpcEndpoint() string {
+	host, port, _ := net.SplitHostPort(serviceConfig.Server.GRPCAddress)
+	if host == "" || net.ParseIP(host).IsUnspecified() {
+		host = "localhost"
 	}
-	opentracing.SetGlobalTracer(tracer)
+	return net.JoinHostPort(host, port)
 }
 
-func run() error {
-	listen, err := net.Listen("tcp", grpcPort)
+// healthChecks returns checks of dependencies the service is not ready without
+func healthChecks(database *sqlx.DB, sink prod.Producer, requestSaver saver.Saver) map[string]health.Check {
+	checks := map[string]health.Check{
+		"db": database.PingContext,
+		"saver": func(ctx context.Context) error {
+			if backlog := requestSaver.Backlog(); backlog >= int(serviceConfig.Saver.Capacity) {
+				return fmt.Errorf("%v requests are waiting to be flushed", backlog)
+			}
+			return nil
+		},
+	}
+	// the outbox is published by the sink, so it is checked rather than a separate connection to Kafka
+	if checker, ok := sink.(prod.HealthChecker); ok {
+		checks["kafka"] = checker.Check
+	}
+	return checks
+}
+
+// initTracing sets up the global tracer provider and propagator. The returned function flushes pending spans.
+func initTracing() func(ctx context.Context) error {
+	provider, err := tracing.NewProvider(context.Background(), tracing.Config{
+		ServiceName:  "ocp-request-api",
+		Exporter:     tracing.Exporter(serviceConfig.Tracing.Exporter),
+		OTLPEndpoint: serviceConfig.Tracing.OTLPEndpoint,
+		OTLPInsecure: serviceConfig.Tracing.OTLPInsecure,
+		SampleRatio:  serviceConfig.Tracing.SampleRatio,
+	})
 	if err != nil {
-		log.Panic().Msgf("failed to listen: %v", err)
+		log.Panic().Msgf("failed to initialize tracing: %v", err)
 	}
+	otel.SetTracerProvider(provider)
+	// trace context is passed with Kafka messages in W3C traceparent format, so it can be read by any tool
+	otel.SetTextMapPropagator(tracing.NewPropagator())
+
+	return provider.Shutdown
+}
+
+// flushSpans flushes pending spans with `shutdownTracing` within the shutdown timeout
+func flushSpans(shutdownTracing func(ctx context.Context) error) {
+	ctx, cancel := context.WithTimeout(context.Background(), serviceConfig.General.ShutdownTimeout)
+	defer cancel()
+	if err := shutdownTracing(ctx); err != nil {
+		log.Error().Err(err).Msg("failed to flush spans")
+	}
+}
 
-	grpcServer := grpc.NewServer()
+// run serves calls until SIGTERM or SIGINT. Then calls are drained, pending work is completed
+// and resources are released in order: HTTP servers, gRPC server, background workers, Saver,
+// producers, tracing and the database.
+func run(shutdownTracing func(ctx context.Context) error) error {
+	listen, err := net.Listen("tcp", serviceConfig.Server.GRPCAddress)
+	if err != nil {
+		log.Panic().Msgf("failed to listen: %v", err)
+	}
 
 	database := db.Connect(serviceConfig.Db.DSN)
-	defer database.Close()
-	repo := repository.NewRepo(database)
+	metrics.RegisterDBStats(database.DB, "requests")
+	idempotencyStore := idempotency.NewStore(database, serviceConfig.Idempotency.TTL)
+
+	store := certificateStore()
 	prom := metrics.NewMetricsReporter()
-	producer := buildKafkaProducer()
-	defer producer.Close()
-	tracer := opentracing.GlobalTracer()
-	searcher := search.NewSearcher(database)
+	grpcServer := grpc.NewServer(serverOptions(prom, store, idempotencyStore)...)
 
-	desc.RegisterOcpRequestApiServer(
-		grpcServer, api.NewRequestApi(repo, serviceConfig.General.tWriteBatchSize, prom, producer, tracer, searcher),
+	repo := repository.NewRepo(database, idempotencyStore)
+	sink := buildSink()
+	producer := prod.NewPolicyProducer(eventsPolicy(), sink)
+	eventsProducer := buildEventsProducer(producer)
+	tracer := otel.Tracer(tracing.InstrumentationName)
+	searcher := search.NewSearcher(database)
+	flushReporter := metrics.NewFlushMetricsReporter()
+	requestSaver := saver.NewSaver(
+		serviceConfig.Saver.Capacity,
+		buildFlusher(repo, flushReporter),
+		serviceConfig.Saver.FlushInterval,
+		flushReporter,
 	)
 
-	sig := make(chan os.Signal, 1)
-	signal.Notify(sig, syscall.SIGTERM)
+	checks := healthChecks(database, sink, requestSaver)
+	healthServer := grpcHealth.NewServer()
+	healthpb.RegisterHealthServer(grpcServer, healthServer)
+	checker := health.NewChecker(
+		checks, serviceConfig.Health.CheckTimeout, healthServer, desc.OcpRequestApi_ServiceDesc.ServiceName,
+	)
+	http.Handle("/healthz", health.LivenessHandler())
+	http.Handle("/readyz", health.ReadinessHandler(checker))
+	if memory, ok := sink.(prod.MemoryProducer); ok {
+		log.Warn().Msg("events are served at /debug/events of the metrics server without authentication, use memory sink for debugging only")
+		http.Handle("/debug/events", prod.NewMemoryHandler(memory))
+	}
 
-	go func() {
-		<-sig
-		log.Info().Msgf("Got SIGTERM. Stopping...")
-		grpcServer.GracefulStop()
-	}()
+	desc.RegisterOcpRequestApiServer(
+		grpcServer, api.NewRequestApi(
+			repo, serviceConfig.General.WriteBatchSize, prom, eventsProducer, tracer, searcher,
+		),
+	)
 
-	if err := grpcServer.Serve(listen); err != nil {
-		log.Panic().Msgf("failed to serve: %v", err)
+	gatewayCtx, stopGateway := context.WithCancel(context.Background())
+	defer stopGateway()
+
+	manager := lifecycle.NewManager(serviceConfig.General.ShutdownTimeout)
+	// load balancers stop sending new calls while running ones are completed
+	manager.Add("health", lifecycle.Worker(func(ctx context.Context) {
+		checker.Run(ctx, serviceConfig.Health.CheckInterval)
+		checker.Shutdown()
+	}))
+	manager.Add("drain delay", lifecycle.Delay(serviceConfig.Health.DrainDelay))
+	manager.Add("gateway", lifecycle.HTTPServer(gatewayServer(gatewayCtx, store)))
+	manager.Add("grpc", lifecycle.GRPCServer(grpcServer, listen))
+	// probes are answered until calls are drained
+	manager.Add("metrics", lifecycle.HTTPServer(metricsServer()))
+	if store != nil {
+		manager.Add("certificates", lifecycle.Worker(store.Watch))
+	}
+	// undelivered events stay in the outbox and are published after restart
+	manager.Add("outbox", lifecycle.Worker(func(ctx context.Context) {
+		outbox.NewRelay(database, producer, serviceConfig.Outbox.BatchSize, serviceConfig.Outbox.PollInterval).Run(ctx)
+	}))
+	manager.Add("idempotency keys", lifecycle.Worker(func(ctx context.Context) {
+		idempotency.NewPurger(idempotencyStore, serviceConfig.Idempotency.PurgeInterval).Run(ctx)
+	}))
+	manager.Add("stats", lifecycle.Worker(func(ctx context.Context) {
+		stats.NewCollector(database, serviceConfig.Stats.RefreshInterval, serviceConfig.Stats.StatementTimeout).Run(ctx)
+	}))
+	// no handlers are running at this point, so pending saves can be flushed
+	manager.Add("saver", lifecycle.Closer(requestSaver.Close))
+	if eventsProducer != producer {
+		manager.Add("events producer", lifecycle.Closer(func(context.Context) error { return eventsProducer.Close() }))
 	}
+	manager.Add("producer", lifecycle.Closer(func(context.Context) error { return producer.Close() }))
+	manager.Add("tracing", lifecycle.Closer(shutdownTracing))
+	manager.Add("database", lifecycle.Closer(func(context.Context) error { return database.Close() }))
 
-	return nil
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+	return manager.Run(ctx)
 }
 
-func runJSON() {
-	ctx := context.Background()
-	ctx, cancel := context.WithCancel(ctx)
-	defer cancel()
+// gatewayServer returns an HTTP server of the gateway passing calls to the gRPC server until `ctx` is done.
+// If `store` is not nil, the gateway serves HTTPS and connects to the gRPC server with TLS
+// presenting the same certificate.
+func gatewayServer(ctx context.Context, store certs.Store) *http.Server {
+	mux := runtime.NewServeMux(runtime.WithIncomingHeaderMatcher(incomingHeaderMatcher))
+	endpoint := grpcEndpoint()
+	server := &http.Server{Addr: serviceConfig.Server.GatewayAddress, Handler: mux}
 
-	mux := runtime.NewServeMux()
 	opts := []grpc.DialOption{grpc.WithInsecure()}
+	if store != nil {
+		var rootCAs *x509.CertPool // system roots
+		if path := serviceConfig.TLS.CAFile; path != "" {
+			var err error
+			if rootCAs, err = certs.LoadCAPool(path); err != nil {
+				log.Panic().Msgf("failed to load tls.ca_file: %v", err)
+			}
+		}
+		serverName := serviceConfig.TLS.ServerName
+		if serverName == "" {
+			serverName, _, _ = net.SplitHostPort(endpoint)
+		}
+		opts = []grpc.DialOption{grpc.WithTransportCredentials(credentials.NewTLS(store.ClientConfig(serverName, rootCAs)))}
+		server.TLSConfig = store.ServerConfig()
+	}
 
-	err := desc.RegisterOcpRequestApiHandlerFromEndpoint(ctx, mux, grpcServerEndpoint, opts)
+	err := desc.RegisterOcpRequestApiHandlerFromEndpoint(ctx, mux, endpoint, opts)
 	if err != nil {
-		panic(err)
+		log.Panic().Msgf("failed to set up the gateway: %v", err)
 	}
+	return server
+}
 
-	err = http.ListenAndServe(":8081", mux)
-	if err != nil {
-		panic(err)
+// forwardedHeaders are HTTP headers the gateway passes to gRPC metadata in addition to the default ones.
+// Trace context headers are passed, so that calls made via the gateway continue traces of HTTP clients.
+var forwardedHeaders = append([]string{idempotency.MetadataKey, interceptors.RequestIdMetadataKey}, tracing.PropagationHeaders...)
+
+// incomingHeaderMatcher passes forwardedHeaders to gRPC metadata with lowercase keys.
+// Authorization header is always passed by the gateway as authorization metadata, the auth interceptor reads it.
+func incomingHeaderMatcher(key string) (string, bool) {
+	for _, metadataKey := range forwardedHeaders {
+		if strings.EqualFold(key, metadataKey) {
+			return metadataKey, true
+		}
 	}
+	return runtime.DefaultHeaderMatcher(key)
 }
 
-func runMetrics() {
+// metricsServer returns an HTTP server of metrics, health and debug endpoints
+func metricsServer() *http.Server {
 	http.Handle("/metrics", promhttp.Handler())
-	if err := http.ListenAndServe(":9100", nil); err != nil {
+	return &http.Server{Addr: serviceConfig.Server.MetricsAddress}
+}
+
+func runMetrics() {
+	if err := metricsServer().ListenAndServe(); err != nil {
 		log.Panic().Msgf("metrics endpoint failed: %v", err)
 	}
 }
@@ -198,11 +667,21 @@ func runMetrics() {
 func main() {
 	flag.Parse()
 	readConfig(configPath)
-	initTracing()
+	shutdownTracing := initTracing()
 
-	go runJSON()
-	go runMetrics()
-	if err := run(); err != nil {
-		log.Panic().Msgf("service exited with error: %v", err)
+	switch command := flag.Arg(0); command {
+	case "":
+		if err := run(shutdownTracing); err != nil {
+			log.Panic().Msgf("service exited with error: %v", err)
+		}
+	case "consume":
+		defer flushSpans(shutdownTracing)
+		go runMetrics()
+		runConsumer()
+	case "replay-events":
+		defer flushSpans(shutdownTracing)
+		runReplay(flag.Args()[1:])
+	default:
+		log.Panic().Msgf("unknown command %q", command)
 	}
 }
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/ozoncp/ocp-request-api/internal/db"
+	"github.com/ozoncp/ocp-request-api/internal/idempotency"
+	"github.com/ozoncp/ocp-request-api/internal/replay"
+	repository "github.com/ozoncp/ocp-request-api/internal/repo"
+	"github.com/rs/zerolog/log"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// runReplay publishes CREATE events with replay marker for stored requests until done or SIGTERM or SIGINT
+func runReplay(args []string) {
+	var (
+		filter    repository.Filter
+		rate      uint
+		batchSize uint64
+	)
+	flags := flag.NewFlagSet("replay-events", flag.ExitOnError)
+	flags.Uint64Var(&filter.FromId, "from-id", 0, "Replay requests with id greater or equal to this one")
+	flags.Uint64Var(&filter.ToId, "to-id", 0, "Replay requests with id less or equal to this one")
+	flags.Uint64Var(&filter.UserId, "user-id", 0, "Replay requests of this user only")
+	flags.UintVar(&rate, "rate", 100, "Maximum number of events per second, must be greater than 0")
+	flags.Uint64Var(&batchSize, "batch-size", 100, "Number of requests read from the database at once, must be greater than 0")
+	_ = flags.Parse(args)
+	// a zero batch reads nothing, so the replay would silently succeed
+	if rate == 0 || batchSize == 0 {
+		fmt.Fprintln(flags.Output(), "-rate and -batch-size must be greater than 0")
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	database := db.Connect(serviceConfig.Db.DSN)
+	defer database.Close()
+	// replayed events are sent regardless of events.* policy, as the command is run to publish them
+	producer := buildSink()
+	defer producer.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGTERM, syscall.SIGINT)
+	go func() {
+		<-sig
+		log.Info().Msgf("Got signal. Stopping replay...")
+		cancel()
+	}()
+
+	replayer := replay.NewReplayer(repository.NewRepo(database, idempotency.NewStore(database, serviceConfig.Idempotency.TTL)), producer, batchSize, rate)
+	replayed, err := replayer.Replay(ctx, filter)
+	if err != nil {
+		log.Error().Msgf("replay stopped after %v events: %v", replayed, err)
+		return
+	}
+	log.Info().Msgf("replayed %v events", replayed)
+}
@@ -3,8 +3,8 @@ package api
 import (
 	"context"
 	"errors"
-	"github.com/opentracing/opentracing-go"
-	traceLog "github.com/opentracing/opentracing-go/log"
+	"github.com/ozoncp/ocp-request-api/internal/authz"
+	"github.com/ozoncp/ocp-request-api/internal/idempotency"
 	"github.com/ozoncp/ocp-request-api/internal/metrics"
 	"github.com/ozoncp/ocp-request-api/internal/models"
 	"github.com/ozoncp/ocp-request-api/internal/producer"
@@ -13,6 +13,8 @@ import (
 	"github.com/ozoncp/ocp-request-api/internal/utils"
 	desc "github.com/ozoncp/ocp-request-api/pkg/ocp-request-api"
 	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
@@ -22,7 +24,7 @@ func NewRequestApi(r repository.Repo,
 	batchSize uint,
 	metricsReporter metrics.MetricsReporter,
 	producer producer.Producer,
-	tracer opentracing.Tracer,
+	tracer trace.Tracer,
 	searcher search.Searcher,
 ) *RequestAPI {
 	return &RequestAPI{
@@ -35,13 +37,17 @@ func NewRequestApi(r repository.Repo,
 	}
 }
 
+// RequestAPI implements OcpRequestApiServer.
+// Events of successful changes are written by the repository into the outbox, so handlers send only
+// read and failure events directly.
+// Calls are logged, traced and counted by interceptors, see internal/interceptors.
 type RequestAPI struct {
 	desc.UnimplementedOcpRequestApiServer
 	repo      repository.Repo
 	batchSize uint // batch size for multi create
 	metrics   metrics.MetricsReporter
 	producer  producer.Producer
-	tracer    opentracing.Tracer
+	tracer    trace.Tracer
 	searcher  search.Searcher
 }
 
@@ -51,11 +57,10 @@ type validator interface {
 
 // ListRequestV1 returns a list of user Requests
 func (r *RequestAPI) ListRequestV1(ctx context.Context, req *desc.ListRequestsV1Request) (*desc.ListRequestsV1Response, error) {
-	log.Printf("Got list request: %v", req)
-	span, ctx := opentracing.StartSpanFromContext(ctx, "ListRequestV1")
-	defer span.Finish()
-
-	if err := r.validateAndSendErrorEvent(ctx, req, producer.ReadEvent); err != nil {
+	if err := r.validateAndSendErrorEvent(ctx, req, producer.ListEvent); err != nil {
+		return nil, err
+	}
+	if err := r.authorizeAndSendErrorEvent(ctx, producer.ListEvent, authz.Read); err != nil {
 		return nil, err
 	}
 	var (
@@ -70,19 +75,16 @@ func (r *RequestAPI) ListRequestV1(ctx context.Context, req *desc.ListRequestsV1
 	}
 
 	if err != nil {
-		log.Error().
+		log.Ctx(ctx).Error().
 			Err(err).
-			Str("endpoint", "ListRequestV1").
 			Uint64("limit", req.Limit).
 			Uint64("offset", req.Offset).
 			Msgf("Failed to list requests")
-		r.producer.Send(producer.NewEvent(ctx, 0, producer.ReadEvent, err))
+		r.producer.Send(producer.NewListEvent(ctx, nil, err))
 		return nil, err
 	}
 
 	ret := make([]*desc.Request, 0, len(requests))
-	eventMsgs := make([]producer.EventMsg, 0, len(requests))
-
 	for _, req := range requests {
 		ret = append(ret, &desc.Request{
 			Id:     req.Id,
@@ -90,10 +92,9 @@ func (r *RequestAPI) ListRequestV1(ctx context.Context, req *desc.ListRequestsV1
 			Type:   req.Type,
 			Text:   req.Text,
 		})
-		eventMsgs = append(eventMsgs, producer.NewEvent(ctx, req.Id, producer.ReadEvent, nil))
-		r.producer.Send(eventMsgs...)
-
 	}
+	// event policy of the producer decides whether the call is reported with one event or per request
+	r.producer.Send(producer.NewListEvent(ctx, requests, nil))
 	r.metrics.IncList(1, "ListRequestV1")
 	return &desc.ListRequestsV1Response{
 		Requests: ret,
@@ -102,28 +103,26 @@ func (r *RequestAPI) ListRequestV1(ctx context.Context, req *desc.ListRequestsV1
 
 // DescribeRequestV1 returns detailed Request information by its ID
 func (r *RequestAPI) DescribeRequestV1(ctx context.Context, req *desc.DescribeRequestV1Request) (*desc.DescribeRequestV1Response, error) {
-	log.Printf("Got describe request: %v", req)
-	span, ctx := opentracing.StartSpanFromContext(ctx, "DescribeRequestV1")
-	defer span.Finish()
-
 	if err := r.validateAndSendErrorEvent(ctx, req, producer.ReadEvent); err != nil {
 		return nil, err
 	}
+	if err := r.authorizeAndSendErrorEvent(ctx, producer.ReadEvent, authz.Read); err != nil {
+		return nil, err
+	}
 
 	ret, err := r.repo.Describe(ctx, req.RequestId)
 
 	if errors.Is(err, repository.NotFound) {
 		return nil, status.Error(codes.NotFound, err.Error())
 	} else if err != nil {
-		log.Error().
-			Str("endpoint", "DescribeRequestV1").
+		log.Ctx(ctx).Error().
 			Uint64("request_id", req.RequestId).
 			Err(err).
 			Msgf("Failed to read request")
 		return nil, err
 	}
 
-	r.producer.Send(producer.NewEvent(ctx, req.RequestId, producer.ReadEvent, err))
+	r.producer.Send(producer.NewEvent(ctx, req.RequestId, producer.ReadEvent, producer.Snapshot{After: ret}, err))
 	r.metrics.IncRead(1, "DescribeRequestV1")
 
 	return &desc.DescribeRequestV1Response{
@@ -139,13 +138,16 @@ func (r *RequestAPI) DescribeRequestV1(ctx context.Context, req *desc.DescribeRe
 
 // CreateRequestV1  Creates new Request and returns its new ID
 func (r *RequestAPI) CreateRequestV1(ctx context.Context, req *desc.CreateRequestV1Request) (*desc.CreateRequestV1Response, error) {
-	log.Printf("Got create request: %v", req)
-	span, ctx := opentracing.StartSpanFromContext(ctx, "CreateRequestV1")
-	defer span.Finish()
-
 	if err := r.validateAndSendErrorEvent(ctx, req, producer.CreateEvent); err != nil {
 		return nil, err
 	}
+	if err := r.authorizeAndSendErrorEvent(ctx, producer.CreateEvent, authz.Create, req.UserId); err != nil {
+		return nil, err
+	}
+	key, err := r.idempotencyKeyAndSendErrorEvent(ctx, req.IdempotencyKey)
+	if err != nil {
+		return nil, err
+	}
 
 	newReq := models.NewRequest(
 		0,
@@ -153,17 +155,26 @@ func (r *RequestAPI) CreateRequestV1(ctx context.Context, req *desc.CreateReques
 		req.Type,
 		req.Text,
 	)
+
+	if key != "" {
+		ids, err := r.addOnce(ctx, idempotency.NewKey(ctx, "CreateRequestV1", key, req.UserId), newReq)
+		if err != nil {
+			return nil, err
+		}
+		return &desc.CreateRequestV1Response{
+			RequestId: ids[0],
+		}, nil
+	}
+
 	newId, err := r.repo.Add(ctx, newReq)
 
 	if err != nil {
-		log.Error().
-			Str("endpoint", "CreateRequestV1").
+		log.Ctx(ctx).Error().
 			Err(err).
 			Msgf("Failed to create request")
 		return nil, err
 	}
 
-	r.producer.Send(producer.NewEvent(ctx, newId, producer.CreateEvent, err))
 	r.metrics.IncCreate(1, "CreateRequestV1")
 	return &desc.CreateRequestV1Response{
 		RequestId: newId,
@@ -172,13 +183,16 @@ func (r *RequestAPI) CreateRequestV1(ctx context.Context, req *desc.CreateReques
 
 // MultiCreateRequestV1  Creates new Request and returns its new ID
 func (r *RequestAPI) MultiCreateRequestV1(ctx context.Context, req *desc.MultiCreateRequestV1Request) (*desc.MultiCreateRequestV1Response, error) {
-	log.Printf("Got multi create request: %v", req)
-	span, ctx := opentracing.StartSpanFromContext(ctx, "MultiCreateRequestV1")
-	defer span.Finish()
-
 	if err := r.validateAndSendErrorEvent(ctx, req, producer.CreateEvent); err != nil {
 		return nil, err
 	}
+	if err := r.authorizeAndSendErrorEvent(ctx, producer.CreateEvent, authz.Create, userIdsOf(req.Requests)...); err != nil {
+		return nil, err
+	}
+	key, err := r.idempotencyKeyAndSendErrorEvent(ctx, req.IdempotencyKey)
+	if err != nil {
+		return nil, err
+	}
 
 	toCreate := make([]models.Request, 0, len(req.Requests))
 
@@ -186,6 +200,17 @@ func (r *RequestAPI) MultiCreateRequestV1(ctx context.Context, req *desc.MultiCr
 		toCreate = append(toCreate, models.NewRequest(0, req.UserId, req.Type, req.Text))
 	}
 
+	// requests of an idempotent call are created by a single transaction reserving the key
+	if key != "" {
+		ids, err := r.addOnce(ctx, idempotency.NewKey(ctx, "MultiCreateRequestV1", key, userIdsOf(req.Requests)...), toCreate...)
+		if err != nil {
+			return nil, err
+		}
+		return &desc.MultiCreateRequestV1Response{
+			RequestIds: ids,
+		}, nil
+	}
+
 	newIds := make([]uint64, 0, len(req.Requests))
 
 	for _, batch := range utils.SplitToBulks(toCreate, r.batchSize) {
@@ -204,39 +229,35 @@ func (r *RequestAPI) MultiCreateRequestV1(ctx context.Context, req *desc.MultiCr
 
 // RemoveRequestV1  removes Request by its ID
 func (r *RequestAPI) RemoveRequestV1(ctx context.Context, req *desc.RemoveRequestV1Request) (*desc.RemoveRequestV1Response, error) {
-	log.Printf("Got remove request: %v", req)
-	span, ctx := opentracing.StartSpanFromContext(ctx, "RemoveRequestV1")
-	defer span.Finish()
-
 	if err := r.validateAndSendErrorEvent(ctx, req, producer.DeleteEvent); err != nil {
 		return nil, err
 	}
+	if err := r.authorizeAndSendErrorEvent(ctx, producer.DeleteEvent, authz.Remove); err != nil {
+		return nil, err
+	}
 
 	err := r.repo.Remove(ctx, req.RequestId)
 	if errors.Is(err, repository.NotFound) {
 		return nil, status.Error(codes.NotFound, "request does not exist")
 	} else if err != nil {
-		log.Error().
+		log.Ctx(ctx).Error().
 			Err(err).
 			Uint64("request_id", req.RequestId).
-			Str("endpoint", "RemoveRequestV1").
 			Msgf("Failed to remove request")
 		return nil, err
 	}
-	r.producer.Send(producer.NewEvent(ctx, req.RequestId, producer.DeleteEvent, err))
 	r.metrics.IncRemove(1, "RemoveRequestV1")
 	return &desc.RemoveRequestV1Response{}, nil
 }
 
 // UpdateRequestV1 updates request data
 func (r *RequestAPI) UpdateRequestV1(ctx context.Context, req *desc.UpdateRequestV1Request) (*desc.UpdateRequestV1Response, error) {
-	log.Printf("Got update request: %v", req)
-	span, ctx := opentracing.StartSpanFromContext(ctx, "UpdateRequestV1")
-	defer span.Finish()
-
 	if err := r.validateAndSendErrorEvent(ctx, req, producer.UpdateEvent); err != nil {
 		return nil, err
 	}
+	if err := r.authorizeAndSendErrorEvent(ctx, producer.UpdateEvent, authz.Update, req.UserId); err != nil {
+		return nil, err
+	}
 
 	err := r.repo.Update(
 		ctx, models.NewRequest(req.RequestId, req.UserId, req.Type, req.Text),
@@ -244,42 +265,88 @@ func (r *RequestAPI) UpdateRequestV1(ctx context.Context, req *desc.UpdateReques
 	if errors.Is(err, repository.NotFound) {
 		return nil, status.Error(codes.NotFound, "request does not exist")
 	} else if err != nil {
-		log.Error().
+		log.Ctx(ctx).Error().
 			Uint64("request_id", req.RequestId).
-			Str("endpoint", "UpdateRequestV1").
 			Err(err).
 			Msgf("Failed to update request")
 		return nil, err
 	}
 
-	r.producer.Send(producer.NewEvent(ctx, req.RequestId, producer.UpdateEvent, err))
 	r.metrics.IncUpdate(1, "UpdateRequestV1")
 	return &desc.UpdateRequestV1Response{}, nil
 }
 
 func (r *RequestAPI) validateAndSendErrorEvent(ctx context.Context, req validator, event producer.EventType) error {
 	if err := req.Validate(); err != nil {
-		r.producer.Send(producer.NewEvent(ctx, 0, event, err))
+		r.producer.Send(producer.NewEvent(ctx, 0, event, producer.NoSnapshot, err))
 		return status.Error(codes.InvalidArgument, err.Error())
 	}
 	return nil
 }
 
+// idempotencyKeyAndSendErrorEvent returns an idempotency key of a create call passed in a request `field`
+// or with metadata, an empty string if there is none
+func (r *RequestAPI) idempotencyKeyAndSendErrorEvent(ctx context.Context, field string) (string, error) {
+	key := idempotency.KeyFromRequest(ctx, field)
+	if err := idempotency.ValidateKey(key); err != nil {
+		r.producer.Send(producer.NewEvent(ctx, 0, producer.CreateEvent, producer.NoSnapshot, err))
+		return "", status.Error(codes.InvalidArgument, err.Error())
+	}
+	return key, nil
+}
+
+// addOnce creates `requests` unless they were created by an earlier call with the same idempotency `key`.
+// Returns ids of created requests or of requests created by the earlier call.
+func (r *RequestAPI) addOnce(ctx context.Context, key idempotency.Key, requests ...models.Request) ([]uint64, error) {
+	ids, created, err := r.repo.AddOnce(ctx, key, requests, r.batchSize)
+	if err != nil {
+		log.Ctx(ctx).Error().
+			Err(err).
+			Msgf("Failed to create requests with idempotency key")
+		r.producer.Send(producer.NewEvent(ctx, 0, producer.CreateEvent, producer.NoSnapshot, err))
+		return nil, err
+	}
+	if !created {
+		log.Ctx(ctx).Info().
+			Uints64("request_ids", ids).
+			Msgf("Repeated call with idempotency key")
+		return ids, nil
+	}
+	r.metrics.IncCreate(uint(len(ids)), key.Method)
+	return ids, nil
+}
+
+// authorizeAndSendErrorEvent checks that the caller is allowed to perform `action` on requests of `userIds`
+func (r *RequestAPI) authorizeAndSendErrorEvent(
+	ctx context.Context, event producer.EventType, action authz.Action, userIds ...uint64,
+) error {
+	if err := authz.Authorize(ctx, action, userIds...); err != nil {
+		r.producer.Send(producer.NewEvent(ctx, 0, event, producer.NoSnapshot, err))
+		return status.Error(codes.PermissionDenied, err.Error())
+	}
+	return nil
+}
+
+func userIdsOf(requests []*desc.CreateRequestV1Request) []uint64 {
+	userIds := make([]uint64, 0, len(requests))
+	for _, req := range requests {
+		userIds = append(userIds, req.UserId)
+	}
+	return userIds
+}
+
 func (r *RequestAPI) writeRequestsBatch(ctx context.Context, batch []models.Request) ([]uint64, error) {
-	childSpan, childCtx := opentracing.StartSpanFromContext(ctx, "MultiCreateRequestV1Batch")
-	childSpan.LogFields(traceLog.Int("batch_size", len(batch)))
-	defer childSpan.Finish()
+	childCtx, childSpan := r.tracer.Start(ctx, "MultiCreateRequestV1Batch",
+		trace.WithAttributes(attribute.Int("batch_size", len(batch))))
+	defer childSpan.End()
 
 	ids, err := r.repo.AddMany(childCtx, batch)
 	if err != nil {
-		log.Error().
+		log.Ctx(ctx).Error().
 			Err(err).
 			Msgf("Failed to save requests")
-		r.producer.Send(producer.NewEvent(ctx, 0, producer.CreateEvent, err))
+		r.producer.Send(producer.NewEvent(ctx, 0, producer.CreateEvent, producer.NoSnapshot, err))
 		return nil, err
 	}
-	for _, id := range ids {
-		r.producer.Send(producer.NewEvent(ctx, id, producer.CreateEvent, nil))
-	}
 	return ids, nil
 }
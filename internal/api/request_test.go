@@ -5,14 +5,19 @@ import (
 	"github.com/golang/mock/gomock"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
-	"github.com/opentracing/opentracing-go"
 	"github.com/ozoncp/ocp-request-api/internal/api"
+	"github.com/ozoncp/ocp-request-api/internal/idempotency"
+	"github.com/ozoncp/ocp-request-api/internal/identity"
 	"github.com/ozoncp/ocp-request-api/internal/mocks"
 	"github.com/ozoncp/ocp-request-api/internal/models"
+	"github.com/ozoncp/ocp-request-api/internal/producer"
 	"github.com/ozoncp/ocp-request-api/internal/repo"
 	desc "github.com/ozoncp/ocp-request-api/pkg/ocp-request-api"
+	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
+	"strings"
 )
 
 type AnyContextType struct {
@@ -29,6 +34,23 @@ func (c AnyContextType) String() string {
 	return "Asserts parameter is a context.Context type"
 }
 
+// expectListEvent asserts a single LIST event of `listed` requests is sent to `events`
+func expectListEvent(events producer.MemoryProducer, listed int) {
+	Expect(events.Events()).To(HaveLen(1))
+	message := events.Events()[0]
+	Expect(message.Event).To(Equal(desc.RequestAPIEvent_LIST))
+	Expect(message.Listed).To(HaveLen(listed))
+}
+
+// expectErrorEvents asserts `count` events of failed calls of `eventType` are sent to `events`
+func expectErrorEvents(events producer.MemoryProducer, eventType desc.RequestAPIEvent_EventType, count int) {
+	Expect(events.Events()).To(HaveLen(count))
+	for _, message := range events.Events() {
+		Expect(message.Event).To(Equal(eventType))
+		Expect(message.Error).ToNot(BeEmpty())
+	}
+}
+
 var _ = Describe("Flusher", func() {
 
 	var (
@@ -37,7 +59,7 @@ var _ = Describe("Flusher", func() {
 		mockCtrl     *gomock.Controller
 		ctx          context.Context
 		mockProm     *mocks.MockMetricsReporter
-		mockProducer *mocks.MockProducer
+		events       producer.MemoryProducer
 		mockSearcher *mocks.MockSearcher
 	)
 
@@ -47,7 +69,7 @@ var _ = Describe("Flusher", func() {
 		mockCtrl = gomock.NewController(GinkgoT())
 		mockRepo = mocks.NewMockRepo(mockCtrl)
 		mockProm = mocks.NewMockMetricsReporter(mockCtrl)
-		mockProducer = mocks.NewMockProducer(mockCtrl)
+		events = producer.NewMemoryProducer(100)
 		mockSearcher = mocks.NewMockSearcher(mockCtrl)
 		ctx = context.Background()
 	})
@@ -62,8 +84,8 @@ var _ = Describe("Flusher", func() {
 				mockRepo,
 				2,
 				mockProm,
-				mockProducer,
-				opentracing.NoopTracer{},
+				events,
+				trace.NewNoopTracerProvider().Tracer(""),
 				mockSearcher,
 			)
 			ctx = context.Background()
@@ -82,11 +104,6 @@ var _ = Describe("Flusher", func() {
 				MaxTimes(1).
 				MinTimes(1)
 
-			mockProducer.EXPECT().
-				Send(gomock.Any()).
-				MaxTimes(1).
-				MinTimes(1)
-
 			resp, err := requestApi.CreateRequestV1(
 				ctx, &desc.CreateRequestV1Request{UserId: 10, Type: 11, Text: "test"},
 			)
@@ -97,6 +114,8 @@ var _ = Describe("Flusher", func() {
 				}))
 
 			Expect(err).ToNot(HaveOccurred())
+			// events of changes are stored into the outbox by the repository
+			Expect(events.Events()).To(BeEmpty())
 		})
 
 		It("Add many requests with no error", func() {
@@ -151,11 +170,6 @@ var _ = Describe("Flusher", func() {
 				MaxTimes(1).
 				MinTimes(1)
 
-			mockProducer.EXPECT().
-				Send(gomock.Any()).
-				MaxTimes(3).
-				MinTimes(3)
-
 			resp, err := requestApi.MultiCreateRequestV1(
 				ctx, &desc.MultiCreateRequestV1Request{Requests: createRequests},
 			)
@@ -166,56 +180,110 @@ var _ = Describe("Flusher", func() {
 				}))
 
 			Expect(err).ToNot(HaveOccurred())
+			// events of changes are stored into the outbox by the repository
+			Expect(events.Events()).To(BeEmpty())
 		})
 
-		It("Add() params validation", func() {
-			mockProducer.EXPECT().
-				Send(gomock.Any()).
-				MaxTimes(1).
-				MinTimes(1)
+		It("Return the original id of a repeated create call", func() {
+			mockRepo.EXPECT().
+				AddOnce(
+					ctxType,
+					idempotency.Key{Method: "CreateRequestV1", Caller: "users:10", Value: "retry-1"},
+					[]models.Request{models.NewRequest(0, 10, 11, "test")},
+					uint(2),
+				).
+				Return([]uint64{19}, false, nil)
 
+			mockRepo.EXPECT().
+				Add(ctxType, gomock.Any()).
+				MaxTimes(0)
+
+			resp, err := requestApi.CreateRequestV1(
+				ctx, &desc.CreateRequestV1Request{UserId: 10, Type: 11, Text: "test", IdempotencyKey: "retry-1"},
+			)
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(resp).To(Equal(&desc.CreateRequestV1Response{RequestId: 19}))
+		})
+
+		It("Create requests once with an idempotency key of the caller from metadata", func() {
+			ctx = identity.NewContext(ctx, identity.Identity{Subject: "alice", Role: identity.Admin})
+			ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("idempotency-key", "retry-2"))
+			createRequests := []*desc.CreateRequestV1Request{
+				{UserId: 10, Type: 100, Text: "one"},
+				{UserId: 20, Type: 200, Text: "two"},
+				{UserId: 30, Type: 300, Text: "three"},
+			}
+
+			// all requests are created by a single call, which inserts them by batches of 2
+			mockRepo.EXPECT().
+				AddOnce(
+					ctxType,
+					idempotency.Key{Method: "MultiCreateRequestV1", Caller: "sub:alice", Value: "retry-2"},
+					gomock.Len(3),
+					uint(2),
+				).
+				Return([]uint64{5, 6, 7}, true, nil)
+
+			mockProm.EXPECT().
+				IncCreate(uint(3), "MultiCreateRequestV1")
+
+			resp, err := requestApi.MultiCreateRequestV1(
+				ctx, &desc.MultiCreateRequestV1Request{Requests: createRequests},
+			)
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(resp.RequestIds).To(Equal([]uint64{5, 6, 7}))
+		})
+
+		It("Reject too long idempotency keys passed with metadata", func() {
+			ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("idempotency-key", strings.Repeat("k", 129)))
+
+			mockRepo.EXPECT().
+				AddOnce(ctxType, gomock.Any(), gomock.Any(), gomock.Any()).
+				MaxTimes(0)
+
+			_, err := requestApi.MultiCreateRequestV1(
+				ctx, &desc.MultiCreateRequestV1Request{Requests: []*desc.CreateRequestV1Request{
+					{UserId: 10, Type: 100, Text: "one"},
+				}},
+			)
+
+			Expect(status.Code(err)).To(Equal(codes.InvalidArgument))
+			expectErrorEvents(events, desc.RequestAPIEvent_CREATE, 1)
+		})
+
+		It("Add() params validation", func() {
 			_, err := requestApi.CreateRequestV1(
 				ctx, &desc.CreateRequestV1Request{UserId: 0},
 			)
 
 			Expect(err.Error()).To(Equal("rpc error: code = InvalidArgument desc = invalid CreateRequestV1Request.UserId: value must be greater than 0"))
+			expectErrorEvents(events, desc.RequestAPIEvent_CREATE, 1)
 
 		})
 
 		It("Remove() params validation", func() {
-			mockProducer.EXPECT().
-				Send(gomock.Any()).
-				MaxTimes(1).
-				MinTimes(1)
-
 			_, err := requestApi.RemoveRequestV1(
 				ctx, &desc.RemoveRequestV1Request{},
 			)
 
 			Expect(err.Error()).To(Equal("rpc error: code = InvalidArgument desc = invalid RemoveRequestV1Request.RequestId: value must be greater than 0"))
+			expectErrorEvents(events, desc.RequestAPIEvent_DELETE, 1)
 
 		})
 
 		It("Describe() params validation", func() {
-			mockProducer.EXPECT().
-				Send(gomock.Any()).
-				MaxTimes(1).
-				MinTimes(1)
-
 			_, err := requestApi.DescribeRequestV1(
 				ctx, &desc.DescribeRequestV1Request{},
 			)
 
 			Expect(err.Error()).To(Equal("rpc error: code = InvalidArgument desc = invalid DescribeRequestV1Request.RequestId: value must be greater than 0"))
+			expectErrorEvents(events, desc.RequestAPIEvent_READ, 1)
 
 		})
 
 		It("List() params validation", func() {
-			mockProducer.EXPECT().
-				Send(gomock.Any()).
-				MaxTimes(2).
-				MinTimes(2)
-
 			_, err := requestApi.ListRequestV1(
 				ctx, &desc.ListRequestsV1Request{Limit: 0},
 			)
@@ -227,7 +295,7 @@ var _ = Describe("Flusher", func() {
 			)
 
 			Expect(err.Error()).To(Equal("rpc error: code = InvalidArgument desc = invalid ListRequestsV1Request.Limit: value must be inside range (0, 10000]"))
-
+			expectErrorEvents(events, desc.RequestAPIEvent_LIST, 2)
 		})
 
 		It("List requests with no error", func() {
@@ -248,11 +316,6 @@ var _ = Describe("Flusher", func() {
 				MaxTimes(1).
 				MinTimes(1)
 
-			mockProducer.EXPECT().
-				Send(gomock.Any()).
-				MaxTimes(3).
-				MinTimes(3)
-
 			resp, err := requestApi.ListRequestV1(
 				ctx, &desc.ListRequestsV1Request{
 					Offset: offset, Limit: limit,
@@ -275,6 +338,7 @@ var _ = Describe("Flusher", func() {
 				}))
 
 			Expect(err).ToNot(HaveOccurred())
+			expectListEvent(events, len(requests))
 		})
 
 		It("Full text search", func() {
@@ -302,11 +366,6 @@ var _ = Describe("Flusher", func() {
 				MaxTimes(1).
 				MinTimes(1)
 
-			mockProducer.EXPECT().
-				Send(gomock.Any()).
-				MaxTimes(3).
-				MinTimes(3)
-
 			resp, err := requestApi.ListRequestV1(
 				ctx, &desc.ListRequestsV1Request{
 					Offset: offset, Limit: limit, SearchQuery: searchQuery,
@@ -329,6 +388,7 @@ var _ = Describe("Flusher", func() {
 				}))
 
 			Expect(err).ToNot(HaveOccurred())
+			expectListEvent(events, len(requests))
 		})
 
 		It("Update existing request", func() {
@@ -344,11 +404,6 @@ var _ = Describe("Flusher", func() {
 				MaxTimes(1).
 				MinTimes(1)
 
-			mockProducer.EXPECT().
-				Send(gomock.Any()).
-				MaxTimes(1).
-				MinTimes(1)
-
 			resp, err := requestApi.UpdateRequestV1(
 				ctx, &desc.UpdateRequestV1Request{
 					RequestId: req.Id,
@@ -361,6 +416,8 @@ var _ = Describe("Flusher", func() {
 				To(Equal(&desc.UpdateRequestV1Response{}))
 
 			Expect(err).ToNot(HaveOccurred())
+			// events of changes are stored into the outbox by the repository
+			Expect(events.Events()).To(BeEmpty())
 		})
 
 		It("Remove non-existing request with no errors", func() {
@@ -391,11 +448,6 @@ var _ = Describe("Flusher", func() {
 				MaxTimes(1).
 				MinTimes(1)
 
-			mockProducer.EXPECT().
-				Send(gomock.Any()).
-				MaxTimes(1).
-				MinTimes(1)
-
 			resp, err := requestApi.RemoveRequestV1(
 				ctx, &desc.RemoveRequestV1Request{
 					RequestId: requestId,
@@ -405,7 +457,26 @@ var _ = Describe("Flusher", func() {
 				To(Equal(&desc.RemoveRequestV1Response{}))
 
 			Expect(err).ToNot(HaveOccurred())
+			// events of changes are stored into the outbox by the repository
+			Expect(events.Events()).To(BeEmpty())
 		})
+		It("Deny students removing requests and creating requests of other users", func() {
+			ctx = identity.NewContext(ctx, identity.Identity{Subject: "s", Role: identity.Student, UserId: 10})
+
+			mockRepo.EXPECT().Remove(ctxType, gomock.Any()).MaxTimes(0)
+			mockRepo.EXPECT().Add(ctxType, gomock.Any()).MaxTimes(0)
+			_, err := requestApi.RemoveRequestV1(ctx, &desc.RemoveRequestV1Request{RequestId: 19})
+			Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+
+			_, err = requestApi.CreateRequestV1(ctx, &desc.CreateRequestV1Request{UserId: 20, Type: 1, Text: "other"})
+			Expect(status.Code(err)).To(Equal(codes.PermissionDenied))
+
+			// failures are reported with events
+			Expect(events.Events()).To(HaveLen(2))
+			Expect(events.Events()[0].Event).To(Equal(desc.RequestAPIEvent_DELETE))
+			Expect(events.Events()[1].Event).To(Equal(desc.RequestAPIEvent_CREATE))
+		})
+
 		It("Remove non-existing request with no errors", func() {
 			requestId := uint64(19)
 			mockRepo.EXPECT().
@@ -439,11 +510,6 @@ var _ = Describe("Flusher", func() {
 				MaxTimes(1).
 				MinTimes(1)
 
-			mockProducer.EXPECT().
-				Send(gomock.Any()).
-				MaxTimes(1).
-				MinTimes(1)
-
 			resp, err := requestApi.DescribeRequestV1(
 				ctx, &desc.DescribeRequestV1Request{
 					RequestId: req.Id,
@@ -461,6 +527,10 @@ var _ = Describe("Flusher", func() {
 				}))
 
 			Expect(err).ToNot(HaveOccurred())
+			Expect(events.Events()).To(HaveLen(1))
+			Expect(events.Events()[0].Event).To(Equal(desc.RequestAPIEvent_READ))
+			Expect(events.Events()[0].RequestId).To(Equal(req.Id))
+			Expect(events.Events()[0].After).To(Equal(resp.Request))
 		})
 
 		It("Describe non-existing request", func() {
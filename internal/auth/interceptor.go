@@ -0,0 +1,70 @@
+package auth
+
+import (
+	"context"
+	"github.com/ozoncp/ocp-request-api/internal/identity"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"strings"
+)
+
+// AuthorizationMetadataKey is a gRPC metadata key carrying a bearer token.
+// The gateway passes Authorization HTTP header with it.
+const AuthorizationMetadataKey = "authorization"
+
+// UnaryServerInterceptor rejects calls without a valid bearer token with Unauthenticated code.
+// Identity of the caller is put into the context of the call, see identity.FromContext.
+// Calls of `publicServices` (full service names, e.g. grpc.health.v1.Health) are passed without authentication.
+func UnaryServerInterceptor(verifier Verifier, publicServices ...string) grpc.UnaryServerInterceptor {
+	public := make(map[string]bool, len(publicServices))
+	for _, service := range publicServices {
+		public[service] = true
+	}
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if public[serviceName(info.FullMethod)] {
+			return handler(ctx, req)
+		}
+		token, ok := bearerToken(ctx)
+		if !ok {
+			return nil, status.Error(codes.Unauthenticated, "bearer token is required")
+		}
+		caller, err := verifier.Verify(token)
+		if err != nil {
+			log.Info().
+				Str("method", info.FullMethod).
+				Err(err).
+				Msgf("Rejected invalid token")
+			return nil, status.Error(codes.Unauthenticated, "invalid bearer token")
+		}
+		return handler(identity.NewContext(ctx, caller), req)
+	}
+}
+
+// serviceName returns a service part of "/package.Service/Method" name
+func serviceName(fullMethod string) string {
+	fullMethod = strings.TrimPrefix(fullMethod, "/")
+	if i := strings.LastIndex(fullMethod, "/"); i >= 0 {
+		return fullMethod[:i]
+	}
+	return fullMethod
+}
+
+// bearerToken returns a token from "authorization: Bearer <token>" metadata of the incoming call
+func bearerToken(ctx context.Context) (string, bool) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return "", false
+	}
+	values := md.Get(AuthorizationMetadataKey)
+	if len(values) == 0 {
+		return "", false
+	}
+	parts := strings.SplitN(values[0], " ", 2)
+	if len(parts) != 2 || !strings.EqualFold(parts[0], "Bearer") || parts[1] == "" {
+		return "", false
+	}
+	return parts[1], true
+}
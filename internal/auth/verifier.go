@@ -0,0 +1,83 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/ozoncp/ocp-request-api/internal/identity"
+)
+
+// Verifier validates bearer tokens
+type Verifier interface {
+	// Verify checks signature and claims of `token` and returns identity of its bearer
+	Verify(token string) (identity.Identity, error)
+}
+
+// NewVerifier creates a Verifier of JWT signed with one of `keys`.
+// Token issuer and audience are checked if `issuer` and `audience` are not empty.
+func NewVerifier(keys Keys, issuer, audience string) Verifier {
+	return &verifier{
+		keys:     keys,
+		issuer:   issuer,
+		audience: audience,
+	}
+}
+
+// claims are JWT claims identifying a caller
+type claims struct {
+	jwt.RegisteredClaims
+	Role   string `json:"role"`
+	UserId uint64 `json:"user_id"`
+}
+
+type verifier struct {
+	keys     Keys
+	issuer   string
+	audience string
+}
+
+func (v *verifier) Verify(token string) (identity.Identity, error) {
+	claims := &claims{}
+	// Valid() of the claims checks exp, nbf and iat
+	if _, err := jwt.ParseWithClaims(token, claims, v.key); err != nil {
+		return identity.Identity{}, err
+	}
+
+	if v.issuer != "" && !claims.VerifyIssuer(v.issuer, true) {
+		return identity.Identity{}, fmt.Errorf("unexpected issuer %q", claims.Issuer)
+	}
+	if v.audience != "" && !claims.VerifyAudience(v.audience, true) {
+		return identity.Identity{}, errors.New("token is issued for another audience")
+	}
+	if claims.Subject == "" {
+		return identity.Identity{}, errors.New("token has no subject")
+	}
+	role, err := identity.ParseRole(claims.Role)
+	if err != nil {
+		return identity.Identity{}, err
+	}
+	if role == identity.Student && claims.UserId == 0 {
+		return identity.Identity{}, errors.New("student token has no user_id")
+	}
+	return identity.Identity{Subject: claims.Subject, Role: role, UserId: claims.UserId}, nil
+}
+
+// key returns a key verifying `token` signature
+func (v *verifier) key(token *jwt.Token) (interface{}, error) {
+	switch token.Method.(type) {
+	case *jwt.SigningMethodHMAC:
+		if len(v.keys.HMACSecret) == 0 {
+			return nil, errors.New("HMAC signed tokens are not accepted")
+		}
+		return v.keys.HMACSecret, nil
+	case *jwt.SigningMethodRSA:
+		kid, _ := token.Header["kid"].(string)
+		key, ok := v.keys.RSA[kid]
+		if !ok {
+			return nil, fmt.Errorf("unknown key id %q", kid)
+		}
+		return key, nil
+	default:
+		return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+	}
+}
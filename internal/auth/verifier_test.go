@@ -0,0 +1,149 @@
+package auth_test
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/golang-jwt/jwt/v4"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/ozoncp/ocp-request-api/internal/auth"
+	"github.com/ozoncp/ocp-request-api/internal/identity"
+	"math/big"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var secret = []byte("secret")
+
+type testClaims struct {
+	jwt.RegisteredClaims
+	Role   string `json:"role,omitempty"`
+	UserId uint64 `json:"user_id,omitempty"`
+}
+
+// signed returns a token with `claims` signed by `key` using `method`
+func signed(method jwt.SigningMethod, key interface{}, kid string, claims testClaims) string {
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(key)
+	Expect(err).ToNot(HaveOccurred())
+	return signed
+}
+
+func validClaims() testClaims {
+	return testClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   "student",
+			Issuer:    "ocp-auth",
+			Audience:  jwt.ClaimStrings{"ocp-request-api"},
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+		Role:   "student",
+		UserId: 10,
+	}
+}
+
+var _ = Describe("Verifier", func() {
+
+	var rsaKey *rsa.PrivateKey
+
+	BeforeEach(func() {
+		var err error
+		rsaKey, err = rsa.GenerateKey(rand.Reader, 2048)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("Accepts HMAC signed tokens", func() {
+		verifier := auth.NewVerifier(auth.Keys{HMACSecret: secret}, "ocp-auth", "ocp-request-api")
+
+		caller, err := verifier.Verify(signed(jwt.SigningMethodHS256, secret, "", validClaims()))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(caller).To(Equal(identity.Identity{Subject: "student", Role: identity.Student, UserId: 10}))
+	})
+
+	It("Rejects tokens with invalid signature or claims", func() {
+		verifier := auth.NewVerifier(auth.Keys{HMACSecret: secret}, "ocp-auth", "ocp-request-api")
+
+		_, err := verifier.Verify(signed(jwt.SigningMethodHS256, []byte("guess"), "", validClaims()))
+		Expect(err).To(HaveOccurred())
+
+		expired := validClaims()
+		expired.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Minute))
+		_, err = verifier.Verify(signed(jwt.SigningMethodHS256, secret, "", expired))
+		Expect(err).To(HaveOccurred())
+
+		foreign := validClaims()
+		foreign.Audience = jwt.ClaimStrings{"another-api"}
+		_, err = verifier.Verify(signed(jwt.SigningMethodHS256, secret, "", foreign))
+		Expect(err).To(HaveOccurred())
+
+		_, err = verifier.Verify(signed(jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, "", validClaims()))
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("Requires a known role and user id of students", func() {
+		verifier := auth.NewVerifier(auth.Keys{HMACSecret: secret}, "", "")
+
+		operator := validClaims()
+		operator.Role, operator.UserId = "operator", 0
+		caller, err := verifier.Verify(signed(jwt.SigningMethodHS256, secret, "", operator))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(caller.Role).To(Equal(identity.Operator))
+
+		unknown := validClaims()
+		unknown.Role = "teacher"
+		_, err = verifier.Verify(signed(jwt.SigningMethodHS256, secret, "", unknown))
+		Expect(err).To(HaveOccurred())
+
+		anonymous := validClaims()
+		anonymous.UserId = 0
+		_, err = verifier.Verify(signed(jwt.SigningMethodHS256, secret, "", anonymous))
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("Accepts RSA signed tokens by key id", func() {
+		verifier := auth.NewVerifier(auth.Keys{RSA: map[string]*rsa.PublicKey{"k1": &rsaKey.PublicKey}}, "", "")
+
+		caller, err := verifier.Verify(signed(jwt.SigningMethodRS256, rsaKey, "k1", validClaims()))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(caller.Subject).To(Equal("student"))
+
+		_, err = verifier.Verify(signed(jwt.SigningMethodRS256, rsaKey, "k2", validClaims()))
+		Expect(err).To(HaveOccurred())
+
+		// HMAC tokens must not be verified with a public key as a secret
+		_, err = verifier.Verify(signed(jwt.SigningMethodHS256, secret, "k1", validClaims()))
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("Loads RSA keys from JWKS file", func() {
+		encode := func(data []byte) string {
+			return base64.RawURLEncoding.EncodeToString(data)
+		}
+		jwks, err := json.Marshal(map[string]interface{}{
+			"keys": []map[string]string{
+				{"kty": "oct", "kid": "shared", "k": encode(secret)},
+				{
+					"kty": "RSA", "kid": "k1", "use": "sig",
+					"n": encode(rsaKey.N.Bytes()), "e": encode(big.NewInt(int64(rsaKey.E)).Bytes()),
+				},
+			},
+		})
+		Expect(err).ToNot(HaveOccurred())
+		dir, err := os.MkdirTemp("", "jwks")
+		Expect(err).ToNot(HaveOccurred())
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "jwks.json")
+		Expect(os.WriteFile(path, jwks, 0600)).To(Succeed())
+
+		keys, err := auth.LoadJWKS(path)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(keys).To(HaveLen(1))
+		Expect(keys["k1"].Equal(&rsaKey.PublicKey)).To(BeTrue())
+	})
+})
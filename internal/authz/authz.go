@@ -0,0 +1,56 @@
+package authz
+
+import (
+	"context"
+	"errors"
+	"github.com/ozoncp/ocp-request-api/internal/identity"
+)
+
+// ErrPermissionDenied is returned if the caller is not allowed to perform an action
+var ErrPermissionDenied = errors.New("permission denied")
+
+// Action is an operation on requests
+type Action string
+
+const (
+	Read   Action = "read"
+	Create Action = "create"
+	Update Action = "update"
+	Remove Action = "remove"
+)
+
+// allowed lists actions of every role. Students are also restricted to own requests, see RestrictedTo.
+var allowed = map[identity.Role]map[Action]bool{
+	identity.Student:  {Read: true, Create: true, Update: true},
+	identity.Operator: {Read: true, Create: true, Update: true},
+	identity.Admin:    {Read: true, Create: true, Update: true, Remove: true},
+}
+
+// Authorize checks that the caller is allowed to perform `action` on requests of `userIds`.
+// Calls without identity in `ctx` are not restricted, which is the case of disabled authentication and internal calls.
+func Authorize(ctx context.Context, action Action, userIds ...uint64) error {
+	caller, ok := identity.FromContext(ctx)
+	if !ok {
+		return nil
+	}
+	if !allowed[caller.Role][action] {
+		return ErrPermissionDenied
+	}
+	if ownUserId, restricted := RestrictedTo(ctx); restricted {
+		for _, userId := range userIds {
+			if userId != ownUserId {
+				return ErrPermissionDenied
+			}
+		}
+	}
+	return nil
+}
+
+// RestrictedTo returns id of the user whose requests the caller is restricted to. Returns false if there is no restriction.
+func RestrictedTo(ctx context.Context) (uint64, bool) {
+	caller, ok := identity.FromContext(ctx)
+	if !ok || caller.Role != identity.Student {
+		return 0, false
+	}
+	return caller.UserId, true
+}
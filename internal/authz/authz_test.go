@@ -0,0 +1,55 @@
+package authz_test
+
+import (
+	"context"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/ozoncp/ocp-request-api/internal/authz"
+	"github.com/ozoncp/ocp-request-api/internal/identity"
+)
+
+var _ = Describe("Authorize", func() {
+
+	as := func(role identity.Role, userId uint64) context.Context {
+		return identity.NewContext(context.Background(), identity.Identity{Subject: "caller", Role: role, UserId: userId})
+	}
+
+	It("Restricts students to own requests", func() {
+		ctx := as(identity.Student, 10)
+
+		Expect(authz.Authorize(ctx, authz.Read)).To(Succeed())
+		Expect(authz.Authorize(ctx, authz.Create, 10, 10)).To(Succeed())
+		Expect(authz.Authorize(ctx, authz.Create, 10, 20)).To(MatchError(authz.ErrPermissionDenied))
+		Expect(authz.Authorize(ctx, authz.Update, 20)).To(MatchError(authz.ErrPermissionDenied))
+		Expect(authz.Authorize(ctx, authz.Remove)).To(MatchError(authz.ErrPermissionDenied))
+
+		userId, restricted := authz.RestrictedTo(ctx)
+		Expect(restricted).To(BeTrue())
+		Expect(userId).To(Equal(uint64(10)))
+	})
+
+	It("Allows operators to read and update requests of all users", func() {
+		ctx := as(identity.Operator, 0)
+
+		Expect(authz.Authorize(ctx, authz.Read)).To(Succeed())
+		Expect(authz.Authorize(ctx, authz.Update, 20)).To(Succeed())
+		Expect(authz.Authorize(ctx, authz.Remove)).To(MatchError(authz.ErrPermissionDenied))
+
+		_, restricted := authz.RestrictedTo(ctx)
+		Expect(restricted).To(BeFalse())
+	})
+
+	It("Allows admins to remove requests", func() {
+		Expect(authz.Authorize(as(identity.Admin, 0), authz.Remove)).To(Succeed())
+	})
+
+	It("Does not restrict calls without identity", func() {
+		Expect(authz.Authorize(context.Background(), authz.Remove)).To(Succeed())
+		_, restricted := authz.RestrictedTo(context.Background())
+		Expect(restricted).To(BeFalse())
+	})
+
+	It("Denies callers without a known role", func() {
+		Expect(authz.Authorize(as("", 10), authz.Read)).To(MatchError(authz.ErrPermissionDenied))
+	})
+})
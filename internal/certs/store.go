@@ -0,0 +1,197 @@
+package certs
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"github.com/fsnotify/fsnotify"
+	"github.com/rs/zerolog/log"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Store keeps a certificate and a pool of client CA certificates loaded from files
+type Store interface {
+	// ServerConfig returns a TLS config of servers presenting the latest loaded certificate
+	ServerConfig() *tls.Config
+	// MutualServerConfig is ServerConfig also requiring clients to present a certificate signed
+	// by one of the latest loaded client CAs, if a client CA file is set
+	MutualServerConfig() *tls.Config
+	// ClientConfig returns a TLS config of clients presenting the latest loaded certificate
+	// to a server named `serverName`. The server certificate is verified with `rootCAs`, system roots if nil.
+	ClientConfig(serverName string, rootCAs *x509.CertPool) *tls.Config
+	// Reload reads the files again. Previously loaded certificates are kept on failure.
+	Reload() error
+	// Watch reloads the files whenever they change until `ctx` is done
+	Watch(ctx context.Context)
+}
+
+// NewStore creates a Store of a certificate from `certFile` and `keyFile` and client CAs from `clientCAFile`.
+// Client certificates are not required if `clientCAFile` is empty. Returns an error if the files can't be loaded.
+func NewStore(certFile, keyFile, clientCAFile string) (Store, error) {
+	s := &store{certFile: certFile, keyFile: keyFile, clientCAFile: clientCAFile}
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// LoadCAPool reads PEM encoded CA certificates from `path`
+func LoadCAPool(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA certificates: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no CA certificates found in %v", path)
+	}
+	return pool, nil
+}
+
+type store struct {
+	certFile     string
+	keyFile      string
+	clientCAFile string
+	lock         sync.RWMutex // guards cert and clientCAs
+	cert         *tls.Certificate
+	clientCAs    *x509.CertPool
+}
+
+func (s *store) ServerConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return s.certificate(), nil
+		},
+	}
+}
+
+func (s *store) MutualServerConfig() *tls.Config {
+	cfg := s.ServerConfig()
+	if s.clientCAFile != "" {
+		// the pool may be reloaded, so client certificates are verified against the latest one
+		cfg.ClientAuth = tls.RequireAnyClientCert
+		cfg.VerifyPeerCertificate = s.verifyClientCertificate
+	}
+	return cfg
+}
+
+func (s *store) ClientConfig(serverName string, rootCAs *x509.CertPool) *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: serverName,
+		RootCAs:    rootCAs,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return s.certificate(), nil
+		},
+	}
+}
+
+func (s *store) Reload() error {
+	cert, err := tls.LoadX509KeyPair(s.certFile, s.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load certificate: %w", err)
+	}
+	var clientCAs *x509.CertPool
+	if s.clientCAFile != "" {
+		if clientCAs, err = LoadCAPool(s.clientCAFile); err != nil {
+			return err
+		}
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.cert = &cert
+	s.clientCAs = clientCAs
+	return nil
+}
+
+func (s *store) Watch(ctx context.Context) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.Error().Err(err).Msg("failed to watch certificates, they won't be reloaded")
+		return
+	}
+	defer watcher.Close()
+
+	// directories are watched, as files are usually replaced rather than written, e.g. by Kubernetes
+	for _, dir := range s.dirs() {
+		if err := watcher.Add(dir); err != nil {
+			log.Error().Err(err).Msgf("failed to watch %v, certificates won't be reloaded", dir)
+			return
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-watcher.Events:
+			if event.Op == fsnotify.Chmod {
+				continue
+			}
+			if err := s.Reload(); err != nil {
+				// files may be written partially at the moment, the next event reloads them
+				log.Warn().Err(err).Msgf("failed to reload certificates after %v", event)
+				continue
+			}
+			log.Info().Msgf("Reloaded certificates after %v", event)
+		case err := <-watcher.Errors:
+			log.Error().Err(err).Msg("certificates watch failed")
+		}
+	}
+}
+
+func (s *store) dirs() []string {
+	seen := map[string]bool{}
+	var dirs []string
+	for _, file := range []string{s.certFile, s.keyFile, s.clientCAFile} {
+		if file == "" {
+			continue
+		}
+		if dir := filepath.Dir(file); !seen[dir] {
+			seen[dir] = true
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
+
+func (s *store) certificate() *tls.Certificate {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.cert
+}
+
+func (s *store) verifyClientCertificate(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+	if len(rawCerts) == 0 {
+		return errors.New("client certificate is required")
+	}
+	certs := make([]*x509.Certificate, 0, len(rawCerts))
+	for _, raw := range rawCerts {
+		cert, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return fmt.Errorf("malformed client certificate: %w", err)
+		}
+		certs = append(certs, cert)
+	}
+
+	s.lock.RLock()
+	roots := s.clientCAs
+	s.lock.RUnlock()
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := certs[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	return err
+}
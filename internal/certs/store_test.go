@@ -0,0 +1,209 @@
+package certs_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/ozoncp/ocp-request-api/internal/certs"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// authority issues certificates for tests
+type authority struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newAuthority() authority {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).ToNot(HaveOccurred())
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	Expect(err).ToNot(HaveOccurred())
+	cert, err := x509.ParseCertificate(der)
+	Expect(err).ToNot(HaveOccurred())
+	return authority{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+func (a authority) pool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(a.cert)
+	return pool
+}
+
+// issue returns PEM encoded certificate and key for localhost named `name`
+func (a authority) issue(name string) ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).ToNot(HaveOccurred())
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, a.cert, &key.PublicKey, a.key)
+	Expect(err).ToNot(HaveOccurred())
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	Expect(err).ToNot(HaveOccurred())
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+}
+
+// handshake connects a client to a server and returns a name of the server certificate
+func handshake(server, client *tls.Config) (string, error) {
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", server)
+	Expect(err).ToNot(HaveOccurred())
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(time.Second))
+		conn.(*tls.Conn).Handshake()
+		conn.Read(make([]byte, 1))
+	}()
+
+	conn, err := tls.Dial("tcp", listener.Addr().String(), client)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	// TLS 1.3 servers reject client certificates after clients complete their part of the handshake
+	conn.SetDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Write([]byte{0}); err != nil {
+		return "", err
+	}
+	if _, err := conn.Read(make([]byte, 1)); err != nil && err != io.EOF {
+		return "", err
+	}
+	return conn.ConnectionState().PeerCertificates[0].Subject.CommonName, nil
+}
+
+var _ = Describe("Store", func() {
+
+	var (
+		ca                             authority
+		dir, certFile, keyFile, caFile string
+		writeCertificate               func(name string)
+	)
+
+	BeforeEach(func() {
+		ca = newAuthority()
+		var err error
+		dir, err = os.MkdirTemp("", "certs")
+		Expect(err).ToNot(HaveOccurred())
+		certFile = filepath.Join(dir, "tls.crt")
+		keyFile = filepath.Join(dir, "tls.key")
+		caFile = filepath.Join(dir, "ca.crt")
+		Expect(os.WriteFile(caFile, ca.pem, 0600)).To(Succeed())
+
+		writeCertificate = func(name string) {
+			cert, key := ca.issue(name)
+			Expect(os.WriteFile(keyFile, key, 0600)).To(Succeed())
+			Expect(os.WriteFile(certFile, cert, 0600)).To(Succeed())
+		}
+		writeCertificate("first")
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("Fails to load missing files", func() {
+		_, err := certs.NewStore(filepath.Join(dir, "missing.crt"), keyFile, "")
+		Expect(err).To(HaveOccurred())
+		_, err = certs.NewStore(certFile, keyFile, filepath.Join(dir, "missing.crt"))
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("Serves the reloaded certificate", func() {
+		store, err := certs.NewStore(certFile, keyFile, "")
+		Expect(err).ToNot(HaveOccurred())
+		client := &tls.Config{ServerName: "localhost", RootCAs: ca.pool()}
+
+		Expect(handshake(store.ServerConfig(), client)).To(Equal("first"))
+
+		writeCertificate("second")
+		Expect(store.Reload()).To(Succeed())
+		Expect(handshake(store.ServerConfig(), client)).To(Equal("second"))
+	})
+
+	It("Keeps the certificate if files are broken", func() {
+		store, err := certs.NewStore(certFile, keyFile, "")
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(os.WriteFile(certFile, []byte("broken"), 0600)).To(Succeed())
+		Expect(store.Reload()).ToNot(Succeed())
+		Expect(handshake(store.ServerConfig(), &tls.Config{ServerName: "localhost", RootCAs: ca.pool()})).
+			To(Equal("first"))
+	})
+
+	It("Requires client certificates signed by client CAs", func() {
+		store, err := certs.NewStore(certFile, keyFile, caFile)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(handshake(store.MutualServerConfig(), store.ClientConfig("localhost", ca.pool()))).To(Equal("first"))
+
+		_, err = handshake(store.MutualServerConfig(), &tls.Config{ServerName: "localhost", RootCAs: ca.pool()})
+		Expect(err).To(HaveOccurred())
+
+		other := newAuthority()
+		otherCert, otherKey := other.issue("stranger")
+		stranger, err := tls.X509KeyPair(otherCert, otherKey)
+		Expect(err).ToNot(HaveOccurred())
+		_, err = handshake(store.MutualServerConfig(), &tls.Config{
+			ServerName: "localhost", RootCAs: ca.pool(), Certificates: []tls.Certificate{stranger},
+		})
+		Expect(err).To(HaveOccurred())
+
+		Expect(handshake(store.ServerConfig(), &tls.Config{ServerName: "localhost", RootCAs: ca.pool()})).
+			To(Equal("first"))
+	})
+
+	It("Reloads files when they change", func() {
+		store, err := certs.NewStore(certFile, keyFile, "")
+		Expect(err).ToNot(HaveOccurred())
+		ctx, cancel := context.WithCancel(context.Background())
+		watched := make(chan struct{})
+		go func() {
+			defer close(watched)
+			store.Watch(ctx)
+		}()
+		defer func() {
+			cancel()
+			<-watched
+		}()
+
+		client := &tls.Config{ServerName: "localhost", RootCAs: ca.pool()}
+		Eventually(func() string {
+			writeCertificate("second")
+			name, _ := handshake(store.ServerConfig(), client)
+			return name
+		}, "3s", "100ms").Should(Equal("second"))
+	})
+})
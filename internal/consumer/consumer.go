@@ -0,0 +1,119 @@
+package consumer
+
+import (
+	"context"
+	"github.com/Shopify/sarama"
+	"github.com/ozoncp/ocp-request-api/internal/producer"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/codes"
+	"sync"
+	"time"
+)
+
+// GroupHandler is a consumer group handler remembering a failure of its handlers.
+// sarama only logs errors returned by ConsumeClaim, so Run asks the handler whether the session failed.
+type GroupHandler interface {
+	sarama.ConsumerGroupHandler
+	// Failure returns an error a handler failed with during the last session, nil if there was none
+	Failure() error
+}
+
+// NewGroupHandler returns a consumer group handler that decodes RequestAPIEvent messages and passes them to `handlers`.
+// A message is marked consumed once all the handlers succeed; messages that can't be decoded are skipped.
+func NewGroupHandler(handlers ...Handler) GroupHandler {
+	return &groupHandler{handlers: handlers}
+}
+
+type groupHandler struct {
+	handlers []Handler
+	lock     sync.Mutex // guards failure, claims are consumed concurrently
+	failure  error
+}
+
+func (g *groupHandler) Setup(sarama.ConsumerGroupSession) error {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.failure = nil
+	return nil
+}
+
+func (g *groupHandler) Failure() error {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	return g.failure
+}
+
+func (g *groupHandler) Cleanup(sarama.ConsumerGroupSession) error {
+	return nil
+}
+
+// ConsumeClaim handles messages of a single partition until the session ends or a handler fails
+func (g *groupHandler) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	for msg := range claim.Messages() {
+		if err := g.handle(session.Context(), msg); err != nil {
+			g.lock.Lock()
+			if g.failure == nil {
+				g.failure = err
+			}
+			g.lock.Unlock()
+			return err
+		}
+		session.MarkMessage(msg, "")
+	}
+	return nil
+}
+
+func (g *groupHandler) handle(ctx context.Context, msg *sarama.ConsumerMessage) error {
+	span, ctx := producer.StartSpanFromMessage(ctx, msg, "ConsumeRequestAPIEvent")
+	defer span.End()
+
+	event, err := producer.DecodeMessage(msg)
+	if err != nil {
+		log.Error().Msgf("skipping malformed event at %v/%v: %v", msg.Partition, msg.Offset, err)
+		return nil
+	}
+
+	for _, handler := range g.handlers {
+		if err := handler.Handle(ctx, event); err != nil {
+			span.SetStatus(codes.Error, err.Error())
+			log.Error().Msgf("failed to handle event %v at %v/%v: %v", event.EventId, msg.Partition, msg.Offset, err)
+			return err
+		}
+	}
+	return nil
+}
+
+// Run consumes `topics` with `group` until `ctx` is done. Errors of `group` are logged.
+// A session ended with an error or a handler failure is restarted after `retryEvery`, so failed messages are read again.
+func Run(
+	ctx context.Context,
+	group sarama.ConsumerGroup,
+	topics []string,
+	handler GroupHandler,
+	retryEvery time.Duration,
+) {
+	go func() {
+		// the channel is closed when the group is closed
+		for err := range group.Errors() {
+			log.Error().Err(err).Msg("consumer group failed")
+		}
+	}()
+
+	for {
+		// Consume returns on rebalance, so it has to be called in a loop
+		err := group.Consume(ctx, topics, handler)
+		if err == nil {
+			err = handler.Failure()
+		}
+		if err != nil {
+			log.Error().Err(err).Msgf("consumer session failed, retrying in %v", retryEvery)
+			select {
+			case <-ctx.Done():
+			case <-time.After(retryEvery):
+			}
+		}
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
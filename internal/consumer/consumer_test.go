@@ -0,0 +1,243 @@
+package consumer_test
+
+import (
+	"context"
+	"errors"
+	"github.com/Shopify/sarama"
+	saramaMocks "github.com/Shopify/sarama/mocks"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/ozoncp/ocp-request-api/internal/consumer"
+	"github.com/ozoncp/ocp-request-api/internal/producer"
+	desc "github.com/ozoncp/ocp-request-api/pkg/ocp-request-api"
+	"time"
+)
+
+const topic = "events"
+
+// partitionClaim turns a partition consumer into a consumer group claim
+type partitionClaim struct {
+	sarama.PartitionConsumer
+}
+
+func (c partitionClaim) Topic() string {
+	return topic
+}
+
+func (c partitionClaim) Partition() int32 {
+	return 0
+}
+
+func (c partitionClaim) InitialOffset() int64 {
+	return sarama.OffsetOldest
+}
+
+// session records marked messages
+type session struct {
+	marked []int64
+}
+
+func (s *session) Claims() map[string][]int32 {
+	return map[string][]int32{topic: {0}}
+}
+
+func (s *session) MemberID() string {
+	return "member"
+}
+
+func (s *session) GenerationID() int32 {
+	return 1
+}
+
+func (s *session) MarkOffset(topic string, partition int32, offset int64, metadata string) {
+}
+
+func (s *session) Commit() {
+}
+
+func (s *session) ResetOffset(topic string, partition int32, offset int64, metadata string) {
+}
+
+func (s *session) MarkMessage(msg *sarama.ConsumerMessage, metadata string) {
+	s.marked = append(s.marked, msg.Offset)
+}
+
+func (s *session) Context() context.Context {
+	return context.Background()
+}
+
+// messagesClaim is a claim of buffered messages
+type messagesClaim struct {
+	partitionClaim
+	messages chan *sarama.ConsumerMessage
+}
+
+func (c messagesClaim) HighWaterMarkOffset() int64 {
+	return 0
+}
+
+func (c messagesClaim) Messages() <-chan *sarama.ConsumerMessage {
+	return c.messages
+}
+
+// fakeGroup passes a message to every session and records when sessions start.
+// It returns no errors from Consume as sarama does when ConsumeClaim fails.
+type fakeGroup struct {
+	message   *sarama.ConsumerMessage
+	sessions  []time.Time
+	stopAfter int
+	stop      context.CancelFunc
+	errors    chan error
+}
+
+func (g *fakeGroup) Consume(ctx context.Context, topics []string, handler sarama.ConsumerGroupHandler) error {
+	g.sessions = append(g.sessions, time.Now())
+	sess := &session{}
+	Expect(handler.Setup(sess)).To(Succeed())
+	claim := messagesClaim{messages: make(chan *sarama.ConsumerMessage, 1)}
+	claim.messages <- g.message
+	close(claim.messages)
+	handler.ConsumeClaim(sess, claim)
+	Expect(handler.Cleanup(sess)).To(Succeed())
+
+	if len(g.sessions) == g.stopAfter {
+		g.stop()
+	}
+	return nil
+}
+
+func (g *fakeGroup) Errors() <-chan error {
+	return g.errors
+}
+
+func (g *fakeGroup) Close() error {
+	close(g.errors)
+	return nil
+}
+
+var _ = Describe("GroupHandler", func() {
+
+	var (
+		kafkaConsumer *saramaMocks.Consumer
+		partition     *saramaMocks.PartitionConsumer
+		sess          *session
+		handled       []uint64
+	)
+
+	recordingHandler := consumer.HandlerFunc(func(ctx context.Context, event *desc.RequestAPIEvent) error {
+		handled = append(handled, event.RequestId)
+		return nil
+	})
+
+	// the mock assigns offsets to yielded messages starting from 1
+	yieldEvent := func(requestId uint64) {
+		value, err := producer.NewEvent(context.Background(), requestId, producer.ReadEvent, producer.NoSnapshot, nil).Encode()
+		Expect(err).ToNot(HaveOccurred())
+		partition.YieldMessage(&sarama.ConsumerMessage{Value: value})
+	}
+
+	consumeClaim := func(handlers ...consumer.Handler) error {
+		pc, err := kafkaConsumer.ConsumePartition(topic, 0, sarama.OffsetOldest)
+		Expect(err).ToNot(HaveOccurred())
+		pc.AsyncClose() // messages are already buffered, so the claim ends once they are read
+		return consumer.NewGroupHandler(handlers...).ConsumeClaim(sess, partitionClaim{pc})
+	}
+
+	BeforeEach(func() {
+		kafkaConsumer = saramaMocks.NewConsumer(GinkgoT(), nil)
+		partition = kafkaConsumer.ExpectConsumePartition(topic, 0, sarama.OffsetOldest)
+		sess = &session{}
+		handled = nil
+	})
+
+	AfterEach(func() {
+		Expect(kafkaConsumer.Close()).To(Succeed())
+	})
+
+	It("Passes decoded events to handlers and marks them consumed", func() {
+		yieldEvent(1)
+		yieldEvent(2)
+
+		Expect(consumeClaim(recordingHandler)).To(Succeed())
+		Expect(handled).To(Equal([]uint64{1, 2}))
+		Expect(sess.marked).To(Equal([]int64{1, 2}))
+	})
+
+	It("Skips malformed messages", func() {
+		partition.YieldMessage(&sarama.ConsumerMessage{Value: []byte("garbage")})
+		yieldEvent(2)
+
+		Expect(consumeClaim(recordingHandler)).To(Succeed())
+		Expect(handled).To(Equal([]uint64{2}))
+		Expect(sess.marked).To(Equal([]int64{1, 2}))
+	})
+
+	It("Stops without marking an event a handler failed on", func() {
+		handlerErr := errors.New("disk is full")
+		failing := consumer.HandlerFunc(func(ctx context.Context, event *desc.RequestAPIEvent) error {
+			if event.RequestId == 2 {
+				return handlerErr
+			}
+			return nil
+		})
+		yieldEvent(1)
+		yieldEvent(2)
+		yieldEvent(3)
+
+		Expect(consumeClaim(failing, recordingHandler)).To(Equal(handlerErr))
+		Expect(handled).To(Equal([]uint64{1}))
+		Expect(sess.marked).To(Equal([]int64{1}))
+	})
+})
+
+var _ = Describe("Run", func() {
+
+	var (
+		group *fakeGroup
+		ctx   context.Context
+	)
+
+	BeforeEach(func() {
+		value, err := producer.NewEvent(context.Background(), 1, producer.ReadEvent, producer.NoSnapshot, nil).Encode()
+		Expect(err).ToNot(HaveOccurred())
+		var stop context.CancelFunc
+		ctx, stop = context.WithCancel(context.Background())
+		group = &fakeGroup{
+			message:   &sarama.ConsumerMessage{Value: value},
+			stopAfter: 3,
+			stop:      stop,
+			errors:    make(chan error),
+		}
+	})
+
+	AfterEach(func() {
+		group.Close()
+	})
+
+	It("Waits before reading a message again after a handler failure", func() {
+		failures := 1
+		handler := consumer.HandlerFunc(func(ctx context.Context, event *desc.RequestAPIEvent) error {
+			if failures > 0 {
+				failures--
+				return errors.New("disk is full")
+			}
+			return nil
+		})
+
+		consumer.Run(ctx, group, []string{topic}, consumer.NewGroupHandler(handler), 100*time.Millisecond)
+		Expect(group.sessions).To(HaveLen(3))
+		Expect(group.sessions[1].Sub(group.sessions[0])).To(BeNumerically(">=", 100*time.Millisecond))
+		Expect(group.sessions[2].Sub(group.sessions[1])).To(BeNumerically("<", 100*time.Millisecond))
+	})
+
+	It("Restarts sessions ended without failures at once", func() {
+		handler := consumer.HandlerFunc(func(ctx context.Context, event *desc.RequestAPIEvent) error {
+			return nil
+		})
+
+		started := time.Now()
+		consumer.Run(ctx, group, []string{topic}, consumer.NewGroupHandler(handler), time.Hour)
+		Expect(group.sessions).To(HaveLen(3))
+		Expect(time.Since(started)).To(BeNumerically("<", time.Second))
+	})
+})
@@ -0,0 +1,67 @@
+package consumer
+
+import (
+	"context"
+	"github.com/ozoncp/ocp-request-api/internal/metrics"
+	desc "github.com/ozoncp/ocp-request-api/pkg/ocp-request-api"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/protobuf/encoding/protojson"
+	"io"
+	"sync"
+)
+
+// Handler processes consumed events. An error makes the consumer re-read the event later.
+type Handler interface {
+	Handle(ctx context.Context, event *desc.RequestAPIEvent) error
+}
+
+// HandlerFunc adapts a function to Handler interface
+type HandlerFunc func(ctx context.Context, event *desc.RequestAPIEvent) error
+
+func (f HandlerFunc) Handle(ctx context.Context, event *desc.RequestAPIEvent) error {
+	return f(ctx, event)
+}
+
+// NewLogHandler returns a Handler that logs every event
+func NewLogHandler() Handler {
+	return HandlerFunc(func(ctx context.Context, event *desc.RequestAPIEvent) error {
+		log.Info().
+			Str("event_id", event.EventId).
+			Str("event", event.Event.String()).
+			Uint64("request_id", event.RequestId).
+			Str("actor", event.Actor).
+			Str("error", event.Error).
+			Msg("consumed event")
+		return nil
+	})
+}
+
+// NewCountingHandler returns a Handler that counts events by type
+func NewCountingHandler(metricsReporter metrics.ConsumerMetricsReporter) Handler {
+	return HandlerFunc(func(ctx context.Context, event *desc.RequestAPIEvent) error {
+		metricsReporter.IncConsumed(event.Event.String())
+		return nil
+	})
+}
+
+// NewFileSink returns a Handler that writes events to `out` as JSON lines
+func NewFileSink(out io.Writer) Handler {
+	return &fileSink{out: out}
+}
+
+type fileSink struct {
+	lock sync.Mutex // events of several partitions are handled concurrently
+	out  io.Writer
+}
+
+func (s *fileSink) Handle(ctx context.Context, event *desc.RequestAPIEvent) error {
+	line, err := protojson.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	_, err = s.out.Write(append(line, '\n'))
+	return err
+}
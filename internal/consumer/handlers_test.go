@@ -0,0 +1,60 @@
+package consumer_test
+
+import (
+	"bytes"
+	"context"
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/ozoncp/ocp-request-api/internal/consumer"
+	"github.com/ozoncp/ocp-request-api/internal/mocks"
+	desc "github.com/ozoncp/ocp-request-api/pkg/ocp-request-api"
+	"google.golang.org/protobuf/encoding/protojson"
+	"strings"
+)
+
+var _ = Describe("Handlers", func() {
+
+	var (
+		ctx    context.Context
+		events []*desc.RequestAPIEvent
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		events = []*desc.RequestAPIEvent{
+			{RequestId: 1, Event: desc.RequestAPIEvent_CREATE, EventId: "first"},
+			{RequestId: 1, Event: desc.RequestAPIEvent_UPDATE, EventId: "second"},
+			{RequestId: 2, Event: desc.RequestAPIEvent_CREATE, EventId: "third"},
+		}
+	})
+
+	It("Counts events by type", func() {
+		mockCtrl := gomock.NewController(GinkgoT())
+		defer mockCtrl.Finish()
+		mockMetrics := mocks.NewMockConsumerMetricsReporter(mockCtrl)
+		mockMetrics.EXPECT().IncConsumed("CREATE").Times(2)
+		mockMetrics.EXPECT().IncConsumed("UPDATE").Times(1)
+
+		handler := consumer.NewCountingHandler(mockMetrics)
+		for _, event := range events {
+			Expect(handler.Handle(ctx, event)).To(Succeed())
+		}
+	})
+
+	It("Writes events as JSON lines", func() {
+		out := &bytes.Buffer{}
+		handler := consumer.NewFileSink(out)
+		for _, event := range events {
+			Expect(handler.Handle(ctx, event)).To(Succeed())
+		}
+
+		lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+		Expect(lines).To(HaveLen(len(events)))
+		for ix, line := range lines {
+			decoded := &desc.RequestAPIEvent{}
+			Expect(protojson.Unmarshal([]byte(line), decoded)).To(Succeed())
+			Expect(decoded.EventId).To(Equal(events[ix].EventId))
+		}
+	})
+})
@@ -2,40 +2,90 @@ package flusher
 
 import (
 	"context"
+	"fmt"
+	"github.com/ozoncp/ocp-request-api/internal/metrics"
 	"github.com/ozoncp/ocp-request-api/internal/models"
 	"github.com/ozoncp/ocp-request-api/internal/repo"
 	"github.com/ozoncp/ocp-request-api/internal/utils"
+	"sync"
+	"time"
 )
 
-// Flusher is interface to store Requests items to a storage
+// Flusher is interface to store Requests items to a storage.
+// Flush returns Requests failed to be stored in the order they are passed in.
 type Flusher interface {
 	Flush(ctx context.Context, entities []models.Request) ([]models.Request, error)
 }
 
-// NewFlusher creates a new Flusher instance that writes Requests to storage by batches of a given size
+// FlushError is returned by a concurrent Flush if some of the chunks failed to be written.
+// Errors contains errors of the failed chunks in the order the chunks were taken from the input.
+type FlushError struct {
+	Errors []error
+}
+
+// Error Returns a description of the error
+func (err FlushError) Error() string {
+	return fmt.Sprintf("failed to write %v chunks, first error: %v", len(err.Errors), err.Errors[0])
+}
+
+// NewFlusher creates a new Flusher instance that writes Requests to storage by batches of a given size.
+// Flushes are reported to `metricsReporter`.
 func NewFlusher(
 	chunkSize uint,
 	requestRepo repo.Repo,
+	metricsReporter metrics.FlushMetricsReporter,
 ) Flusher {
+	return NewConcurrentFlusher(chunkSize, 1, requestRepo, metricsReporter)
+}
+
+// NewConcurrentFlusher creates a new Flusher instance that writes batches of a given size using `workers` goroutines.
+// With a single worker it behaves exactly as the Flusher returned by NewFlusher.
+func NewConcurrentFlusher(
+	chunkSize uint,
+	workers uint,
+	requestRepo repo.Repo,
+	metricsReporter metrics.FlushMetricsReporter,
+) Flusher {
+	if workers == 0 {
+		workers = 1
+	}
 	return &flusher{
 		chunkSize:   chunkSize,
+		workers:     workers,
 		requestRepo: requestRepo,
+		metrics:     metricsReporter,
 	}
 }
 
 type flusher struct {
 	chunkSize   uint
+	workers     uint
 	requestRepo repo.Repo
+	metrics     metrics.FlushMetricsReporter
 }
 
 // Flush stores a slice of Requests to the underlying repository. It makes requests by chunks of a certain size.
 // It's returns a slice of Requests that it's failed to write.
 func (f *flusher) Flush(ctx context.Context, requests []models.Request) ([]models.Request, error) {
-	var err error
 	if len(requests) == 0 {
 		return requests, nil
 	}
 
+	start := time.Now()
+	var remains []models.Request
+	var err error
+	if f.workers > 1 {
+		remains, err = f.flushConcurrently(ctx, requests)
+	} else {
+		remains, err = f.flushSequentially(ctx, requests)
+	}
+	f.metrics.ObserveFlush(len(requests), len(remains), time.Since(start))
+	return remains, err
+}
+
+// flushSequentially writes chunks one by one and stops on the first failure
+func (f *flusher) flushSequentially(ctx context.Context, requests []models.Request) ([]models.Request, error) {
+	var err error
 	remains := make([]models.Request, 0, f.chunkSize)
 	for ix, chunk := range utils.SplitToBulks(requests, f.chunkSize) {
 		if _, err = f.requestRepo.AddMany(ctx, chunk); err != nil {
@@ -45,3 +95,47 @@ func (f *flusher) Flush(ctx context.Context, requests []models.Request) ([]model
 	}
 	return remains, nil
 }
+
+// flushConcurrently writes chunks with a pool of workers. Unlike the sequential mode it does not stop on
+// the first failure, so only the Requests of the failed chunks are returned.
+func (f *flusher) flushConcurrently(ctx context.Context, requests []models.Request) ([]models.Request, error) {
+	chunks := utils.SplitToBulks(requests, f.chunkSize)
+	chunkErrors := make([]error, len(chunks))
+	chunkIndexes := make(chan int)
+
+	workers := int(f.workers)
+	if workers > len(chunks) {
+		workers = len(chunks)
+	}
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ix := range chunkIndexes {
+				_, chunkErrors[ix] = f.requestRepo.AddMany(ctx, chunks[ix])
+			}
+		}()
+	}
+
+	for ix := range chunks {
+		chunkIndexes <- ix
+	}
+	close(chunkIndexes)
+	wg.Wait()
+
+	remains := make([]models.Request, 0)
+	errs := make([]error, 0)
+	for ix, err := range chunkErrors {
+		if err != nil {
+			remains = append(remains, chunks[ix]...)
+			errs = append(errs, err)
+		}
+	}
+
+	if len(errs) > 0 {
+		return remains, FlushError{Errors: errs}
+	}
+	return remains, nil
+}
@@ -14,15 +14,17 @@ import (
 var _ = Describe("Flusher", func() {
 
 	var (
-		fl       flusher.Flusher
-		mockRepo *mocks.MockRepo
-		mockCtrl *gomock.Controller
-		ctx      context.Context
+		fl          flusher.Flusher
+		mockRepo    *mocks.MockRepo
+		mockMetrics *mocks.MockFlushMetricsReporter
+		mockCtrl    *gomock.Controller
+		ctx         context.Context
 	)
 
 	BeforeEach(func() {
 		mockCtrl = gomock.NewController(GinkgoT())
 		mockRepo = mocks.NewMockRepo(mockCtrl)
+		mockMetrics = mocks.NewMockFlushMetricsReporter(mockCtrl)
 		ctx = context.Background()
 	})
 
@@ -32,7 +34,8 @@ var _ = Describe("Flusher", func() {
 
 	Context("Adding items with no errors. Will not return any remains.", func() {
 		JustBeforeEach(func() {
-			fl = flusher.NewFlusher(2, mockRepo)
+			fl = flusher.NewFlusher(2, mockRepo, mockMetrics)
+			mockMetrics.EXPECT().ObserveFlush(gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes()
 		})
 
 		It("Added all batches with a single call to repo.", func() {
@@ -91,7 +94,8 @@ var _ = Describe("Flusher", func() {
 
 	Context("Repo fails to add items", func() {
 		JustBeforeEach(func() {
-			fl = flusher.NewFlusher(2, mockRepo)
+			fl = flusher.NewFlusher(2, mockRepo, mockMetrics)
+			mockMetrics.EXPECT().ObserveFlush(gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes()
 		})
 
 		It("Failed to add all items", func() {
@@ -148,4 +152,67 @@ var _ = Describe("Flusher", func() {
 
 	})
 
+	Context("Concurrent flusher", func() {
+		JustBeforeEach(func() {
+			fl = flusher.NewConcurrentFlusher(2, 3, mockRepo, mockMetrics)
+		})
+
+		It("Added all chunks in parallel", func() {
+			mockRepo.EXPECT().
+				AddMany(ctx, gomock.Any()).
+				Return([]uint64{}, nil).
+				MaxTimes(3).
+				MinTimes(3)
+			mockMetrics.EXPECT().ObserveFlush(5, 0, gomock.Any()).Times(1)
+
+			remains, err := fl.Flush(ctx, []models.Request{
+				models.NewRequest(1, 2, 3, ""),
+				models.NewRequest(2, 2, 3, ""),
+				models.NewRequest(3, 2, 3, ""),
+				models.NewRequest(4, 2, 3, ""),
+				models.NewRequest(5, 2, 3, ""),
+			})
+			Expect(remains).To(HaveLen(0))
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("Returns only requests of the failed chunks", func() {
+			requests := []models.Request{
+				models.NewRequest(1, 2, 3, ""),
+				models.NewRequest(2, 2, 3, ""),
+				models.NewRequest(3, 2, 3, ""),
+				models.NewRequest(4, 2, 3, ""),
+				models.NewRequest(5, 2, 3, ""),
+				models.NewRequest(6, 2, 3, ""),
+				models.NewRequest(7, 2, 3, ""),
+			}
+			failedToAdd := errors.New("failed to add")
+
+			mockRepo.EXPECT().
+				AddMany(ctx, requests[:2]).
+				Return(nil, failedToAdd)
+
+			mockRepo.EXPECT().
+				AddMany(ctx, requests[2:4]).
+				Return([]uint64{3, 4}, nil)
+
+			mockRepo.EXPECT().
+				AddMany(ctx, requests[4:6]).
+				Return(nil, failedToAdd)
+
+			mockRepo.EXPECT().
+				AddMany(ctx, requests[6:]).
+				Return([]uint64{7}, nil)
+
+			mockMetrics.EXPECT().ObserveFlush(7, 4, gomock.Any()).Times(1)
+
+			remains, err := fl.Flush(ctx, requests)
+
+			Expect(remains).To(Equal([]models.Request{
+				requests[0], requests[1], requests[4], requests[5],
+			}), "These are failed to add to repo")
+			Expect(err).To(Equal(flusher.FlushError{Errors: []error{failedToAdd, failedToAdd}}))
+		})
+	})
+
 })
@@ -0,0 +1,141 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"sync"
+	"time"
+)
+
+// ErrShuttingDown is reported by a Checker after Shutdown
+var ErrShuttingDown = errors.New("service is shutting down")
+
+// ErrNotChecked is reported by a Checker until Run completes checks for the first time
+var ErrNotChecked = errors.New("checks have not completed yet")
+
+// Check tells whether a dependency of the service is usable, it returns an error if it is not
+type Check func(ctx context.Context) error
+
+// Checker tells whether the service is ready to serve calls and keeps status of gRPC health service up to date
+type Checker interface {
+	// Check runs all checks concurrently. Returns errors of failed checks by name, empty if the service is ready.
+	Check(ctx context.Context) map[string]error
+	// Status returns errors of failed checks found by the latest run of checks, empty if the service is ready.
+	// Unlike Check, it runs no checks, so it is cheap to call on every probe.
+	Status() map[string]error
+	// Run updates status of gRPC health service and the one returned by Status every `every` until `ctx` is done
+	Run(ctx context.Context, every time.Duration)
+	// Shutdown reports the service is stopping, so it is not ready regardless of checks from now on
+	Shutdown()
+}
+
+// NewChecker creates a Checker running `checks` with `timeout` each. Serving status of `services`
+// (and of the whole server, the empty service name) is set on `server`.
+// The services are not serving until checks pass for the first time.
+func NewChecker(checks map[string]Check, timeout time.Duration, server *health.Server, services ...string) Checker {
+	c := &checker{
+		checks:   checks,
+		timeout:  timeout,
+		server:   server,
+		services: append([]string{""}, services...),
+		failed:   map[string]error{"startup": ErrNotChecked},
+	}
+	c.setServingStatus(healthpb.HealthCheckResponse_NOT_SERVING)
+	return c
+}
+
+type checker struct {
+	checks   map[string]Check
+	timeout  time.Duration
+	server   *health.Server
+	services []string
+	lock     sync.RWMutex // guards shutdown flag and failed checks
+	shutdown bool
+	failed   map[string]error // found by the latest run of checks
+}
+
+func (c *checker) Check(ctx context.Context) map[string]error {
+	if c.isShutdown() {
+		return map[string]error{"shutdown": ErrShuttingDown}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	var (
+		lock   sync.Mutex
+		wait   sync.WaitGroup
+		failed = map[string]error{}
+	)
+	for name, check := range c.checks {
+		wait.Add(1)
+		go func(name string, check Check) {
+			defer wait.Done()
+			if err := check(ctx); err != nil {
+				lock.Lock()
+				failed[name] = err
+				lock.Unlock()
+			}
+		}(name, check)
+	}
+	wait.Wait()
+	return failed
+}
+
+func (c *checker) Status() map[string]error {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	if c.shutdown {
+		return map[string]error{"shutdown": ErrShuttingDown}
+	}
+	return c.failed
+}
+
+func (c *checker) Run(ctx context.Context, every time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+
+	for {
+		c.updateServingStatus(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (c *checker) Shutdown() {
+	c.lock.Lock()
+	c.shutdown = true
+	c.lock.Unlock()
+	// statuses are NOT_SERVING from now on, later updates are ignored
+	c.server.Shutdown()
+}
+
+func (c *checker) updateServingStatus(ctx context.Context) {
+	failed := c.Check(ctx)
+	c.lock.Lock()
+	c.failed = failed
+	c.lock.Unlock()
+
+	if len(failed) > 0 {
+		c.setServingStatus(healthpb.HealthCheckResponse_NOT_SERVING)
+	} else {
+		c.setServingStatus(healthpb.HealthCheckResponse_SERVING)
+	}
+}
+
+func (c *checker) setServingStatus(status healthpb.HealthCheckResponse_ServingStatus) {
+	for _, service := range c.services {
+		c.server.SetServingStatus(service, status)
+	}
+}
+
+func (c *checker) isShutdown() bool {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.shutdown
+}
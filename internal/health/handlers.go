@@ -0,0 +1,42 @@
+package health
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+type statusResponse struct {
+	Status string            `json:"status"`
+	Failed map[string]string `json:"failed,omitempty"`
+}
+
+// LivenessHandler answers 200 OK as long as the process is able to serve HTTP
+func LivenessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeStatus(w, http.StatusOK, statusResponse{Status: "ok"})
+	})
+}
+
+// ReadinessHandler answers 200 OK if the latest checks of `checker` found the service ready,
+// 503 Service Unavailable with errors of failed checks otherwise. Checks are run by Checker.Run, not by probes.
+func ReadinessHandler(checker Checker) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		failed := checker.Status()
+		if len(failed) == 0 {
+			writeStatus(w, http.StatusOK, statusResponse{Status: "ok"})
+			return
+		}
+
+		response := statusResponse{Status: "not ready", Failed: make(map[string]string, len(failed))}
+		for name, err := range failed {
+			response.Failed[name] = err.Error()
+		}
+		writeStatus(w, http.StatusServiceUnavailable, response)
+	})
+}
+
+func writeStatus(w http.ResponseWriter, code int, response statusResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(response)
+}
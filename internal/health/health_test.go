@@ -0,0 +1,155 @@
+package health_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/ozoncp/ocp-request-api/internal/health"
+	grpcHealth "google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"time"
+)
+
+var errConnectionRefused = errors.New("connection refused")
+
+var _ = Describe("Checker", func() {
+
+	const service = "ocp.request.api.OcpRequestApi"
+
+	var (
+		ctx          context.Context
+		server       *grpcHealth.Server
+		dbDown       int32 // the db check fails if set
+		checker      health.Checker
+		servingState = func(service string) healthpb.HealthCheckResponse_ServingStatus {
+			response, err := server.Check(context.Background(), &healthpb.HealthCheckRequest{Service: service})
+			Expect(err).ToNot(HaveOccurred())
+			return response.Status
+		}
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		server = grpcHealth.NewServer()
+		atomic.StoreInt32(&dbDown, 0)
+		checker = health.NewChecker(map[string]health.Check{
+			"db": func(ctx context.Context) error {
+				if atomic.LoadInt32(&dbDown) == 1 {
+					return errConnectionRefused
+				}
+				return nil
+			},
+			"kafka": func(ctx context.Context) error {
+				return nil
+			},
+		}, 50*time.Millisecond, server, service)
+	})
+
+	It("Reports failed checks", func() {
+		Expect(checker.Check(ctx)).To(BeEmpty())
+
+		atomic.StoreInt32(&dbDown, 1)
+		Expect(checker.Check(ctx)).To(Equal(map[string]error{"db": errConnectionRefused}))
+	})
+
+	It("Fails checks taking longer than the timeout", func() {
+		checker = health.NewChecker(map[string]health.Check{
+			"db": func(ctx context.Context) error {
+				<-ctx.Done()
+				return ctx.Err()
+			},
+		}, time.Millisecond, server)
+
+		Expect(checker.Check(ctx)).To(Equal(map[string]error{"db": context.DeadlineExceeded}))
+	})
+
+	It("Updates status of gRPC health service", func() {
+		Expect(servingState(service)).To(Equal(healthpb.HealthCheckResponse_NOT_SERVING))
+
+		runCtx, stop := context.WithCancel(ctx)
+		stopped := make(chan struct{})
+		go func() {
+			defer close(stopped)
+			checker.Run(runCtx, time.Millisecond)
+		}()
+		defer func() {
+			stop()
+			<-stopped
+		}()
+
+		Eventually(func() healthpb.HealthCheckResponse_ServingStatus {
+			return servingState(service)
+		}).Should(Equal(healthpb.HealthCheckResponse_SERVING))
+
+		atomic.StoreInt32(&dbDown, 1)
+		Eventually(func() healthpb.HealthCheckResponse_ServingStatus {
+			return servingState(service)
+		}).Should(Equal(healthpb.HealthCheckResponse_NOT_SERVING))
+		Expect(servingState("")).To(Equal(healthpb.HealthCheckResponse_NOT_SERVING))
+	})
+
+	It("Is not ready after shutdown", func() {
+		checker.Shutdown()
+
+		Expect(checker.Check(ctx)).To(HaveKeyWithValue("shutdown", health.ErrShuttingDown))
+		Expect(servingState(service)).To(Equal(healthpb.HealthCheckResponse_NOT_SERVING))
+		Expect(servingState("")).To(Equal(healthpb.HealthCheckResponse_NOT_SERVING))
+	})
+
+	Describe("HTTP handlers", func() {
+
+		get := func(handler http.Handler) (int, map[string]interface{}) {
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+			var body map[string]interface{}
+			Expect(json.Unmarshal(recorder.Body.Bytes(), &body)).To(Succeed())
+			return recorder.Code, body
+		}
+
+		It("Reports the service is alive", func() {
+			code, body := get(health.LivenessHandler())
+			Expect(code).To(Equal(http.StatusOK))
+			Expect(body).To(HaveKeyWithValue("status", "ok"))
+		})
+
+		It("Reports readiness with failed checks of the latest run", func() {
+			code, body := get(health.ReadinessHandler(checker))
+			Expect(code).To(Equal(http.StatusServiceUnavailable))
+			Expect(body).To(HaveKeyWithValue("failed", map[string]interface{}{"startup": health.ErrNotChecked.Error()}))
+
+			runCtx, stop := context.WithCancel(ctx)
+			stopped := make(chan struct{})
+			go func() {
+				defer close(stopped)
+				checker.Run(runCtx, time.Millisecond)
+			}()
+			defer func() {
+				stop()
+				<-stopped
+			}()
+
+			Eventually(func() int {
+				code, _ := get(health.ReadinessHandler(checker))
+				return code
+			}).Should(Equal(http.StatusOK))
+			_, body = get(health.ReadinessHandler(checker))
+			Expect(body).To(Equal(map[string]interface{}{"status": "ok"}))
+
+			atomic.StoreInt32(&dbDown, 1)
+			Eventually(func() map[string]interface{} {
+				_, body := get(health.ReadinessHandler(checker))
+				return body
+			}).Should(HaveKeyWithValue("failed", map[string]interface{}{"db": "connection refused"}))
+
+			atomic.StoreInt32(&dbDown, 0)
+			checker.Shutdown()
+			code, _ = get(health.ReadinessHandler(checker))
+			Expect(code).To(Equal(http.StatusServiceUnavailable))
+		})
+	})
+})
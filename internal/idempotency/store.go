@@ -0,0 +1,241 @@
+package idempotency
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	sq "github.com/Masterminds/squirrel"
+	sql "github.com/jmoiron/sqlx"
+	"github.com/ozoncp/ocp-request-api/internal/identity"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc/metadata"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// MetadataKey is a gRPC metadata key carrying an idempotency key. The gateway maps Idempotency-Key HTTP header to it.
+const MetadataKey = "idempotency-key"
+
+// MaxKeyLength is the max number of characters in an idempotency key, the same as idempotency_key fields allow
+const MaxKeyLength = 128
+
+// Key identifies a create call by an idempotency key passed by the caller.
+// Keys are scoped by an API method and the caller, so keys of different callers never clash.
+type Key struct {
+	Method string
+	Caller string
+	Value  string
+}
+
+// NewKey returns a Key of a `method` call with idempotency key `value` creating requests of `userIds`.
+// The call is attributed to the authenticated caller, anonymous calls are attributed to the users requests belong to.
+func NewKey(ctx context.Context, method, value string, userIds ...uint64) Key {
+	return Key{Method: method, Caller: callerOf(ctx, userIds), Value: value}
+}
+
+func callerOf(ctx context.Context, userIds []uint64) string {
+	if caller, ok := identity.FromContext(ctx); ok {
+		return "sub:" + caller.Subject
+	}
+
+	unique := make(map[uint64]struct{}, len(userIds))
+	for _, id := range userIds {
+		unique[id] = struct{}{}
+	}
+	sorted := make([]uint64, 0, len(unique))
+	for id := range unique {
+		sorted = append(sorted, id)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	ids := make([]string, 0, len(sorted))
+	for _, id := range sorted {
+		ids = append(ids, fmt.Sprint(id))
+	}
+	return "users:" + strings.Join(ids, ",")
+}
+
+// Store remembers ids of requests created by API calls with idempotency keys, so that a retried call
+// returns the original result instead of creating requests again.
+type Store interface {
+	// Get returns ids of requests created with `key`. Returns false if the key is unknown or expired.
+	Get(ctx context.Context, key Key) ([]uint64, bool, error)
+	// Reserve reserves `key` within transaction `tx` creating requests. If the key is already reserved and
+	// not expired, returns ids of requests created with it and false. A concurrent reservation of the same key
+	// blocks until the transaction holding it is finished, so requests of a key are created once.
+	Reserve(ctx context.Context, tx sq.StatementBuilderType, key Key) ([]uint64, bool, error)
+	// Complete remembers ids of requests created within transaction `tx` with a `key` reserved by it
+	Complete(ctx context.Context, tx sq.StatementBuilderType, key Key, requestIds []uint64) error
+	// Purge removes expired keys. Returns a number of removed keys.
+	Purge(ctx context.Context) (int64, error)
+}
+
+// NewStore creates a Store keeping keys in PostgreSQL for `ttl`
+func NewStore(db *sql.DB, ttl time.Duration) Store {
+	stmtCache := sq.NewStmtCache(db)
+
+	return &store{
+		stmBuilder: sq.StatementBuilder.PlaceholderFormat(sq.Dollar).RunWith(stmtCache),
+		ttl:        ttl,
+		now:        time.Now,
+	}
+}
+
+type store struct {
+	stmBuilder sq.StatementBuilderType
+	ttl        time.Duration
+	now        func() time.Time
+}
+
+func (s *store) Get(ctx context.Context, key Key) ([]uint64, bool, error) {
+	return s.createdWith(ctx, s.stmBuilder, key, sq.Gt{"created_at": s.expiredAt()})
+}
+
+func (s *store) Reserve(ctx context.Context, tx sq.StatementBuilderType, key Key) ([]uint64, bool, error) {
+	// an expired key may still be in the table until it is purged, take it over
+	rows, err := tx.Insert("idempotency_keys").
+		Columns("caller", "method", "key", "request_ids", "created_at").
+		Values(key.Caller, key.Method, key.Value, "[]", s.now()).
+		Suffix("ON CONFLICT (caller, method, key) DO UPDATE "+
+			"SET request_ids = EXCLUDED.request_ids, created_at = EXCLUDED.created_at "+
+			"WHERE idempotency_keys.created_at <= ? RETURNING key", s.expiredAt()).
+		QueryContext(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	reserved := rows.Next()
+	if err := rows.Close(); err != nil {
+		return nil, false, err
+	}
+	if reserved {
+		return nil, true, nil
+	}
+
+	// the key is taken by a committed transaction, which is visible to the next statement
+	ids, _, err := s.createdWith(ctx, tx, key, nil)
+	return ids, false, err
+}
+
+func (s *store) Complete(ctx context.Context, tx sq.StatementBuilderType, key Key, requestIds []uint64) error {
+	stored, err := json.Marshal(requestIds)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Update("idempotency_keys").
+		Set("request_ids", string(stored)).
+		Where(sq.Eq{"caller": key.Caller, "method": key.Method, "key": key.Value}).
+		ExecContext(ctx)
+	return err
+}
+
+func (s *store) Purge(ctx context.Context) (int64, error) {
+	result, err := s.stmBuilder.Delete("idempotency_keys").
+		Where(sq.LtOrEq{"created_at": s.expiredAt()}).
+		ExecContext(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// createdWith reads ids of requests created with `key` matching an optional `condition`
+func (s *store) createdWith(ctx context.Context, runner sq.StatementBuilderType, key Key, condition sq.Sqlizer) ([]uint64, bool, error) {
+	conditions := sq.And{sq.Eq{"caller": key.Caller, "method": key.Method, "key": key.Value}}
+	if condition != nil {
+		conditions = append(conditions, condition)
+	}
+	rows, err := runner.Select("request_ids").
+		From("idempotency_keys").
+		Where(conditions).
+		QueryContext(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return nil, false, rows.Err()
+	}
+	var stored []byte
+	if err := rows.Scan(&stored); err != nil {
+		return nil, false, err
+	}
+	var requestIds []uint64
+	if err := json.Unmarshal(stored, &requestIds); err != nil {
+		return nil, false, err
+	}
+	return requestIds, true, nil
+}
+
+func (s *store) expiredAt() time.Time {
+	return s.now().Add(-s.ttl)
+}
+
+// Purger removes expired keys in background, out of the way of API calls
+type Purger interface {
+	Run(ctx context.Context)
+}
+
+// NewPurger creates a Purger removing expired keys of `store` every `purgeEvery`
+func NewPurger(store Store, purgeEvery time.Duration) Purger {
+	return &purger{
+		store:      store,
+		purgeEvery: purgeEvery,
+	}
+}
+
+type purger struct {
+	store      Store
+	purgeEvery time.Duration
+}
+
+// Run purges expired keys until `ctx` is done
+func (p *purger) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.purgeEvery)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			purged, err := p.store.Purge(ctx)
+			if err != nil {
+				log.Error().Err(err).Msg("failed to purge expired idempotency keys")
+				continue
+			}
+			log.Debug().Int64("purged", purged).Msg("purged expired idempotency keys")
+		}
+	}
+}
+
+// KeyFromRequest returns an idempotency key passed in a request `field` or, if the field is empty, with gRPC metadata
+func KeyFromRequest(ctx context.Context, field string) string {
+	if field != "" {
+		return field
+	}
+	return KeyFromContext(ctx)
+}
+
+// ValidateKey checks an idempotency key passed by the caller. Keys of request fields are checked
+// by request validation, but keys passed with metadata are not.
+func ValidateKey(key string) error {
+	if utf8.RuneCountInString(key) > MaxKeyLength {
+		return fmt.Errorf("idempotency key must be at most %v characters long", MaxKeyLength)
+	}
+	return nil
+}
+
+// KeyFromContext returns an idempotency key passed with gRPC metadata. Returns an empty string if there is none.
+func KeyFromContext(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	if values := md.Get(MetadataKey); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
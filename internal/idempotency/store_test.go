@@ -0,0 +1,150 @@
+package idempotency
+
+import (
+	"context"
+	"database/sql"
+	"github.com/DATA-DOG/go-sqlmock"
+	sq "github.com/Masterminds/squirrel"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/ozoncp/ocp-request-api/internal/identity"
+	"google.golang.org/grpc/metadata"
+	"time"
+)
+
+var _ = Describe("Store", func() {
+
+	var (
+		s      Store
+		dbMock sqlmock.Sqlmock
+		ctx    context.Context
+		db     *sql.DB
+		now    time.Time
+		key    Key
+		tx     *sql.Tx
+	)
+
+	begin := func() sq.StatementBuilderType {
+		var err error
+		tx, err = db.Begin()
+		Expect(err).ToNot(HaveOccurred())
+		return sq.StatementBuilder.PlaceholderFormat(sq.Dollar).RunWith(tx)
+	}
+	commit := func() error {
+		return tx.Commit()
+	}
+
+	BeforeEach(func() {
+		var err error
+		ctx = context.Background()
+		now = time.Date(2021, 9, 1, 12, 0, 0, 0, time.UTC)
+		key = Key{Method: "CreateRequestV1", Caller: "sub:alice", Value: "abc"}
+		db, dbMock, err = sqlmock.New()
+		Expect(err).ToNot(HaveOccurred())
+
+		s = &store{
+			stmBuilder: sq.StatementBuilder.PlaceholderFormat(sq.Dollar).RunWith(sq.NewStmtCache(db)),
+			ttl:        time.Hour,
+			now:        func() time.Time { return now },
+		}
+	})
+
+	AfterEach(func() {
+		defer db.Close()
+		Expect(dbMock.ExpectationsWereMet()).To(Succeed())
+	})
+
+	It("Returns ids created with an unexpired key", func() {
+		dbMock.ExpectPrepare(
+			"SELECT request_ids FROM idempotency_keys WHERE \\(caller = \\$1 AND key = \\$2 AND method = \\$3 AND created_at > \\$4\\)",
+		).
+			ExpectQuery().
+			WithArgs("sub:alice", "abc", "CreateRequestV1", now.Add(-time.Hour)).
+			WillReturnRows(sqlmock.NewRows([]string{"request_ids"}).AddRow([]byte("[10,11]")))
+
+		ids, found, err := s.Get(ctx, key)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(found).To(BeTrue())
+		Expect(ids).To(Equal([]uint64{10, 11}))
+	})
+
+	It("Does not find unknown keys", func() {
+		dbMock.ExpectPrepare("SELECT request_ids FROM idempotency_keys").
+			ExpectQuery().
+			WillReturnRows(sqlmock.NewRows([]string{"request_ids"}))
+
+		_, found, err := s.Get(ctx, key)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(found).To(BeFalse())
+	})
+
+	It("Reserves a new or expired key within a transaction", func() {
+		dbMock.ExpectBegin()
+		dbMock.ExpectQuery(
+			"INSERT INTO idempotency_keys \\(caller,method,key,request_ids,created_at\\) VALUES \\(\\$1,\\$2,\\$3,\\$4,\\$5\\) "+
+				"ON CONFLICT \\(caller, method, key\\) DO UPDATE .* WHERE idempotency_keys.created_at <= \\$6 RETURNING key",
+		).
+			WithArgs("sub:alice", "CreateRequestV1", "abc", "[]", now, now.Add(-time.Hour)).
+			WillReturnRows(sqlmock.NewRows([]string{"key"}).AddRow("abc"))
+		dbMock.ExpectExec(
+			"UPDATE idempotency_keys SET request_ids = \\$1 WHERE caller = \\$2 AND key = \\$3 AND method = \\$4",
+		).
+			WithArgs("[10,11]", "sub:alice", "abc", "CreateRequestV1").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		dbMock.ExpectCommit()
+
+		tx := begin()
+		ids, reserved, err := s.Reserve(ctx, tx, key)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(reserved).To(BeTrue())
+		Expect(ids).To(BeEmpty())
+		Expect(s.Complete(ctx, tx, key, []uint64{10, 11})).To(Succeed())
+		Expect(commit()).To(Succeed())
+	})
+
+	It("Returns ids created with a key reserved earlier", func() {
+		dbMock.ExpectBegin()
+		dbMock.ExpectQuery("INSERT INTO idempotency_keys").
+			WillReturnRows(sqlmock.NewRows([]string{"key"}))
+		dbMock.ExpectQuery(
+			"SELECT request_ids FROM idempotency_keys WHERE \\(caller = \\$1 AND key = \\$2 AND method = \\$3\\)",
+		).
+			WithArgs("sub:alice", "abc", "CreateRequestV1").
+			WillReturnRows(sqlmock.NewRows([]string{"request_ids"}).AddRow([]byte("[10,11]")))
+		dbMock.ExpectCommit()
+
+		tx := begin()
+		ids, reserved, err := s.Reserve(ctx, tx, key)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(reserved).To(BeFalse())
+		Expect(ids).To(Equal([]uint64{10, 11}))
+		Expect(commit()).To(Succeed())
+	})
+
+	It("Purges expired keys", func() {
+		dbMock.ExpectPrepare("DELETE FROM idempotency_keys WHERE created_at <= \\$1").
+			ExpectExec().
+			WithArgs(now.Add(-time.Hour)).
+			WillReturnResult(sqlmock.NewResult(0, 3))
+
+		purged, err := s.Purge(ctx)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(purged).To(Equal(int64(3)))
+	})
+
+	It("Scopes keys by the caller", func() {
+		Expect(NewKey(ctx, "MultiCreateRequestV1", "abc", 20, 10, 20)).
+			To(Equal(Key{Method: "MultiCreateRequestV1", Caller: "users:10,20", Value: "abc"}))
+
+		ctx = identity.NewContext(ctx, identity.Identity{Subject: "alice", Role: identity.Student, UserId: 10})
+		Expect(NewKey(ctx, "MultiCreateRequestV1", "abc", 10)).
+			To(Equal(Key{Method: "MultiCreateRequestV1", Caller: "sub:alice", Value: "abc"}))
+	})
+
+	It("Reads a key from gRPC metadata", func() {
+		Expect(KeyFromContext(ctx)).To(BeEmpty())
+
+		ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("Idempotency-Key", "abc"))
+		Expect(KeyFromContext(ctx)).To(Equal("abc"))
+	})
+})
@@ -0,0 +1,48 @@
+package identity
+
+import (
+	"context"
+	"fmt"
+)
+
+// Role defines what a caller is allowed to do
+type Role string
+
+const (
+	// Student works with own requests only
+	Student Role = "student"
+	// Operator reads and updates requests of all users
+	Operator Role = "operator"
+	// Admin is allowed to do anything, including removal of requests
+	Admin Role = "admin"
+)
+
+// ParseRole returns a Role by its name
+func ParseRole(name string) (Role, error) {
+	switch role := Role(name); role {
+	case Student, Operator, Admin:
+		return role, nil
+	default:
+		return "", fmt.Errorf("unknown role %q, expected student, operator or admin", name)
+	}
+}
+
+// Identity describes a caller of the API
+type Identity struct {
+	Subject string // unique caller's name
+	Role    Role
+	UserId  uint64 // user the caller acts as, always set for students
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of `ctx` that carries a given caller's Identity
+func NewContext(ctx context.Context, id Identity) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns Identity of the caller stored in `ctx`. Returns false if caller is unknown.
+func FromContext(ctx context.Context) (Identity, bool) {
+	id, ok := ctx.Value(contextKey{}).(Identity)
+	return id, ok
+}
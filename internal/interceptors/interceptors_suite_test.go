@@ -0,0 +1,13 @@
+package interceptors_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestInterceptors(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Interceptors Suite")
+}
@@ -0,0 +1,179 @@
+package interceptors_test
+
+import (
+	"context"
+	"fmt"
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/ozoncp/ocp-request-api/internal/interceptors"
+	"github.com/ozoncp/ocp-request-api/internal/mocks"
+	"github.com/ozoncp/ocp-request-api/internal/tracing"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	otelCodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// headerStream records response headers set by interceptors
+type headerStream struct {
+	grpc.ServerTransportStream
+	header metadata.MD
+}
+
+func (s *headerStream) SetHeader(md metadata.MD) error {
+	s.header = metadata.Join(s.header, md)
+	return nil
+}
+
+var _ = Describe("Interceptors", func() {
+
+	var (
+		ctx    context.Context
+		info   *grpc.UnaryServerInfo
+		stream *headerStream
+	)
+
+	succeeding := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return "ok", nil
+	}
+
+	BeforeEach(func() {
+		stream = &headerStream{}
+		ctx = grpc.NewContextWithServerTransportStream(context.Background(), stream)
+		info = &grpc.UnaryServerInfo{FullMethod: "/ocp.request.api.OcpRequestApi/DescribeRequestV1"}
+	})
+
+	It("Assigns request id and passes a logger with it to the handler", func() {
+		var (
+			requestId string
+			logger    *zerolog.Logger
+		)
+		handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+			requestId = interceptors.RequestIdFromContext(ctx)
+			logger = log.Ctx(ctx)
+			return "ok", nil
+		}
+
+		_, err := interceptors.Logging()(ctx, nil, info, handler)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(requestId).ToNot(BeEmpty())
+		Expect(stream.header.Get(interceptors.RequestIdMetadataKey)).To(Equal([]string{requestId}))
+		Expect(logger.GetLevel()).ToNot(Equal(zerolog.Disabled))
+
+		ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("x-request-id", "client-id"))
+		_, err = interceptors.Logging()(ctx, nil, info, handler)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(requestId).To(Equal("client-id"))
+	})
+
+	Describe("Tracing", func() {
+
+		var (
+			recorder *tracetest.SpanRecorder
+			tracer   trace.Tracer
+			parent   trace.Span
+		)
+
+		BeforeEach(func() {
+			otel.SetTextMapPropagator(tracing.NewPropagator())
+			recorder = tracetest.NewSpanRecorder()
+			tracer = sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)).Tracer("test")
+			_, parent = tracer.Start(context.Background(), "client")
+		})
+
+		AfterEach(func() {
+			otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator())
+		})
+
+		It("Continues a trace of the client", func() {
+			carrier := propagation.MapCarrier{}
+			otel.GetTextMapPropagator().Inject(trace.ContextWithSpan(context.Background(), parent), carrier)
+			ctx = metadata.NewIncomingContext(ctx, metadata.New(carrier))
+
+			var handlerSpan trace.Span
+			_, err := interceptors.Tracing(tracer)(ctx, nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+				handlerSpan = trace.SpanFromContext(ctx)
+				return nil, status.Error(codes.NotFound, "not found")
+			})
+			Expect(err).To(HaveOccurred())
+
+			finished := recorder.Ended()
+			Expect(finished).To(HaveLen(1))
+			Expect(finished[0].SpanContext()).To(Equal(handlerSpan.SpanContext()))
+			Expect(finished[0].Name()).To(Equal("DescribeRequestV1"))
+			Expect(finished[0].Parent().SpanID()).To(Equal(parent.SpanContext().SpanID()))
+			Expect(finished[0].SpanKind()).To(Equal(trace.SpanKindServer))
+			Expect(finished[0].Status().Code).To(Equal(otelCodes.Error))
+			Expect(finished[0].Attributes()).To(ContainElement(attribute.String("grpc.code", "NotFound")))
+		})
+
+		It("Continues traces passed with traceparent or uber-trace-id", func() {
+			sc := parent.SpanContext()
+			for _, md := range []metadata.MD{
+				metadata.Pairs("traceparent", fmt.Sprintf("00-%v-%v-01", sc.TraceID(), sc.SpanID())),
+				metadata.Pairs("uber-trace-id", fmt.Sprintf("%v:%v:0:1", sc.TraceID(), sc.SpanID())),
+			} {
+				_, err := interceptors.Tracing(tracer)(metadata.NewIncomingContext(ctx, md), nil, info, succeeding)
+				Expect(err).ToNot(HaveOccurred())
+			}
+
+			spans := recorder.Ended()
+			Expect(spans).To(HaveLen(2))
+			for _, span := range spans {
+				Expect(span.SpanContext().TraceID()).To(Equal(sc.TraceID()))
+				Expect(span.Parent().SpanID()).To(Equal(sc.SpanID()))
+			}
+		})
+
+		It("Starts a new trace if the client passed none", func() {
+			_, err := interceptors.Tracing(tracer)(ctx, nil, info, succeeding)
+			Expect(err).ToNot(HaveOccurred())
+
+			spans := recorder.Ended()
+			Expect(spans).To(HaveLen(1))
+			Expect(spans[0].Parent().IsValid()).To(BeFalse())
+			Expect(spans[0].SpanContext().TraceID()).ToNot(Equal(parent.SpanContext().TraceID()))
+		})
+	})
+
+	It("Reports calls in flight, duration and status code of calls", func() {
+		mockCtrl := gomock.NewController(GinkgoT())
+		defer mockCtrl.Finish()
+		reporter := mocks.NewMockGrpcMetricsReporter(mockCtrl)
+		gomock.InOrder(
+			reporter.EXPECT().CallStarted("DescribeRequestV1"),
+			reporter.EXPECT().ObserveCall("DescribeRequestV1", "OK", gomock.Any()),
+			reporter.EXPECT().CallStarted("DescribeRequestV1"),
+			reporter.EXPECT().ObserveCall("DescribeRequestV1", "NotFound", gomock.Any()),
+		)
+
+		_, err := interceptors.Metrics(reporter)(ctx, nil, info, succeeding)
+		Expect(err).ToNot(HaveOccurred())
+		_, err = interceptors.Metrics(reporter)(ctx, nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+			return nil, status.Error(codes.NotFound, "not found")
+		})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("Turns a panic into Internal error", func() {
+		_, err := interceptors.Recovery()(ctx, nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+			var request *struct{ Id uint64 }
+			return request.Id, nil
+		})
+		Expect(status.Code(err)).To(Equal(codes.Internal))
+
+		resp, err := interceptors.Recovery()(ctx, nil, info, succeeding)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(resp).To(Equal("ok"))
+	})
+})
@@ -0,0 +1,70 @@
+package interceptors
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"path"
+	"time"
+)
+
+// RequestIdMetadataKey is a metadata key of a request id. The gateway maps X-Request-Id HTTP header to it.
+const RequestIdMetadataKey = "x-request-id"
+
+type requestIdKey struct{}
+
+// RequestIdFromContext returns id of the request being handled. Returns an empty string outside of a call.
+func RequestIdFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIdKey{}).(string)
+	return id
+}
+
+// Logging assigns an id to every call and logs its result. The id is taken from x-request-id metadata if the client
+// passed one and returned in x-request-id response header. Handlers get a logger with request id with log.Ctx(ctx).
+func Logging() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		started := time.Now()
+		requestId := incomingRequestId(ctx)
+		if requestId == "" {
+			requestId = uuid.New().String()
+		}
+		// fails only if the call is not a gRPC one, logs have the id anyway
+		_ = grpc.SetHeader(ctx, metadata.Pairs(RequestIdMetadataKey, requestId))
+
+		logger := log.With().
+			Str("request_id", requestId).
+			Str("method", path.Base(info.FullMethod)).
+			Logger()
+		ctx = logger.WithContext(context.WithValue(ctx, requestIdKey{}, requestId))
+
+		logger.Debug().Msgf("Got request: %v", req)
+		resp, err := handler(ctx, req)
+
+		var event *zerolog.Event
+		if err != nil {
+			event = logger.Warn().Err(err)
+		} else {
+			event = logger.Info()
+		}
+		event.
+			Str("code", status.Code(err).String()).
+			Dur("duration", time.Since(started)).
+			Msg("Handled request")
+		return resp, err
+	}
+}
+
+func incomingRequestId(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	if values := md.Get(RequestIdMetadataKey); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
@@ -0,0 +1,22 @@
+package interceptors
+
+import (
+	"context"
+	"github.com/ozoncp/ocp-request-api/internal/metrics"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+	"path"
+	"time"
+)
+
+// Metrics reports calls in flight, duration and status code of every call
+func Metrics(reporter metrics.GrpcMetricsReporter) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		method := path.Base(info.FullMethod)
+		reporter.CallStarted(method)
+		started := time.Now()
+		resp, err := handler(ctx, req)
+		reporter.ObserveCall(method, status.Code(err).String(), time.Since(started))
+		return resp, err
+	}
+}
@@ -0,0 +1,27 @@
+package interceptors
+
+import (
+	"context"
+	"fmt"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"runtime/debug"
+)
+
+// Recovery turns a panic of a handler into Internal error, so that a single bad call does not crash the service
+func Recovery() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				log.Ctx(ctx).Error().
+					Str("panic", fmt.Sprint(recovered)).
+					Bytes("stack", debug.Stack()).
+					Msg("Handler panicked")
+				err = status.Error(codes.Internal, "internal error")
+			}
+		}()
+		return handler(ctx, req)
+	}
+}
@@ -0,0 +1,63 @@
+package interceptors
+
+import (
+	"context"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	otelCodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"path"
+)
+
+// metadataCarrier reads and writes trace context from/to gRPC metadata
+type metadataCarrier metadata.MD
+
+// Get implements propagation.TextMapCarrier
+func (c metadataCarrier) Get(key string) string {
+	if values := metadata.MD(c).Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// Set implements propagation.TextMapCarrier
+func (c metadataCarrier) Set(key, val string) {
+	metadata.MD(c).Set(key, val)
+}
+
+// Keys implements propagation.TextMapCarrier
+func (c metadataCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for key := range c {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// Tracing starts a span per call named by the method. The span is a child of a span passed by the client
+// with incoming metadata (traceparent or uber-trace-id, read by the global propagator), so traces continue across services.
+func Tracing(tracer trace.Tracer) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		// a client may send no trace context, the call starts a new trace then
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			ctx = otel.GetTextMapPropagator().Extract(ctx, metadataCarrier(md))
+		}
+
+		ctx, span := tracer.Start(ctx, path.Base(info.FullMethod), trace.WithSpanKind(trace.SpanKindServer))
+		defer span.End()
+		if requestId := RequestIdFromContext(ctx); requestId != "" {
+			span.SetAttributes(attribute.String("request_id", requestId))
+		}
+
+		resp, err := handler(ctx, req)
+		if err != nil {
+			span.SetAttributes(attribute.String("grpc.code", status.Code(err).String()))
+			span.RecordError(err)
+			span.SetStatus(otelCodes.Error, err.Error())
+		}
+		return resp, err
+	}
+}
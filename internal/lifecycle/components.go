@@ -0,0 +1,142 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"google.golang.org/grpc"
+	"net"
+	"net/http"
+	"time"
+)
+
+// HTTPServer returns a component serving HTTP calls with `server`, HTTPS if its TLSConfig is set.
+// Running calls are completed on stop, the server is closed if they are not completed in time.
+func HTTPServer(server *http.Server) Component {
+	return &httpServer{server: server}
+}
+
+type httpServer struct {
+	server *http.Server
+}
+
+func (s *httpServer) Start() error {
+	var err error
+	if s.server.TLSConfig != nil {
+		// certificates are provided by TLSConfig
+		err = s.server.ListenAndServeTLS("", "")
+	} else {
+		err = s.server.ListenAndServe()
+	}
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+func (s *httpServer) Stop(ctx context.Context) error {
+	if err := s.server.Shutdown(ctx); err != nil {
+		s.server.Close()
+		return err
+	}
+	return nil
+}
+
+// GRPCServer returns a component serving gRPC calls with `server` on `listener`.
+// Running calls are completed on stop, the server is stopped forcibly if they are not completed in time.
+func GRPCServer(server *grpc.Server, listener net.Listener) Component {
+	return &grpcServer{server: server, listener: listener}
+}
+
+type grpcServer struct {
+	server   *grpc.Server
+	listener net.Listener
+}
+
+func (s *grpcServer) Start() error {
+	if err := s.server.Serve(s.listener); err != nil && !errors.Is(err, grpc.ErrServerStopped) {
+		return err
+	}
+	return nil
+}
+
+func (s *grpcServer) Stop(ctx context.Context) error {
+	stopped := make(chan struct{})
+	go func() {
+		s.server.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		s.server.Stop()
+		return ctx.Err()
+	}
+}
+
+// Worker returns a component running `run` in background until stopped. `run` must return once its context is done.
+func Worker(run func(ctx context.Context)) Component {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &worker{run: run, ctx: ctx, cancel: cancel, done: make(chan struct{})}
+}
+
+type worker struct {
+	run    func(ctx context.Context)
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func (w *worker) Start() error {
+	defer close(w.done)
+	w.run(w.ctx)
+	return nil
+}
+
+func (w *worker) Stop(ctx context.Context) error {
+	w.cancel()
+	select {
+	case <-w.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Closer returns a component with nothing to run, `close` releases its resources on stop
+func Closer(close func(ctx context.Context) error) Component {
+	return closer(close)
+}
+
+type closer func(ctx context.Context) error
+
+func (c closer) Start() error {
+	return nil
+}
+
+func (c closer) Stop(ctx context.Context) error {
+	return c(ctx)
+}
+
+// Delay returns a component with nothing to run that waits for `delay` on stop, so that components
+// stopped before it take effect before the ones stopped after it, e.g. load balancers notice the service is not ready.
+func Delay(delay time.Duration) Component {
+	return delayer(delay)
+}
+
+type delayer time.Duration
+
+func (d delayer) Start() error {
+	return nil
+}
+
+func (d delayer) Stop(ctx context.Context) error {
+	timer := time.NewTimer(time.Duration(d))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
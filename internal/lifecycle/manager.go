@@ -0,0 +1,96 @@
+package lifecycle
+
+import (
+	"context"
+	"fmt"
+	"github.com/rs/zerolog/log"
+	"sync"
+	"time"
+)
+
+// Component is a part of the service started and stopped by a Manager
+type Component interface {
+	// Start runs the component until it is stopped. Returns nil if it is stopped by Stop.
+	Start() error
+	// Stop makes Start return. Pending work is completed unless `ctx` is done first.
+	Stop(ctx context.Context) error
+}
+
+// Manager starts components of the service and stops them on shutdown
+type Manager interface {
+	// Add registers a component. Components are stopped in the order they are added.
+	Add(name string, component Component)
+	// Run starts all components and waits until `ctx` is done or one of components fails.
+	// Then components are stopped one by one within the shutdown timeout.
+	// Returns an error of a failed component or the first error of stopping.
+	Run(ctx context.Context) error
+}
+
+// NewManager creates a Manager given `timeout` to stop all components
+func NewManager(timeout time.Duration) Manager {
+	return &manager{timeout: timeout}
+}
+
+type namedComponent struct {
+	name string
+	Component
+}
+
+type manager struct {
+	timeout    time.Duration
+	components []namedComponent
+}
+
+func (m *manager) Add(name string, component Component) {
+	m.components = append(m.components, namedComponent{name: name, Component: component})
+}
+
+func (m *manager) Run(ctx context.Context) error {
+	failed := make(chan error, len(m.components))
+	var running sync.WaitGroup
+	for _, c := range m.components {
+		running.Add(1)
+		go func(c namedComponent) {
+			defer running.Done()
+			if err := c.Start(); err != nil {
+				failed <- fmt.Errorf("%v failed: %w", c.name, err)
+			}
+		}(c)
+	}
+
+	var err error
+	select {
+	case <-ctx.Done():
+		log.Info().Msgf("Stopping...")
+	case err = <-failed:
+		log.Error().Err(err).Msgf("Stopping after a failure...")
+	}
+
+	// every component is asked to stop even if the timeout is exceeded, so that it releases its resources
+	stopCtx, cancel := context.WithTimeout(context.Background(), m.timeout)
+	defer cancel()
+	for _, c := range m.components {
+		if stopErr := c.Stop(stopCtx); stopErr != nil {
+			log.Error().Err(stopErr).Str("component", c.name).Msgf("Failed to stop gracefully")
+			if err == nil {
+				err = fmt.Errorf("failed to stop %v: %w", c.name, stopErr)
+			}
+			continue
+		}
+		log.Info().Str("component", c.name).Msgf("Stopped")
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		running.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-stopCtx.Done():
+		if err == nil {
+			err = fmt.Errorf("components are still running after %v", m.timeout)
+		}
+	}
+	return err
+}
@@ -0,0 +1,154 @@
+package lifecycle_test
+
+import (
+	"context"
+	"errors"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/ozoncp/ocp-request-api/internal/lifecycle"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// fakeComponent runs until stopped or failed, stops are recorded to `stopped`
+type fakeComponent struct {
+	name    string
+	stopped *[]string
+	lock    *sync.Mutex
+	fail    chan error
+	done    chan struct{}
+	stop    func(ctx context.Context) error
+}
+
+func (c *fakeComponent) Start() error {
+	select {
+	case err := <-c.fail:
+		return err
+	case <-c.done:
+		return nil
+	}
+}
+
+func (c *fakeComponent) Stop(ctx context.Context) error {
+	c.lock.Lock()
+	*c.stopped = append(*c.stopped, c.name)
+	c.lock.Unlock()
+	close(c.done)
+	if c.stop != nil {
+		return c.stop(ctx)
+	}
+	return nil
+}
+
+var _ = Describe("Manager", func() {
+
+	var (
+		manager    lifecycle.Manager
+		lock       sync.Mutex
+		stopped    []string
+		components map[string]*fakeComponent
+	)
+
+	stoppedNames := func() []string {
+		lock.Lock()
+		defer lock.Unlock()
+		return append([]string(nil), stopped...)
+	}
+
+	BeforeEach(func() {
+		manager = lifecycle.NewManager(100 * time.Millisecond)
+		stopped = nil
+		components = map[string]*fakeComponent{}
+		for _, name := range []string{"http", "grpc", "db"} {
+			components[name] = &fakeComponent{
+				name: name, stopped: &stopped, lock: &lock, fail: make(chan error, 1), done: make(chan struct{}),
+			}
+			manager.Add(name, components[name])
+		}
+	})
+
+	It("Stops components in order once context is done", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		result := make(chan error, 1)
+		go func() {
+			result <- manager.Run(ctx)
+		}()
+
+		Consistently(result, "50ms").ShouldNot(Receive())
+		cancel()
+		Eventually(result).Should(Receive(BeNil()))
+		Expect(stoppedNames()).To(Equal([]string{"http", "grpc", "db"}))
+	})
+
+	It("Stops components when one of them fails", func() {
+		failure := errors.New("address already in use")
+		components["grpc"].fail <- failure
+
+		err := manager.Run(context.Background())
+		Expect(errors.Is(err, failure)).To(BeTrue())
+		Expect(stoppedNames()).To(Equal([]string{"http", "grpc", "db"}))
+	})
+
+	It("Stops remaining components when shutdown timeout is exceeded", func() {
+		components["grpc"].stop = func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		started := time.Now()
+		err := manager.Run(ctx)
+		Expect(errors.Is(err, context.DeadlineExceeded)).To(BeTrue())
+		Expect(time.Since(started)).To(BeNumerically("<", time.Second))
+		Expect(stoppedNames()).To(Equal([]string{"http", "grpc", "db"}))
+	})
+})
+
+var _ = Describe("Components", func() {
+
+	It("Worker waits for its function to return", func() {
+		returned := make(chan struct{})
+		worker := lifecycle.Worker(func(ctx context.Context) {
+			defer close(returned)
+			<-ctx.Done()
+		})
+		go worker.Start()
+
+		Expect(worker.Stop(context.Background())).To(Succeed())
+		Expect(returned).To(BeClosed())
+	})
+
+	It("Worker gives up waiting once context is done", func() {
+		worker := lifecycle.Worker(func(ctx context.Context) {
+			time.Sleep(time.Second)
+		})
+		go worker.Start()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		Expect(worker.Stop(ctx)).To(MatchError(context.DeadlineExceeded))
+	})
+
+	It("Delay waits on stop until context is done", func() {
+		started := time.Now()
+		Expect(lifecycle.Delay(20 * time.Millisecond).Stop(context.Background())).To(Succeed())
+		Expect(time.Since(started)).To(BeNumerically(">=", 20*time.Millisecond))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		Expect(lifecycle.Delay(time.Second).Stop(ctx)).To(MatchError(context.DeadlineExceeded))
+	})
+
+	It("HTTPServer stops serving without an error", func() {
+		server := lifecycle.HTTPServer(&http.Server{Addr: "127.0.0.1:0"})
+		result := make(chan error, 1)
+		go func() {
+			result <- server.Start()
+		}()
+
+		Expect(server.Stop(context.Background())).To(Succeed())
+		Eventually(result).Should(Receive(BeNil()))
+	})
+})
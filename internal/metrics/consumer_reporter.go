@@ -0,0 +1,29 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// ConsumerMetricsReporter reports events read from Kafka by their type
+type ConsumerMetricsReporter interface {
+	IncConsumed(eventType string)
+}
+
+type promConsumerReporter struct {
+	consumedCounter *prometheus.CounterVec
+}
+
+// NewConsumerMetricsReporter creates a reporter that exports consumed events counters to Prometheus
+func NewConsumerMetricsReporter() ConsumerMetricsReporter {
+	return &promConsumerReporter{
+		consumedCounter: promauto.NewCounterVec(prometheus.CounterOpts{
+			Name: "requests_events_consumed",
+			Help: "The total number of consumed events by event type",
+		}, []string{"event"}),
+	}
+}
+
+func (p *promConsumerReporter) IncConsumed(eventType string) {
+	p.consumedCounter.With(prometheus.Labels{"event": eventType}).Inc()
+}
@@ -0,0 +1,79 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"sync/atomic"
+	"time"
+)
+
+// FlushMetricsReporter reports Saver queue state, results of flushes made by Flusher
+// and requests the Saver retries after a failed flush
+type FlushMetricsReporter interface {
+	SetQueueDepth(depth int)
+	ObserveFlush(batchSize int, failed int, duration time.Duration)
+	IncRetried(v uint)
+}
+
+type promFlushReporter struct {
+	queueDepth     prometheus.Gauge
+	batchSize      prometheus.Histogram
+	flushDuration  prometheus.Histogram
+	failedCounter  prometheus.Counter
+	retriedCounter prometheus.Counter
+	lastSuccess    int64 // unix nanoseconds of the last successful flush
+}
+
+// NewFlushMetricsReporter creates a reporter that exports Saver and Flusher metrics to Prometheus
+func NewFlushMetricsReporter() FlushMetricsReporter {
+	p := &promFlushReporter{
+		queueDepth: promauto.NewGauge(prometheus.GaugeOpts{
+			Name: "requests_saver_queue_depth",
+			Help: "The number of requests waiting in the Saver to be flushed",
+		}),
+		batchSize: promauto.NewHistogram(prometheus.HistogramOpts{
+			Name:    "requests_flush_batch_size",
+			Help:    "The number of requests passed to a single flush",
+			Buckets: prometheus.ExponentialBuckets(1, 4, 8),
+		}),
+		flushDuration: promauto.NewHistogram(prometheus.HistogramOpts{
+			Name:    "requests_flush_duration_seconds",
+			Help:    "Time spent to flush a batch of requests",
+			Buckets: prometheus.DefBuckets,
+		}),
+		failedCounter: promauto.NewCounter(prometheus.CounterOpts{
+			Name: "requests_flush_failed_items",
+			Help: "The total number of requests failed to be flushed",
+		}),
+		retriedCounter: promauto.NewCounter(prometheus.CounterOpts{
+			Name: "requests_flush_retried_items",
+			Help: "The total number of failed requests scheduled for the next flush",
+		}),
+		lastSuccess: time.Now().UnixNano(),
+	}
+
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "requests_flush_seconds_since_last_success",
+		Help: "Time passed since the last flush with no failed requests",
+	}, func() float64 {
+		return time.Since(time.Unix(0, atomic.LoadInt64(&p.lastSuccess))).Seconds()
+	})
+	return p
+}
+
+func (p *promFlushReporter) SetQueueDepth(depth int) {
+	p.queueDepth.Set(float64(depth))
+}
+
+func (p *promFlushReporter) ObserveFlush(batchSize int, failed int, duration time.Duration) {
+	p.batchSize.Observe(float64(batchSize))
+	p.flushDuration.Observe(duration.Seconds())
+	p.failedCounter.Add(float64(failed))
+	if failed == 0 {
+		atomic.StoreInt64(&p.lastSuccess, time.Now().UnixNano())
+	}
+}
+
+func (p *promFlushReporter) IncRetried(v uint) {
+	p.retriedCounter.Add(float64(v))
+}
@@ -0,0 +1,60 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"google.golang.org/grpc/codes"
+	"time"
+)
+
+// GrpcMetricsReporter reports results of gRPC calls
+type GrpcMetricsReporter interface {
+	// CallStarted reports a call of `method` is in flight
+	CallStarted(method string)
+	// ObserveCall reports a call of `method` started with CallStarted completed with a status `code` in `duration`
+	ObserveCall(method, code string, duration time.Duration)
+}
+
+type promGrpcReporter struct {
+	durationHistogram *prometheus.HistogramVec
+	callsCounter      *prometheus.CounterVec
+	errorsCounter     *prometheus.CounterVec
+	inFlightGauge     *prometheus.GaugeVec
+}
+
+// NewGrpcMetricsReporter creates a reporter that exports latency histograms, status code counters
+// and in-flight gauges to Prometheus
+func NewGrpcMetricsReporter() GrpcMetricsReporter {
+	return &promGrpcReporter{
+		durationHistogram: promauto.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "requests_grpc_call_duration_seconds",
+			Help:    "Duration of gRPC calls by method",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"method"}),
+		callsCounter: promauto.NewCounterVec(prometheus.CounterOpts{
+			Name: "requests_grpc_calls",
+			Help: "The total number of gRPC calls by method and status code",
+		}, []string{"method", "code"}),
+		errorsCounter: promauto.NewCounterVec(prometheus.CounterOpts{
+			Name: "requests_grpc_errors",
+			Help: "The total number of gRPC calls failed with a status code other than OK by method and status code",
+		}, []string{"method", "code"}),
+		inFlightGauge: promauto.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "requests_grpc_calls_in_flight",
+			Help: "The number of gRPC calls being handled by method",
+		}, []string{"method"}),
+	}
+}
+
+func (p *promGrpcReporter) CallStarted(method string) {
+	p.inFlightGauge.With(prometheus.Labels{"method": method}).Inc()
+}
+
+func (p *promGrpcReporter) ObserveCall(method, code string, duration time.Duration) {
+	p.inFlightGauge.With(prometheus.Labels{"method": method}).Dec()
+	p.durationHistogram.With(prometheus.Labels{"method": method}).Observe(duration.Seconds())
+	p.callsCounter.With(prometheus.Labels{"method": method, "code": code}).Inc()
+	if code != codes.OK.String() {
+		p.errorsCounter.With(prometheus.Labels{"method": method, "code": code}).Inc()
+	}
+}
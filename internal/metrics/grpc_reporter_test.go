@@ -0,0 +1,30 @@
+package metrics
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"time"
+)
+
+var _ = Describe("GrpcMetricsReporter", func() {
+
+	// metrics are registered globally, so the reporter is created once
+	reporter := NewGrpcMetricsReporter().(*promGrpcReporter)
+
+	It("Counts calls in flight and failed calls by status code", func() {
+		reporter.CallStarted("CreateRequestV1")
+		reporter.CallStarted("CreateRequestV1")
+		Expect(testutil.ToFloat64(reporter.inFlightGauge.WithLabelValues("CreateRequestV1"))).To(Equal(2.0))
+
+		reporter.ObserveCall("CreateRequestV1", "OK", time.Millisecond)
+		reporter.ObserveCall("CreateRequestV1", "InvalidArgument", time.Millisecond)
+
+		Expect(testutil.ToFloat64(reporter.inFlightGauge.WithLabelValues("CreateRequestV1"))).To(BeZero())
+		Expect(testutil.ToFloat64(reporter.callsCounter.WithLabelValues("CreateRequestV1", "OK"))).To(Equal(1.0))
+		Expect(testutil.ToFloat64(reporter.callsCounter.WithLabelValues("CreateRequestV1", "InvalidArgument"))).To(Equal(1.0))
+		Expect(testutil.ToFloat64(reporter.errorsCounter.WithLabelValues("CreateRequestV1", "InvalidArgument"))).To(Equal(1.0))
+		Expect(testutil.CollectAndCount(reporter.errorsCounter)).To(Equal(1))
+		Expect(testutil.CollectAndCount(reporter.durationHistogram)).To(Equal(1))
+	})
+})
@@ -0,0 +1,49 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// ProducerMetricsReporter reports results of events delivery to Kafka
+type ProducerMetricsReporter interface {
+	IncDelivered(v uint)
+	IncFailed(v uint)
+	IncDropped(v uint)
+}
+
+type promProducerReporter struct {
+	deliveredCounter prometheus.Counter
+	failedCounter    prometheus.Counter
+	droppedCounter   prometheus.Counter
+}
+
+// NewProducerMetricsReporter creates a reporter that exports events delivery metrics to Prometheus
+func NewProducerMetricsReporter() ProducerMetricsReporter {
+	return &promProducerReporter{
+		deliveredCounter: promauto.NewCounter(prometheus.CounterOpts{
+			Name: "requests_events_delivered",
+			Help: "The total number of events acknowledged by Kafka",
+		}),
+		failedCounter: promauto.NewCounter(prometheus.CounterOpts{
+			Name: "requests_events_failed",
+			Help: "The total number of events Kafka failed to accept",
+		}),
+		droppedCounter: promauto.NewCounter(prometheus.CounterOpts{
+			Name: "requests_events_dropped",
+			Help: "The total number of events dropped because producer buffer was full",
+		}),
+	}
+}
+
+func (p *promProducerReporter) IncDelivered(v uint) {
+	p.deliveredCounter.Add(float64(v))
+}
+
+func (p *promProducerReporter) IncFailed(v uint) {
+	p.failedCounter.Add(float64(v))
+}
+
+func (p *promProducerReporter) IncDropped(v uint) {
+	p.droppedCounter.Add(float64(v))
+}
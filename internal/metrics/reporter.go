@@ -5,7 +5,10 @@ import (
 	"github.com/prometheus/client_golang/prometheus/promauto"
 )
 
+// MetricsReporter reports numbers of requests handled by API handlers, as well as latency, status codes
+// and in-flight number of the handler calls
 type MetricsReporter interface {
+	GrpcMetricsReporter
 	IncCreate(v uint, handler string)
 	IncRemove(v uint, handler string)
 	IncUpdate(v uint, handler string)
@@ -14,6 +17,7 @@ type MetricsReporter interface {
 }
 
 type promReporter struct {
+	GrpcMetricsReporter
 	createCounter *prometheus.CounterVec
 	readCounter   *prometheus.CounterVec
 	updateCounter *prometheus.CounterVec
@@ -23,6 +27,7 @@ type promReporter struct {
 
 func NewMetricsReporter() MetricsReporter {
 	return &promReporter{
+		GrpcMetricsReporter: NewGrpcMetricsReporter(),
 		createCounter: promauto.NewCounterVec(prometheus.CounterOpts{
 			Name: "requests_objects_create",
 			Help: "The total number of create requests",
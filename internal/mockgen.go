@@ -4,5 +4,10 @@ package internal
 //go:generate mockgen -destination=./mocks/repo_mock.go -package=mocks github.com/ozoncp/ocp-request-api/internal/repo Repo
 //go:generate mockgen -destination=./mocks/saver_mock.go -package=mocks github.com/ozoncp/ocp-request-api/internal/saver Saver
 //go:generate mockgen -destination=./mocks/metrics_reporter_mock.go -package=mocks github.com/ozoncp/ocp-request-api/internal/metrics MetricsReporter
+//go:generate mockgen -destination=./mocks/flush_metrics_reporter_mock.go -package=mocks github.com/ozoncp/ocp-request-api/internal/metrics FlushMetricsReporter
+//go:generate mockgen -destination=./mocks/producer_metrics_reporter_mock.go -package=mocks github.com/ozoncp/ocp-request-api/internal/metrics ProducerMetricsReporter
+//go:generate mockgen -destination=./mocks/grpc_metrics_reporter_mock.go -package=mocks github.com/ozoncp/ocp-request-api/internal/metrics GrpcMetricsReporter
+//go:generate mockgen -destination=./mocks/consumer_metrics_reporter_mock.go -package=mocks github.com/ozoncp/ocp-request-api/internal/metrics ConsumerMetricsReporter
 //go:generate mockgen -destination=./mocks/producer_mock.go -package=mocks github.com/ozoncp/ocp-request-api/internal/producer Producer
 //go:generate mockgen -destination=./mocks/searcher_mock.go -package=mocks github.com/ozoncp/ocp-request-api/internal/search Searcher
+//go:generate mockgen -destination=./mocks/idempotency_store_mock.go -package=mocks github.com/ozoncp/ocp-request-api/internal/idempotency Store
@@ -0,0 +1,46 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: github.com/ozoncp/ocp-request-api/internal/metrics (interfaces: ConsumerMetricsReporter)
+
+// Package mocks is a generated GoMock package.
+package mocks
+
+import (
+	reflect "reflect"
+
+	gomock "github.com/golang/mock/gomock"
+)
+
+// MockConsumerMetricsReporter is a mock of ConsumerMetricsReporter interface.
+type MockConsumerMetricsReporter struct {
+	ctrl     *gomock.Controller
+	recorder *MockConsumerMetricsReporterMockRecorder
+}
+
+// MockConsumerMetricsReporterMockRecorder is the mock recorder for MockConsumerMetricsReporter.
+type MockConsumerMetricsReporterMockRecorder struct {
+	mock *MockConsumerMetricsReporter
+}
+
+// NewMockConsumerMetricsReporter creates a new mock instance.
+func NewMockConsumerMetricsReporter(ctrl *gomock.Controller) *MockConsumerMetricsReporter {
+	mock := &MockConsumerMetricsReporter{ctrl: ctrl}
+	mock.recorder = &MockConsumerMetricsReporterMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockConsumerMetricsReporter) EXPECT() *MockConsumerMetricsReporterMockRecorder {
+	return m.recorder
+}
+
+// IncConsumed mocks base method.
+func (m *MockConsumerMetricsReporter) IncConsumed(arg0 string) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "IncConsumed", arg0)
+}
+
+// IncConsumed indicates an expected call of IncConsumed.
+func (mr *MockConsumerMetricsReporterMockRecorder) IncConsumed(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "IncConsumed", reflect.TypeOf((*MockConsumerMetricsReporter)(nil).IncConsumed), arg0)
+}
@@ -0,0 +1,71 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: github.com/ozoncp/ocp-request-api/internal/metrics (interfaces: FlushMetricsReporter)
+
+// Package mocks is a generated GoMock package.
+package mocks
+
+import (
+	reflect "reflect"
+	time "time"
+
+	gomock "github.com/golang/mock/gomock"
+)
+
+// MockFlushMetricsReporter is a mock of FlushMetricsReporter interface.
+type MockFlushMetricsReporter struct {
+	ctrl     *gomock.Controller
+	recorder *MockFlushMetricsReporterMockRecorder
+}
+
+// MockFlushMetricsReporterMockRecorder is the mock recorder for MockFlushMetricsReporter.
+type MockFlushMetricsReporterMockRecorder struct {
+	mock *MockFlushMetricsReporter
+}
+
+// NewMockFlushMetricsReporter creates a new mock instance.
+func NewMockFlushMetricsReporter(ctrl *gomock.Controller) *MockFlushMetricsReporter {
+	mock := &MockFlushMetricsReporter{ctrl: ctrl}
+	mock.recorder = &MockFlushMetricsReporterMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockFlushMetricsReporter) EXPECT() *MockFlushMetricsReporterMockRecorder {
+	return m.recorder
+}
+
+// IncRetried mocks base method.
+func (m *MockFlushMetricsReporter) IncRetried(arg0 uint) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "IncRetried", arg0)
+}
+
+// IncRetried indicates an expected call of IncRetried.
+func (mr *MockFlushMetricsReporterMockRecorder) IncRetried(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "IncRetried", reflect.TypeOf((*MockFlushMetricsReporter)(nil).IncRetried), arg0)
+}
+
+// ObserveFlush mocks base method.
+func (m *MockFlushMetricsReporter) ObserveFlush(arg0, arg1 int, arg2 time.Duration) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "ObserveFlush", arg0, arg1, arg2)
+}
+
+// ObserveFlush indicates an expected call of ObserveFlush.
+func (mr *MockFlushMetricsReporterMockRecorder) ObserveFlush(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ObserveFlush", reflect.TypeOf((*MockFlushMetricsReporter)(nil).ObserveFlush), arg0, arg1, arg2)
+}
+
+// SetQueueDepth mocks base method.
+func (m *MockFlushMetricsReporter) SetQueueDepth(arg0 int) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "SetQueueDepth", arg0)
+}
+
+// SetQueueDepth indicates an expected call of SetQueueDepth.
+func (mr *MockFlushMetricsReporterMockRecorder) SetQueueDepth(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetQueueDepth", reflect.TypeOf((*MockFlushMetricsReporter)(nil).SetQueueDepth), arg0)
+}
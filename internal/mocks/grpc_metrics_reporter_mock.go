@@ -0,0 +1,59 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: github.com/ozoncp/ocp-request-api/internal/metrics (interfaces: GrpcMetricsReporter)
+
+// Package mocks is a generated GoMock package.
+package mocks
+
+import (
+	reflect "reflect"
+	time "time"
+
+	gomock "github.com/golang/mock/gomock"
+)
+
+// MockGrpcMetricsReporter is a mock of GrpcMetricsReporter interface.
+type MockGrpcMetricsReporter struct {
+	ctrl     *gomock.Controller
+	recorder *MockGrpcMetricsReporterMockRecorder
+}
+
+// MockGrpcMetricsReporterMockRecorder is the mock recorder for MockGrpcMetricsReporter.
+type MockGrpcMetricsReporterMockRecorder struct {
+	mock *MockGrpcMetricsReporter
+}
+
+// NewMockGrpcMetricsReporter creates a new mock instance.
+func NewMockGrpcMetricsReporter(ctrl *gomock.Controller) *MockGrpcMetricsReporter {
+	mock := &MockGrpcMetricsReporter{ctrl: ctrl}
+	mock.recorder = &MockGrpcMetricsReporterMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockGrpcMetricsReporter) EXPECT() *MockGrpcMetricsReporterMockRecorder {
+	return m.recorder
+}
+
+// CallStarted mocks base method.
+func (m *MockGrpcMetricsReporter) CallStarted(arg0 string) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "CallStarted", arg0)
+}
+
+// CallStarted indicates an expected call of CallStarted.
+func (mr *MockGrpcMetricsReporterMockRecorder) CallStarted(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CallStarted", reflect.TypeOf((*MockGrpcMetricsReporter)(nil).CallStarted), arg0)
+}
+
+// ObserveCall mocks base method.
+func (m *MockGrpcMetricsReporter) ObserveCall(arg0, arg1 string, arg2 time.Duration) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "ObserveCall", arg0, arg1, arg2)
+}
+
+// ObserveCall indicates an expected call of ObserveCall.
+func (mr *MockGrpcMetricsReporterMockRecorder) ObserveCall(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ObserveCall", reflect.TypeOf((*MockGrpcMetricsReporter)(nil).ObserveCall), arg0, arg1, arg2)
+}
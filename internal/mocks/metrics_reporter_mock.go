@@ -6,6 +6,7 @@ package mocks
 
 import (
 	reflect "reflect"
+	time "time"
 
 	gomock "github.com/golang/mock/gomock"
 )
@@ -33,6 +34,18 @@ func (m *MockMetricsReporter) EXPECT() *MockMetricsReporterMockRecorder {
 	return m.recorder
 }
 
+// CallStarted mocks base method.
+func (m *MockMetricsReporter) CallStarted(arg0 string) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "CallStarted", arg0)
+}
+
+// CallStarted indicates an expected call of CallStarted.
+func (mr *MockMetricsReporterMockRecorder) CallStarted(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CallStarted", reflect.TypeOf((*MockMetricsReporter)(nil).CallStarted), arg0)
+}
+
 // IncCreate mocks base method.
 func (m *MockMetricsReporter) IncCreate(arg0 uint, arg1 string) {
 	m.ctrl.T.Helper()
@@ -92,3 +105,15 @@ func (mr *MockMetricsReporterMockRecorder) IncUpdate(arg0, arg1 interface{}) *go
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "IncUpdate", reflect.TypeOf((*MockMetricsReporter)(nil).IncUpdate), arg0, arg1)
 }
+
+// ObserveCall mocks base method.
+func (m *MockMetricsReporter) ObserveCall(arg0, arg1 string, arg2 time.Duration) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "ObserveCall", arg0, arg1, arg2)
+}
+
+// ObserveCall indicates an expected call of ObserveCall.
+func (mr *MockMetricsReporterMockRecorder) ObserveCall(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ObserveCall", reflect.TypeOf((*MockMetricsReporter)(nil).ObserveCall), arg0, arg1, arg2)
+}
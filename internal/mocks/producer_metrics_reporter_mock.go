@@ -0,0 +1,70 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: github.com/ozoncp/ocp-request-api/internal/metrics (interfaces: ProducerMetricsReporter)
+
+// Package mocks is a generated GoMock package.
+package mocks
+
+import (
+	reflect "reflect"
+
+	gomock "github.com/golang/mock/gomock"
+)
+
+// MockProducerMetricsReporter is a mock of ProducerMetricsReporter interface.
+type MockProducerMetricsReporter struct {
+	ctrl     *gomock.Controller
+	recorder *MockProducerMetricsReporterMockRecorder
+}
+
+// MockProducerMetricsReporterMockRecorder is the mock recorder for MockProducerMetricsReporter.
+type MockProducerMetricsReporterMockRecorder struct {
+	mock *MockProducerMetricsReporter
+}
+
+// NewMockProducerMetricsReporter creates a new mock instance.
+func NewMockProducerMetricsReporter(ctrl *gomock.Controller) *MockProducerMetricsReporter {
+	mock := &MockProducerMetricsReporter{ctrl: ctrl}
+	mock.recorder = &MockProducerMetricsReporterMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockProducerMetricsReporter) EXPECT() *MockProducerMetricsReporterMockRecorder {
+	return m.recorder
+}
+
+// IncDelivered mocks base method.
+func (m *MockProducerMetricsReporter) IncDelivered(arg0 uint) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "IncDelivered", arg0)
+}
+
+// IncDelivered indicates an expected call of IncDelivered.
+func (mr *MockProducerMetricsReporterMockRecorder) IncDelivered(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "IncDelivered", reflect.TypeOf((*MockProducerMetricsReporter)(nil).IncDelivered), arg0)
+}
+
+// IncDropped mocks base method.
+func (m *MockProducerMetricsReporter) IncDropped(arg0 uint) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "IncDropped", arg0)
+}
+
+// IncDropped indicates an expected call of IncDropped.
+func (mr *MockProducerMetricsReporterMockRecorder) IncDropped(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "IncDropped", reflect.TypeOf((*MockProducerMetricsReporter)(nil).IncDropped), arg0)
+}
+
+// IncFailed mocks base method.
+func (m *MockProducerMetricsReporter) IncFailed(arg0 uint) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "IncFailed", arg0)
+}
+
+// IncFailed indicates an expected call of IncFailed.
+func (mr *MockProducerMetricsReporterMockRecorder) IncFailed(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "IncFailed", reflect.TypeOf((*MockProducerMetricsReporter)(nil).IncFailed), arg0)
+}
@@ -49,13 +49,15 @@ func (mr *MockProducerMockRecorder) Close() *gomock.Call {
 }
 
 // Send mocks base method.
-func (m *MockProducer) Send(arg0 ...producer.EventMsg) {
+func (m *MockProducer) Send(arg0 ...producer.EventMsg) error {
 	m.ctrl.T.Helper()
 	varargs := []interface{}{}
 	for _, a := range arg0 {
 		varargs = append(varargs, a)
 	}
-	m.ctrl.Call(m, "Send", varargs...)
+	ret := m.ctrl.Call(m, "Send", varargs...)
+	ret0, _ := ret[0].(error)
+	return ret0
 }
 
 // Send indicates an expected call of Send.
@@ -9,7 +9,9 @@ import (
 	reflect "reflect"
 
 	gomock "github.com/golang/mock/gomock"
+	idempotency "github.com/ozoncp/ocp-request-api/internal/idempotency"
 	models "github.com/ozoncp/ocp-request-api/internal/models"
+	repo "github.com/ozoncp/ocp-request-api/internal/repo"
 )
 
 // MockRepo is a mock of Repo interface.
@@ -65,6 +67,22 @@ func (mr *MockRepoMockRecorder) AddMany(arg0, arg1 interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddMany", reflect.TypeOf((*MockRepo)(nil).AddMany), arg0, arg1)
 }
 
+// AddOnce mocks base method.
+func (m *MockRepo) AddOnce(arg0 context.Context, arg1 idempotency.Key, arg2 []models.Request, arg3 uint) ([]uint64, bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AddOnce", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].([]uint64)
+	ret1, _ := ret[1].(bool)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// AddOnce indicates an expected call of AddOnce.
+func (mr *MockRepoMockRecorder) AddOnce(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddOnce", reflect.TypeOf((*MockRepo)(nil).AddOnce), arg0, arg1, arg2, arg3)
+}
+
 // Describe mocks base method.
 func (m *MockRepo) Describe(arg0 context.Context, arg1 uint64) (*models.Request, error) {
 	m.ctrl.T.Helper()
@@ -95,6 +113,21 @@ func (mr *MockRepoMockRecorder) List(arg0, arg1, arg2 interface{}) *gomock.Call
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "List", reflect.TypeOf((*MockRepo)(nil).List), arg0, arg1, arg2)
 }
 
+// ListAfter mocks base method.
+func (m *MockRepo) ListAfter(arg0 context.Context, arg1 uint64, arg2 repo.Filter, arg3 uint64) ([]models.Request, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListAfter", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].([]models.Request)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListAfter indicates an expected call of ListAfter.
+func (mr *MockRepoMockRecorder) ListAfter(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListAfter", reflect.TypeOf((*MockRepo)(nil).ListAfter), arg0, arg1, arg2, arg3)
+}
+
 // Remove mocks base method.
 func (m *MockRepo) Remove(arg0 context.Context, arg1 uint64) error {
 	m.ctrl.T.Helper()
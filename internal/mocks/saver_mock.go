@@ -5,6 +5,7 @@
 package mocks
 
 import (
+	context "context"
 	reflect "reflect"
 
 	gomock "github.com/golang/mock/gomock"
@@ -34,16 +35,32 @@ func (m *MockSaver) EXPECT() *MockSaverMockRecorder {
 	return m.recorder
 }
 
+// Backlog mocks base method.
+func (m *MockSaver) Backlog() int {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Backlog")
+	ret0, _ := ret[0].(int)
+	return ret0
+}
+
+// Backlog indicates an expected call of Backlog.
+func (mr *MockSaverMockRecorder) Backlog() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Backlog", reflect.TypeOf((*MockSaver)(nil).Backlog))
+}
+
 // Close mocks base method.
-func (m *MockSaver) Close() {
+func (m *MockSaver) Close(arg0 context.Context) error {
 	m.ctrl.T.Helper()
-	m.ctrl.Call(m, "Close")
+	ret := m.ctrl.Call(m, "Close", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
 }
 
 // Close indicates an expected call of Close.
-func (mr *MockSaverMockRecorder) Close() *gomock.Call {
+func (mr *MockSaverMockRecorder) Close(arg0 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Close", reflect.TypeOf((*MockSaver)(nil).Close))
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Close", reflect.TypeOf((*MockSaver)(nil).Close), arg0)
 }
 
 // Init mocks base method.
@@ -0,0 +1,150 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	sq "github.com/Masterminds/squirrel"
+	"github.com/ozoncp/ocp-request-api/internal/producer"
+	"github.com/rs/zerolog/log"
+	"time"
+)
+
+// Relay publishes events stored in the outbox table and deletes delivered ones.
+// An event is deleted only after the producer has acknowledged it, so delivery is at-least-once.
+type Relay interface {
+	Run(ctx context.Context)
+}
+
+// relayLockId is a key of the Postgres advisory lock held while a batch is published. Events are published
+// by a single relay of the cluster at a time, so events of a request are never published out of order.
+const relayLockId = 5100729
+
+type txBeginner interface {
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+}
+
+// NewRelay creates a Relay that polls the outbox every `pollEvery` and publishes up to `batchSize` events at once
+func NewRelay(db txBeginner, producer producer.Producer, batchSize uint64, pollEvery time.Duration) Relay {
+	return &relay{
+		db:        db,
+		producer:  producer,
+		batchSize: batchSize,
+		pollEvery: pollEvery,
+	}
+}
+
+type relay struct {
+	db        txBeginner
+	producer  producer.Producer
+	batchSize uint64
+	pollEvery time.Duration
+}
+
+// Run publishes outbox events until `ctx` is done
+func (r *relay) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.pollEvery)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.deliverPending(ctx)
+		}
+	}
+}
+
+// deliverPending publishes batches of events until the outbox is drained or an error occurs
+func (r *relay) deliverPending(ctx context.Context) {
+	for {
+		delivered, err := r.deliverBatch(ctx)
+		if err != nil {
+			log.Error().Err(err).Msg("failed to deliver outbox events")
+			return
+		}
+		if delivered < r.batchSize {
+			return
+		}
+	}
+}
+
+// deliverBatch publishes a single batch of undelivered events. Returns a number of delivered events.
+// Replicas of the service take turns by an advisory lock held until the batch is deleted,
+// nothing is published if another relay holds it.
+func (r *relay) deliverBatch(ctx context.Context) (uint64, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var locked bool
+	if err := tx.QueryRowContext(ctx, "SELECT pg_try_advisory_xact_lock($1)", relayLockId).Scan(&locked); err != nil {
+		return 0, err
+	}
+	if !locked {
+		return 0, nil
+	}
+
+	stmBuilder := sq.StatementBuilder.PlaceholderFormat(sq.Dollar).RunWith(tx)
+	rows, err := stmBuilder.Select("id, payload, trace_context").
+		From("outbox").
+		OrderBy("id").
+		Limit(r.batchSize).
+		QueryContext(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	ids := make([]uint64, 0, r.batchSize)
+	msgs := make([]producer.EventMsg, 0, r.batchSize)
+	for rows.Next() {
+		var (
+			id           uint64
+			payload      []byte
+			traceContext sql.NullString
+		)
+		if err := rows.Scan(&id, &payload, &traceContext); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ids = append(ids, id)
+		msgs = append(msgs, producer.FromPayload(payload, decodeTraceContext(id, traceContext)))
+	}
+	if err := rows.Close(); err != nil {
+		return 0, err
+	}
+
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	if err := r.producer.Send(msgs...); err != nil {
+		return 0, err
+	}
+
+	_, err = stmBuilder.Delete("outbox").
+		Where(sq.Eq{"id": ids}).
+		ExecContext(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	return uint64(len(ids)), tx.Commit()
+}
+
+// decodeTraceContext returns trace context stored with an event, nil if there is none.
+// Malformed trace context is not a reason to hold an event back, so it is dropped.
+func decodeTraceContext(id uint64, stored sql.NullString) map[string]string {
+	if !stored.Valid {
+		return nil
+	}
+	traceContext := map[string]string{}
+	if err := json.Unmarshal([]byte(stored.String), &traceContext); err != nil {
+		log.Warn().Msgf("failed to decode trace context of outbox event %v: %v", id, err)
+		return nil
+	}
+	return traceContext
+}
@@ -0,0 +1,175 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/ozoncp/ocp-request-api/internal/mocks"
+	"github.com/ozoncp/ocp-request-api/internal/producer"
+	"time"
+)
+
+var _ = Describe("Relay", func() {
+
+	var (
+		rel          *relay
+		dbMock       sqlmock.Sqlmock
+		db           *sql.DB
+		mockCtrl     *gomock.Controller
+		mockProducer *mocks.MockProducer
+		ctx          context.Context
+	)
+
+	payload := func(requestId uint64) []byte {
+		data, err := producer.NewEvent(context.Background(), requestId, producer.CreateEvent, producer.NoSnapshot, nil).Encode()
+		Expect(err).ToNot(HaveOccurred())
+		return data
+	}
+
+	selectQuery := "SELECT id, payload, trace_context FROM outbox ORDER BY id LIMIT 2"
+
+	expectLock := func(locked bool) {
+		dbMock.ExpectQuery("SELECT pg_try_advisory_xact_lock\\(\\$1\\)").
+			WithArgs(relayLockId).
+			WillReturnRows(sqlmock.NewRows([]string{"pg_try_advisory_xact_lock"}).AddRow(locked))
+	}
+
+	BeforeEach(func() {
+		var err error
+		ctx = context.Background()
+		mockCtrl = gomock.NewController(GinkgoT())
+		mockProducer = mocks.NewMockProducer(mockCtrl)
+		db, dbMock, err = sqlmock.New()
+		Expect(err).ToNot(HaveOccurred())
+
+		rel = NewRelay(db, mockProducer, 2, time.Millisecond).(*relay)
+	})
+
+	AfterEach(func() {
+		mockCtrl.Finish()
+		defer db.Close()
+		Expect(dbMock.ExpectationsWereMet()).To(Succeed())
+	})
+
+	It("Publishes pending events and deletes them", func() {
+		first, second := payload(10), payload(20)
+		dbMock.ExpectBegin()
+		expectLock(true)
+		dbMock.ExpectQuery(selectQuery).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "payload", "trace_context"}).
+				AddRow(uint64(1), first, `{"traceparent": "00-00000000000000010000000000000002-0000000000000003-01"}`).
+				AddRow(uint64(2), second, nil))
+		dbMock.ExpectExec("DELETE FROM outbox WHERE id IN \\(\\$1,\\$2\\)").
+			WithArgs(uint64(1), uint64(2)).
+			WillReturnResult(sqlmock.NewResult(0, 2))
+		dbMock.ExpectCommit()
+
+		mockProducer.EXPECT().
+			Send(gomock.Any(), gomock.Any()).
+			DoAndReturn(func(msgs ...producer.EventMsg) error {
+				for ix, expected := range [][]byte{first, second} {
+					data, err := msgs[ix].Encode()
+					Expect(err).ToNot(HaveOccurred())
+					Expect(data).To(Equal(expected))
+				}
+				Expect(msgs[0].RequestId()).To(Equal(uint64(10)))
+				Expect(msgs[1].RequestId()).To(Equal(uint64(20)))
+				Expect(msgs[0].TraceContext()).To(Equal(map[string]string{
+					"traceparent": "00-00000000000000010000000000000002-0000000000000003-01",
+				}))
+				Expect(msgs[1].TraceContext()).To(BeNil())
+				return nil
+			}).
+			MaxTimes(1).
+			MinTimes(1)
+
+		delivered, err := rel.deliverBatch(ctx)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(delivered).To(Equal(uint64(2)))
+	})
+
+	It("Keeps events in the outbox if producer fails", func() {
+		sendErr := errors.New("kafka is down")
+		dbMock.ExpectBegin()
+		expectLock(true)
+		dbMock.ExpectQuery(selectQuery).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "payload", "trace_context"}).
+				AddRow(uint64(1), []byte("one"), nil))
+		dbMock.ExpectRollback()
+
+		mockProducer.EXPECT().
+			Send(gomock.Any()).
+			Return(sendErr).
+			MaxTimes(1).
+			MinTimes(1)
+
+		delivered, err := rel.deliverBatch(ctx)
+		Expect(err).To(Equal(sendErr))
+		Expect(delivered).To(Equal(uint64(0)))
+	})
+
+	It("Does not publish anything if outbox is empty", func() {
+		dbMock.ExpectBegin()
+		expectLock(true)
+		dbMock.ExpectQuery(selectQuery).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "payload", "trace_context"}))
+		dbMock.ExpectRollback()
+
+		mockProducer.EXPECT().
+			Send(gomock.Any()).
+			MaxTimes(0)
+
+		delivered, err := rel.deliverBatch(ctx)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(delivered).To(Equal(uint64(0)))
+	})
+
+	It("Does not publish anything while another relay holds the lock", func() {
+		dbMock.ExpectBegin()
+		expectLock(false)
+		dbMock.ExpectRollback()
+
+		mockProducer.EXPECT().
+			Send(gomock.Any()).
+			MaxTimes(0)
+
+		delivered, err := rel.deliverBatch(ctx)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(delivered).To(Equal(uint64(0)))
+	})
+
+	It("Drains the outbox by batches until a partial batch is read", func() {
+		dbMock.ExpectBegin()
+		expectLock(true)
+		dbMock.ExpectQuery(selectQuery).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "payload", "trace_context"}).
+				AddRow(uint64(1), []byte("one"), nil).
+				AddRow(uint64(2), []byte("two"), nil))
+		dbMock.ExpectExec("DELETE FROM outbox WHERE id IN \\(\\$1,\\$2\\)").
+			WithArgs(uint64(1), uint64(2)).
+			WillReturnResult(sqlmock.NewResult(0, 2))
+		dbMock.ExpectCommit()
+
+		dbMock.ExpectBegin()
+		expectLock(true)
+		dbMock.ExpectQuery(selectQuery).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "payload", "trace_context"}).
+				AddRow(uint64(3), []byte("three"), nil))
+		dbMock.ExpectExec("DELETE FROM outbox WHERE id IN \\(\\$1\\)").
+			WithArgs(uint64(3)).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		dbMock.ExpectCommit()
+
+		mockProducer.EXPECT().
+			Send(gomock.Any()).
+			Return(nil).
+			MaxTimes(2).
+			MinTimes(2)
+
+		rel.deliverPending(ctx)
+	})
+})
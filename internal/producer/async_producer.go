@@ -0,0 +1,125 @@
+package producer
+
+import (
+	"errors"
+	"github.com/Shopify/sarama"
+	"github.com/ozoncp/ocp-request-api/internal/metrics"
+	"github.com/rs/zerolog/log"
+	"sync"
+)
+
+var (
+	// ErrBufferFull is returned by asynchronous producer if its buffer has no room for a message.
+	// The message is dropped in that case.
+	ErrBufferFull = errors.New("producer buffer is full")
+	// ErrProducerClosed is returned on attempt to send a message with a closed producer
+	ErrProducerClosed = errors.New("producer is closed")
+)
+
+// NewAsyncProducer returns a Kafka producer that does not wait for messages to be acknowledged.
+// Up to `bufferSize` messages wait to be passed to `kafkaProducer`; if the buffer is full
+// Send drops the rest of the messages instead of blocking a caller.
+// Messages are keyed and encoded according to `format`.
+// Delivery results are reported to `metricsReporter`. Close waits for all buffered messages to be sent.
+func NewAsyncProducer(
+	topic string,
+	format Format,
+	kafkaProducer sarama.AsyncProducer,
+	bufferSize int,
+	metricsReporter metrics.ProducerMetricsReporter,
+) Producer {
+	p := &asyncProducer{
+		topic:         topic,
+		format:        format,
+		kafkaProducer: kafkaProducer,
+		buffer:        make(chan *sarama.ProducerMessage, bufferSize),
+		metrics:       metricsReporter,
+		drained:       &sync.WaitGroup{},
+	}
+	p.drained.Add(3)
+	go p.forward()
+	go p.drainSuccesses()
+	go p.drainErrors()
+	return p
+}
+
+type asyncProducer struct {
+	topic         string
+	format        Format
+	kafkaProducer sarama.AsyncProducer
+	// buffer is drained into the input of kafkaProducer, which is unbuffered and blocks while sarama is busy
+	buffer    chan *sarama.ProducerMessage
+	metrics   metrics.ProducerMetricsReporter
+	drained   *sync.WaitGroup
+	closeLock sync.RWMutex // guards closed flag and the buffer closing
+	closed    bool
+}
+
+// Send enqueues messages for sending and returns immediately
+func (p *asyncProducer) Send(msgs ...EventMsg) error {
+	p.closeLock.RLock()
+	defer p.closeLock.RUnlock()
+
+	if p.closed {
+		return ErrProducerClosed
+	}
+
+	for ix, m := range msgs {
+		prepared, err := p.format.producerMessage(p.topic, m)
+		if err != nil {
+			p.metrics.IncFailed(1)
+			log.Error().Msgf("failed to prepare message for Kafka: %v", err)
+			continue
+		}
+
+		select {
+		case p.buffer <- prepared:
+		default:
+			dropped := len(msgs) - ix
+			p.metrics.IncDropped(uint(dropped))
+			log.Warn().Msgf("failed to enqueue %v messages: %v", dropped, ErrBufferFull)
+			return ErrBufferFull
+		}
+	}
+	return nil
+}
+
+// Close flushes buffered messages and closes the producer.
+func (p *asyncProducer) Close() error {
+	p.closeLock.Lock()
+	if p.closed {
+		p.closeLock.Unlock()
+		return nil
+	}
+	p.closed = true
+	close(p.buffer)
+	p.closeLock.Unlock()
+
+	p.drained.Wait()
+	return nil
+}
+
+// forward passes buffered messages to Kafka producer and closes it once the buffer is closed and drained
+func (p *asyncProducer) forward() {
+	defer p.drained.Done()
+	for m := range p.buffer {
+		p.kafkaProducer.Input() <- m
+	}
+	// sarama flushes buffered messages and closes Successes() and Errors() channels when it's done
+	p.kafkaProducer.AsyncClose()
+}
+
+func (p *asyncProducer) drainSuccesses() {
+	defer p.drained.Done()
+	for range p.kafkaProducer.Successes() {
+		p.metrics.IncDelivered(1)
+	}
+}
+
+func (p *asyncProducer) drainErrors() {
+	defer p.drained.Done()
+	for err := range p.kafkaProducer.Errors() {
+		p.metrics.IncFailed(1)
+		log.Error().Msgf("failed to send message to Kafka: %v", err)
+	}
+}
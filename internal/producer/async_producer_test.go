@@ -0,0 +1,165 @@
+package producer_test
+
+import (
+	"context"
+	"errors"
+	"github.com/Shopify/sarama"
+	saramaMocks "github.com/Shopify/sarama/mocks"
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/ozoncp/ocp-request-api/internal/mocks"
+	"github.com/ozoncp/ocp-request-api/internal/producer"
+	"sync"
+)
+
+// blockedProducer is a sarama.AsyncProducer with unbuffered input, as sarama's one is,
+// which is not read until the producer is released
+type blockedProducer struct {
+	input     chan *sarama.ProducerMessage
+	inputs    chan struct{} // receives a value every time Input() is called
+	successes chan *sarama.ProducerMessage
+	errors    chan *sarama.ProducerError
+}
+
+func newBlockedProducer() *blockedProducer {
+	return &blockedProducer{
+		input:     make(chan *sarama.ProducerMessage),
+		inputs:    make(chan struct{}, 100),
+		successes: make(chan *sarama.ProducerMessage),
+		errors:    make(chan *sarama.ProducerError),
+	}
+}
+
+// release starts reading the input until the producer is closed
+func (b *blockedProducer) release() {
+	go func() {
+		for range b.input {
+		}
+	}()
+}
+
+func (b *blockedProducer) AsyncClose() {
+	close(b.input)
+	close(b.successes)
+	close(b.errors)
+}
+
+func (b *blockedProducer) Close() error {
+	b.AsyncClose()
+	return nil
+}
+
+func (b *blockedProducer) Input() chan<- *sarama.ProducerMessage {
+	b.inputs <- struct{}{}
+	return b.input
+}
+
+func (b *blockedProducer) Successes() <-chan *sarama.ProducerMessage {
+	return b.successes
+}
+
+func (b *blockedProducer) Errors() <-chan *sarama.ProducerError {
+	return b.errors
+}
+
+var _ = Describe("AsyncProducer", func() {
+
+	var (
+		mockCtrl    *gomock.Controller
+		mockMetrics *mocks.MockProducerMetricsReporter
+		ctx         context.Context
+		cfg         *sarama.Config
+	)
+
+	BeforeEach(func() {
+		mockCtrl = gomock.NewController(GinkgoT())
+		mockMetrics = mocks.NewMockProducerMetricsReporter(mockCtrl)
+		ctx = context.Background()
+		cfg = sarama.NewConfig()
+		cfg.Producer.Return.Successes = true
+	})
+
+	AfterEach(func() {
+		mockCtrl.Finish()
+	})
+
+	It("Reports delivered and failed messages before Close() returns", func() {
+		kafkaProducer := saramaMocks.NewAsyncProducer(GinkgoT(), cfg)
+		kafkaProducer.ExpectInputAndSucceed()
+		kafkaProducer.ExpectInputAndSucceed()
+		kafkaProducer.ExpectInputAndFail(sarama.ErrOutOfBrokers)
+
+		mockMetrics.EXPECT().IncDelivered(uint(1)).Times(2)
+		mockMetrics.EXPECT().IncFailed(uint(1)).Times(1)
+
+		p := producer.NewAsyncProducer("events", producer.Format{}, kafkaProducer, 10, mockMetrics)
+		Expect(p.Send(
+			producer.NewEvent(ctx, 1, producer.ReadEvent, producer.NoSnapshot, nil),
+			producer.NewEvent(ctx, 2, producer.ReadEvent, producer.NoSnapshot, nil),
+			producer.NewEvent(ctx, 3, producer.ReadEvent, producer.NoSnapshot, nil),
+		)).To(Succeed())
+
+		Expect(p.Close()).To(Succeed())
+	})
+
+	It("Buffers bursts of messages while sarama is busy", func() {
+		// sarama's input is unbuffered, a message is passed only when sarama is ready to receive it
+		cfg.ChannelBufferSize = 0
+		kafkaProducer := saramaMocks.NewAsyncProducer(GinkgoT(), cfg)
+
+		const senders, burst = 10, 10
+		for i := 0; i < senders*burst; i++ {
+			kafkaProducer.ExpectInputAndSucceed()
+		}
+		mockMetrics.EXPECT().IncDelivered(uint(1)).Times(senders * burst)
+
+		p := producer.NewAsyncProducer("events", producer.Format{}, kafkaProducer, senders*burst, mockMetrics)
+		wg := sync.WaitGroup{}
+		for i := 0; i < senders; i++ {
+			wg.Add(1)
+			go func(sender int) {
+				defer GinkgoRecover()
+				defer wg.Done()
+				events := make([]producer.EventMsg, 0, burst)
+				for j := 0; j < burst; j++ {
+					events = append(events, producer.NewEvent(
+						ctx, uint64(sender*burst+j), producer.ReadEvent, producer.NoSnapshot, nil,
+					))
+				}
+				Expect(p.Send(events...)).To(Succeed())
+			}(i)
+		}
+		wg.Wait()
+
+		Expect(p.Close()).To(Succeed())
+	})
+
+	It("Drops messages if buffer is full", func() {
+		kafkaProducer := newBlockedProducer()
+		p := producer.NewAsyncProducer("events", producer.Format{}, kafkaProducer, 1, mockMetrics)
+
+		Expect(p.Send(producer.NewEvent(ctx, 1, producer.ReadEvent, producer.NoSnapshot, nil))).To(Succeed())
+		// the first message is taken from the buffer and waits for sarama
+		Eventually(kafkaProducer.inputs).Should(Receive())
+
+		mockMetrics.EXPECT().IncDropped(uint(2)).Times(1)
+		err := p.Send(
+			producer.NewEvent(ctx, 2, producer.ReadEvent, producer.NoSnapshot, nil),
+			producer.NewEvent(ctx, 3, producer.ReadEvent, producer.NoSnapshot, nil),
+			producer.NewEvent(ctx, 4, producer.ReadEvent, producer.NoSnapshot, nil),
+		)
+		Expect(err).To(Equal(producer.ErrBufferFull))
+
+		kafkaProducer.release()
+		Expect(p.Close()).To(Succeed())
+	})
+
+	It("Rejects messages after Close()", func() {
+		p := producer.NewAsyncProducer("events", producer.Format{}, saramaMocks.NewAsyncProducer(GinkgoT(), cfg), 1, mockMetrics)
+		Expect(p.Close()).To(Succeed())
+
+		err := p.Send(producer.NewEvent(ctx, 1, producer.ReadEvent, producer.NoSnapshot, errors.New("test")))
+		Expect(err).To(Equal(producer.ErrProducerClosed))
+	})
+})
@@ -0,0 +1,140 @@
+package producer
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/Shopify/sarama"
+	desc "github.com/ozoncp/ocp-request-api/pkg/ocp-request-api"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CloudEvents attributes of events produced by the service (https://github.com/cloudevents/spec)
+const (
+	CloudEventsSpecVersion = "1.0"
+	CloudEventsSource      = "/ocp-request-api"
+	CloudEventsTypePrefix  = "com.ozoncp.request.api."
+
+	cloudEventsStructuredContentType = "application/cloudevents+json"
+	cloudEventsJSONDataContentType   = "application/json"
+	cloudEventsProtoDataContentType  = "application/protobuf"
+	cloudEventsHeaderPrefix          = "ce_"
+	contentTypeHeader                = "content-type"
+)
+
+var errNoEventId = errors.New("event has no id")
+
+// cloudEvent is a CloudEvents JSON envelope
+type cloudEvent struct {
+	SpecVersion     string          `json:"specversion"`
+	Id              string          `json:"id"`
+	Source          string          `json:"source"`
+	Type            string          `json:"type"`
+	Subject         string          `json:"subject,omitempty"`
+	Time            string          `json:"time,omitempty"`
+	DataContentType string          `json:"datacontenttype"`
+	Data            json.RawMessage `json:"data"`
+}
+
+// cloudEventAttributes returns CloudEvents context attributes of `message`
+func cloudEventAttributes(message *desc.RequestAPIEvent) (cloudEvent, error) {
+	if message.EventId == "" {
+		return cloudEvent{}, errNoEventId
+	}
+	attrs := cloudEvent{
+		SpecVersion: CloudEventsSpecVersion,
+		Id:          message.EventId,
+		Source:      CloudEventsSource,
+		Type:        CloudEventsTypePrefix + strings.ToLower(message.Event.String()),
+	}
+	if message.RequestId != 0 {
+		attrs.Subject = strconv.FormatUint(message.RequestId, 10)
+	}
+	if message.Timestamp != nil {
+		attrs.Time = message.Timestamp.AsTime().Format(time.RFC3339Nano)
+	}
+	return attrs, nil
+}
+
+// toStructuredCloudEvent replaces the value of `prepared` with a JSON envelope holding the event as JSON data
+func toStructuredCloudEvent(prepared *sarama.ProducerMessage, msg EventMsg) error {
+	message, err := msg.Message()
+	if err != nil {
+		return err
+	}
+	envelope, err := cloudEventAttributes(message)
+	if err != nil {
+		return err
+	}
+	// CREATE is the zero value of event type, so unpopulated fields are emitted to keep it in JSON
+	if envelope.Data, err = (protojson.MarshalOptions{EmitUnpopulated: true}).Marshal(message); err != nil {
+		return err
+	}
+	envelope.DataContentType = cloudEventsJSONDataContentType
+
+	value, err := json.Marshal(envelope)
+	if err != nil {
+		return err
+	}
+	prepared.Value = sarama.ByteEncoder(value)
+	prepared.Headers = append(prepared.Headers, header(contentTypeHeader, cloudEventsStructuredContentType))
+	return nil
+}
+
+// toBinaryCloudEvent keeps the protobuf value of `prepared` and puts CloudEvents attributes into its headers
+func toBinaryCloudEvent(prepared *sarama.ProducerMessage, msg EventMsg) error {
+	message, err := msg.Message()
+	if err != nil {
+		return err
+	}
+	attrs, err := cloudEventAttributes(message)
+	if err != nil {
+		return err
+	}
+
+	prepared.Headers = append(prepared.Headers,
+		header(contentTypeHeader, cloudEventsProtoDataContentType),
+		header(cloudEventsHeaderPrefix+"specversion", attrs.SpecVersion),
+		header(cloudEventsHeaderPrefix+"id", attrs.Id),
+		header(cloudEventsHeaderPrefix+"source", attrs.Source),
+		header(cloudEventsHeaderPrefix+"type", attrs.Type),
+	)
+	if attrs.Subject != "" {
+		prepared.Headers = append(prepared.Headers, header(cloudEventsHeaderPrefix+"subject", attrs.Subject))
+	}
+	if attrs.Time != "" {
+		prepared.Headers = append(prepared.Headers, header(cloudEventsHeaderPrefix+"time", attrs.Time))
+	}
+	return nil
+}
+
+func header(key, value string) sarama.RecordHeader {
+	return sarama.RecordHeader{Key: []byte(key), Value: []byte(value)}
+}
+
+// DecodeMessage decodes an event from a consumed Kafka message of any encoding supported by the producer
+func DecodeMessage(msg *sarama.ConsumerMessage) (*desc.RequestAPIEvent, error) {
+	message := &desc.RequestAPIEvent{}
+	if contentType(msg) != cloudEventsStructuredContentType {
+		// both raw protobuf and binary mode CloudEvents carry protobuf message as is
+		return message, proto.Unmarshal(msg.Value, message)
+	}
+
+	envelope := cloudEvent{}
+	if err := json.Unmarshal(msg.Value, &envelope); err != nil {
+		return nil, err
+	}
+	return message, protojson.Unmarshal(envelope.Data, message)
+}
+
+func contentType(msg *sarama.ConsumerMessage) string {
+	for _, h := range msg.Headers {
+		if h != nil && strings.ToLower(string(h.Key)) == contentTypeHeader {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
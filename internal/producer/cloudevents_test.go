@@ -0,0 +1,120 @@
+package producer_test
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/Shopify/sarama"
+	saramaMocks "github.com/Shopify/sarama/mocks"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/ozoncp/ocp-request-api/internal/models"
+	"github.com/ozoncp/ocp-request-api/internal/producer"
+	desc "github.com/ozoncp/ocp-request-api/pkg/ocp-request-api"
+	"google.golang.org/protobuf/proto"
+)
+
+var _ = Describe("CloudEvents encoding", func() {
+
+	var (
+		kafkaProducer *saramaMocks.SyncProducer
+		sent          *sarama.ProducerMessage
+		event         producer.EventMsg
+		eventId       string
+	)
+
+	headers := func(msg *sarama.ProducerMessage) map[string]string {
+		result := map[string]string{}
+		for _, h := range msg.Headers {
+			result[string(h.Key)] = string(h.Value)
+		}
+		return result
+	}
+
+	decodeSent := func() *desc.RequestAPIEvent {
+		value, err := sent.Value.Encode()
+		Expect(err).ToNot(HaveOccurred())
+		consumed := &sarama.ConsumerMessage{Value: value}
+		for ix := range sent.Headers {
+			consumed.Headers = append(consumed.Headers, &sent.Headers[ix])
+		}
+		message, err := producer.DecodeMessage(consumed)
+		Expect(err).ToNot(HaveOccurred())
+		return message
+	}
+
+	BeforeEach(func() {
+		req := models.NewRequest(1, 10, 100, "text")
+		event = producer.NewEvent(
+			context.Background(), 1, producer.CreateEvent, producer.Snapshot{After: &req}, nil,
+		)
+		message, err := event.Message()
+		Expect(err).ToNot(HaveOccurred())
+		eventId = message.EventId
+
+		sent = nil
+		kafkaProducer = saramaMocks.NewSyncProducer(GinkgoT(), saramaMocks.NewTestConfig())
+		kafkaProducer.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(func(msg *sarama.ProducerMessage) error {
+			sent = msg
+			return nil
+		})
+	})
+
+	AfterEach(func() {
+		Expect(kafkaProducer.Close()).To(Succeed())
+	})
+
+	It("Wraps event into structured mode JSON envelope", func() {
+		p := producer.NewProducer("events", producer.Format{Encoding: producer.EncodingCloudEventsStructured}, kafkaProducer)
+		Expect(p.Send(event)).To(Succeed())
+
+		value, err := sent.Value.Encode()
+		Expect(err).ToNot(HaveOccurred())
+		envelope := map[string]interface{}{}
+		Expect(json.Unmarshal(value, &envelope)).To(Succeed())
+
+		Expect(envelope).To(HaveKeyWithValue("specversion", "1.0"))
+		Expect(envelope).To(HaveKeyWithValue("id", eventId))
+		Expect(envelope).To(HaveKeyWithValue("source", producer.CloudEventsSource))
+		Expect(envelope).To(HaveKeyWithValue("type", producer.CloudEventsTypePrefix+"create"))
+		Expect(envelope).To(HaveKeyWithValue("subject", "1"))
+		Expect(envelope).To(HaveKeyWithValue("datacontenttype", "application/json"))
+		Expect(envelope).To(HaveKey("time"))
+		Expect(envelope["data"]).To(HaveKeyWithValue("event", "CREATE"))
+		Expect(headers(sent)).To(HaveKeyWithValue("content-type", "application/cloudevents+json"))
+
+		decoded := decodeSent()
+		Expect(decoded.EventId).To(Equal(eventId))
+		Expect(decoded.After.Text).To(Equal("text"))
+	})
+
+	It("Puts attributes into headers in binary mode", func() {
+		p := producer.NewProducer("events", producer.Format{Encoding: producer.EncodingCloudEventsBinary}, kafkaProducer)
+		Expect(p.Send(event)).To(Succeed())
+
+		value, err := sent.Value.Encode()
+		Expect(err).ToNot(HaveOccurred())
+		message := &desc.RequestAPIEvent{}
+		Expect(proto.Unmarshal(value, message)).To(Succeed())
+		Expect(message.EventId).To(Equal(eventId))
+
+		Expect(headers(sent)).To(SatisfyAll(
+			HaveKeyWithValue("content-type", "application/protobuf"),
+			HaveKeyWithValue("ce_specversion", "1.0"),
+			HaveKeyWithValue("ce_id", eventId),
+			HaveKeyWithValue("ce_source", producer.CloudEventsSource),
+			HaveKeyWithValue("ce_type", producer.CloudEventsTypePrefix+"create"),
+			HaveKeyWithValue("ce_subject", "1"),
+			HaveKey("ce_time"),
+		))
+		Expect(decodeSent().EventId).To(Equal(eventId))
+	})
+
+	It("Encodes outbox payloads the same way", func() {
+		payload, err := event.Encode()
+		Expect(err).ToNot(HaveOccurred())
+
+		p := producer.NewProducer("events", producer.Format{Encoding: producer.EncodingCloudEventsBinary}, kafkaProducer)
+		Expect(p.Send(producer.FromPayload(payload, nil))).To(Succeed())
+		Expect(headers(sent)).To(HaveKeyWithValue("ce_id", eventId))
+	})
+})
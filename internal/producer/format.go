@@ -0,0 +1,56 @@
+package producer
+
+import (
+	"fmt"
+	"github.com/Shopify/sarama"
+)
+
+// Encoding selects how events are encoded into Kafka messages
+type Encoding string
+
+const (
+	// EncodingProtobuf sends RequestAPIEvent protobuf message as is
+	EncodingProtobuf Encoding = "protobuf"
+	// EncodingCloudEventsStructured wraps RequestAPIEvent into CloudEvents JSON envelope
+	EncodingCloudEventsStructured Encoding = "cloudevents_structured"
+	// EncodingCloudEventsBinary sends RequestAPIEvent protobuf message with CloudEvents attributes in `ce_` headers
+	EncodingCloudEventsBinary Encoding = "cloudevents_binary"
+)
+
+// ParseEncoding validates an encoding name taken from configuration
+func ParseEncoding(name string) (Encoding, error) {
+	switch encoding := Encoding(name); encoding {
+	case EncodingProtobuf, EncodingCloudEventsStructured, EncodingCloudEventsBinary:
+		return encoding, nil
+	default:
+		return "", fmt.Errorf(
+			"unknown encoding %q, expected %q, %q or %q",
+			name, EncodingProtobuf, EncodingCloudEventsStructured, EncodingCloudEventsBinary,
+		)
+	}
+}
+
+// Format defines how events are turned into Kafka messages.
+// Zero value keys messages by request id and encodes them as protobuf.
+type Format struct {
+	KeyBy    PartitionKey
+	Encoding Encoding
+}
+
+func (f Format) producerMessage(topic string, msg EventMsg) (*sarama.ProducerMessage, error) {
+	prepared := &sarama.ProducerMessage{
+		Topic:   topic,
+		Key:     messageKey(f.KeyBy, msg),
+		Value:   msg,
+		Headers: traceHeaders(msg.TraceContext()),
+	}
+
+	switch f.Encoding {
+	case EncodingCloudEventsStructured:
+		return prepared, toStructuredCloudEvent(prepared, msg)
+	case EncodingCloudEventsBinary:
+		return prepared, toBinaryCloudEvent(prepared, msg)
+	default:
+		return prepared, nil
+	}
+}
@@ -0,0 +1,65 @@
+package producer
+
+import (
+	"context"
+	"github.com/Shopify/sarama"
+	"github.com/ozoncp/ocp-request-api/internal/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
+	"sort"
+)
+
+// traceHeaders converts trace context of an event to Kafka record headers
+func traceHeaders(traceContext map[string]string) []sarama.RecordHeader {
+	if len(traceContext) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(traceContext))
+	for k := range traceContext {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	headers := make([]sarama.RecordHeader, 0, len(keys))
+	for _, k := range keys {
+		headers = append(headers, header(k, traceContext[k]))
+	}
+	return headers
+}
+
+// consumerHeaders reads consumed message headers as propagation.TextMapCarrier
+type consumerHeaders []*sarama.RecordHeader
+
+func (h consumerHeaders) Get(key string) string {
+	for _, header := range h {
+		if header != nil && string(header.Key) == key {
+			return string(header.Value)
+		}
+	}
+	return ""
+}
+
+// Set does nothing, consumed messages are not modified
+func (h consumerHeaders) Set(key, val string) {}
+
+func (h consumerHeaders) Keys() []string {
+	keys := make([]string, 0, len(h))
+	for _, header := range h {
+		if header != nil {
+			keys = append(keys, string(header.Key))
+		}
+	}
+	return keys
+}
+
+// StartSpanFromMessage starts a span with `operationName` as a child of the span that produced `msg`.
+// If `msg` carries no trace context, a new trace is started. Returns a context holding the span.
+func StartSpanFromMessage(
+	ctx context.Context,
+	msg *sarama.ConsumerMessage,
+	operationName string,
+) (trace.Span, context.Context) {
+	ctx = otel.GetTextMapPropagator().Extract(ctx, consumerHeaders(msg.Headers))
+	ctx, span := otel.Tracer(tracing.InstrumentationName).Start(ctx, operationName, trace.WithSpanKind(trace.SpanKindConsumer))
+	return span, ctx
+}
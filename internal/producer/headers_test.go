@@ -0,0 +1,76 @@
+package producer_test
+
+import (
+	"context"
+	"github.com/Shopify/sarama"
+	saramaMocks "github.com/Shopify/sarama/mocks"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/ozoncp/ocp-request-api/internal/producer"
+	"github.com/ozoncp/ocp-request-api/internal/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var _ = Describe("Trace headers", func() {
+
+	var (
+		recorder         *tracetest.SpanRecorder
+		previousProvider trace.TracerProvider
+	)
+
+	BeforeEach(func() {
+		previousProvider = otel.GetTracerProvider()
+		recorder = tracetest.NewSpanRecorder()
+		otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+		otel.SetTextMapPropagator(tracing.NewPropagator())
+	})
+
+	AfterEach(func() {
+		otel.SetTracerProvider(previousProvider)
+		otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator())
+	})
+
+	It("Propagates span from producer to consumer", func() {
+		ctx, parent := otel.Tracer("test").Start(context.Background(), "CreateRequestV1")
+
+		var sent *sarama.ProducerMessage
+		kafkaProducer := saramaMocks.NewSyncProducer(GinkgoT(), saramaMocks.NewTestConfig())
+		kafkaProducer.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(func(msg *sarama.ProducerMessage) error {
+			sent = msg
+			return nil
+		})
+
+		p := producer.NewProducer("events", producer.Format{}, kafkaProducer)
+		Expect(p.Send(producer.NewEvent(ctx, 1, producer.CreateEvent, producer.NoSnapshot, nil))).To(Succeed())
+		Expect(p.Close()).To(Succeed())
+		Expect(sent.Headers).To(HaveLen(1))
+		Expect(string(sent.Headers[0].Key)).To(Equal(tracing.TraceParentHeader))
+
+		consumed := &sarama.ConsumerMessage{}
+		for ix := range sent.Headers {
+			consumed.Headers = append(consumed.Headers, &sent.Headers[ix])
+		}
+		child, childCtx := producer.StartSpanFromMessage(context.Background(), consumed, "HandleEvent")
+		child.End()
+
+		Expect(trace.SpanFromContext(childCtx)).To(Equal(child))
+		finished := recorder.Ended()
+		Expect(finished).To(HaveLen(1))
+		Expect(finished[0].Name()).To(Equal("HandleEvent"))
+		Expect(finished[0].Parent().SpanID()).To(Equal(parent.SpanContext().SpanID()))
+		Expect(finished[0].SpanContext().TraceID()).To(Equal(parent.SpanContext().TraceID()))
+	})
+
+	It("Starts a new trace if message has no trace context", func() {
+		span, _ := producer.StartSpanFromMessage(context.Background(), &sarama.ConsumerMessage{}, "HandleEvent")
+		span.End()
+
+		finished := recorder.Ended()
+		Expect(finished).To(HaveLen(1))
+		Expect(finished[0].Parent().IsValid()).To(BeFalse())
+	})
+})
@@ -0,0 +1,38 @@
+package producer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// HealthChecker is implemented by producers able to tell whether events can be sent
+type HealthChecker interface {
+	// Check returns an error if events can not be sent
+	Check(ctx context.Context) error
+}
+
+// Check fails when Kafka brokers are unreachable by the client of the producer or metadata of its topic
+// can not be fetched. Producers created by NewProducer have no client to check and always pass.
+func (p *producer) Check(ctx context.Context) error {
+	if p.client == nil {
+		return nil
+	}
+	if p.client.Closed() {
+		return errors.New("kafka client is closed")
+	}
+	// sarama calls are not cancellable, so the check gives up waiting on `ctx`
+	done := make(chan error, 1)
+	go func() {
+		done <- p.client.RefreshMetadata(p.topic)
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			return fmt.Errorf("failed to fetch metadata of %v topic: %w", p.topic, err)
+		}
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
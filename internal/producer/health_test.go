@@ -0,0 +1,66 @@
+package producer_test
+
+import (
+	"context"
+	"github.com/Shopify/sarama"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/ozoncp/ocp-request-api/internal/producer"
+	"time"
+)
+
+var _ = Describe("Producer health check", func() {
+
+	var (
+		broker *sarama.MockBroker
+		client sarama.Client
+		prod   producer.Producer
+		check  func(ctx context.Context) error
+	)
+
+	BeforeEach(func() {
+		broker = sarama.NewMockBroker(GinkgoT(), 1)
+		broker.SetHandlerByMap(map[string]sarama.MockResponse{
+			"MetadataRequest": sarama.NewMockMetadataResponse(GinkgoT()).
+				SetBroker(broker.Addr(), broker.BrokerID()).
+				SetLeader("events", 0, broker.BrokerID()),
+		})
+
+		cfg := sarama.NewConfig()
+		cfg.Metadata.Retry.Max = 0
+		cfg.Producer.Return.Successes = true
+		var err error
+		client, err = sarama.NewClient([]string{broker.Addr()}, cfg)
+		Expect(err).ToNot(HaveOccurred())
+		prod, err = producer.NewClientProducer("events", producer.Format{}, client)
+		Expect(err).ToNot(HaveOccurred())
+		checker, ok := prod.(producer.HealthChecker)
+		Expect(ok).To(BeTrue())
+		check = checker.Check
+	})
+
+	AfterEach(func() {
+		if !client.Closed() {
+			prod.Close()
+		}
+		broker.Close()
+	})
+
+	It("Passes when topic metadata is fetched", func() {
+		Expect(check(context.Background())).To(Succeed())
+	})
+
+	It("Fails when the producer is closed", func() {
+		Expect(prod.Close()).To(Succeed())
+		Expect(client.Closed()).To(BeTrue())
+		Expect(check(context.Background())).ToNot(Succeed())
+	})
+
+	It("Gives up waiting when context is done", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		broker.SetLatency(time.Second)
+
+		Expect(check(ctx)).To(MatchError(context.Canceled))
+	})
+})
@@ -0,0 +1,44 @@
+package producer
+
+import (
+	"fmt"
+	"github.com/Shopify/sarama"
+	"strconv"
+)
+
+// PartitionKey selects which field of an event is used as a Kafka message key.
+// Messages with the same key go to the same partition, so they are consumed in the order they were sent.
+type PartitionKey string
+
+const (
+	// KeyByRequestId orders events of every single request
+	KeyByRequestId PartitionKey = "request_id"
+	// KeyByUserId orders events of all requests of a user
+	KeyByUserId PartitionKey = "user_id"
+)
+
+// ParsePartitionKey validates a partition key name taken from configuration
+func ParsePartitionKey(name string) (PartitionKey, error) {
+	switch key := PartitionKey(name); key {
+	case KeyByRequestId, KeyByUserId:
+		return key, nil
+	default:
+		return "", fmt.Errorf("unknown partition key %q, expected %q or %q", name, KeyByRequestId, KeyByUserId)
+	}
+}
+
+// messageKey returns a key for `msg`. Events that are not related to a request (e.g. failed calls)
+// have no key, so they are spread over partitions.
+func messageKey(keyBy PartitionKey, msg EventMsg) sarama.Encoder {
+	var id uint64
+	switch keyBy {
+	case KeyByUserId:
+		id = msg.UserId()
+	default:
+		id = msg.RequestId()
+	}
+	if id == 0 {
+		return nil
+	}
+	return sarama.StringEncoder(strconv.FormatUint(id, 10))
+}
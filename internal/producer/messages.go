@@ -3,12 +3,21 @@ package producer
 import (
 	"context"
 	"github.com/Shopify/sarama"
-	"github.com/opentracing/opentracing-go"
+	"github.com/google/uuid"
+	"github.com/ozoncp/ocp-request-api/internal/identity"
+	"github.com/ozoncp/ocp-request-api/internal/models"
 	desc "github.com/ozoncp/ocp-request-api/pkg/ocp-request-api"
 	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
 	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"time"
 )
 
+// SchemaVersion is a version of RequestAPIEvent messages produced by the service
+const SchemaVersion = 5
+
 type EventType int
 
 const (
@@ -16,36 +25,79 @@ const (
 	ReadEvent
 	UpdateEvent
 	DeleteEvent
+	// ListEvent reports a whole list call, see NewListEvent
+	ListEvent
 )
 
+// EventMsg is an encoded event with ids used to key a Kafka message
+// and trace context sent in Kafka message headers
 type EventMsg interface {
 	sarama.Encoder
+	RequestId() uint64
+	UserId() uint64
+	TraceContext() map[string]string
+	// Message returns the event as protobuf message
+	Message() (*desc.RequestAPIEvent, error)
+}
+
+// Snapshot holds states of a Request before and after the change an event describes.
+// Either of states may be nil, e.g. there is no Before state for CreateEvent.
+type Snapshot struct {
+	Before *models.Request
+	After  *models.Request
 }
 
-func NewEvent(ctx context.Context, requestId uint64, eventType EventType, err error) EventMsg {
+// NoSnapshot is used for events that are not related to an existing Request (e.g. failed calls)
+var NoSnapshot = Snapshot{}
+
+// NewEvent creates an event message of a given type. The caller's identity and span are taken from `ctx`.
+func NewEvent(ctx context.Context, requestId uint64, eventType EventType, snapshot Snapshot, err error) EventMsg {
 	e := &event{
+		id:        uuid.New().String(),
 		requestId: requestId,
 		eventType: eventType,
+		snapshot:  snapshot,
 		err:       err,
+		timestamp: time.Now(),
+	}
+
+	if caller, ok := identity.FromContext(ctx); ok {
+		e.actor = caller.Subject
 	}
 
-	// provide parent's span info with message
-	spanDump := opentracing.TextMapCarrier{} // just a map with some methods derived
-	span := opentracing.SpanFromContext(ctx)
-	if span != nil {
-		if err := opentracing.GlobalTracer().Inject(span.Context(), opentracing.TextMap, spanDump); err != nil {
-			log.Warn().Msgf("failed to update event message with span info: %v", err)
-		} else {
-			e.span = spanDump
-		}
+	// provide parent's span info with message headers
+	spanDump := propagation.MapCarrier{} // just a map with some methods derived
+	otel.GetTextMapPropagator().Inject(ctx, spanDump)
+	if len(spanDump) > 0 {
+		e.span = spanDump
 	}
 	return e
 }
 
+// NewListEvent creates a single event for a list call that returned `listed` requests
+func NewListEvent(ctx context.Context, listed []models.Request, err error) EventMsg {
+	e := NewEvent(ctx, 0, ListEvent, NoSnapshot, err).(*event)
+	e.listed = listed
+	return e
+}
+
+// NewReplayEvent creates a CREATE event repeating the current state of `request`, see RequestAPIEvent.replay
+func NewReplayEvent(ctx context.Context, request models.Request) EventMsg {
+	e := NewEvent(ctx, request.Id, CreateEvent, Snapshot{After: &request}, nil).(*event)
+	e.replay = true
+	return e
+}
+
 type event struct {
+	id          string
 	requestId   uint64
 	eventType   EventType
+	snapshot    Snapshot
+	listed      []models.Request
+	replay      bool
 	err         error
+	actor       string
+	timestamp   time.Time
 	traceId     string
 	span        map[string]string
 	encodedData []byte //caching to avoid double encoding on Length() and Encode()
@@ -57,12 +109,28 @@ func (e *event) Encode() ([]byte, error) {
 		return e.encodedData, e.encodeErr
 	}
 
+	message, _ := e.Message()
+	e.encodedData, e.encodeErr = proto.Marshal(message)
+	return e.encodedData, e.encodeErr
+}
+
+func (e *event) Message() (*desc.RequestAPIEvent, error) {
 	message := &desc.RequestAPIEvent{
-		RequestId: e.requestId,
+		RequestId:     e.requestId,
+		EventId:       e.id,
+		SchemaVersion: SchemaVersion,
+		Timestamp:     timestamppb.New(e.timestamp),
+		Actor:         e.actor,
+		Before:        requestToProto(e.snapshot.Before),
+		After:         requestToProto(e.snapshot.After),
+		Replay:        e.replay,
 	}
 	if e.err != nil {
 		message.Error = e.err.Error()
 	}
+	for ix := range e.listed {
+		message.Listed = append(message.Listed, requestToProto(&e.listed[ix]))
+	}
 
 	switch e.eventType {
 	case CreateEvent:
@@ -73,18 +141,108 @@ func (e *event) Encode() ([]byte, error) {
 		message.Event = desc.RequestAPIEvent_UPDATE
 	case DeleteEvent:
 		message.Event = desc.RequestAPIEvent_DELETE
+	case ListEvent:
+		message.Event = desc.RequestAPIEvent_LIST
 	default:
 		log.Panic().Msgf("unexpected event type: %v", e.eventType)
 	}
+	return message, nil
+}
 
-	if len(e.span) > 0 {
-		message.TraceSpan = e.span
+// readEvents splits a list event into READ events of every listed request
+func (e *event) readEvents() []EventMsg {
+	reads := make([]EventMsg, 0, len(e.listed))
+	for ix := range e.listed {
+		reads = append(reads, &event{
+			id:        uuid.New().String(),
+			requestId: e.listed[ix].Id,
+			eventType: ReadEvent,
+			snapshot:  Snapshot{After: &e.listed[ix]},
+			actor:     e.actor,
+			timestamp: e.timestamp,
+			span:      e.span,
+		})
 	}
-	e.encodedData, e.encodeErr = proto.Marshal(message)
-	return e.encodedData, e.encodeErr
+	return reads
 }
 
 func (e *event) Length() int {
 	data, _ := e.Encode()
 	return len(data)
 }
+
+func (e *event) RequestId() uint64 {
+	return e.requestId
+}
+
+func (e *event) TraceContext() map[string]string {
+	return e.span
+}
+
+// UserId returns an owner of the request, 0 if event has no snapshots
+func (e *event) UserId() uint64 {
+	if e.snapshot.After != nil {
+		return e.snapshot.After.UserId
+	}
+	if e.snapshot.Before != nil {
+		return e.snapshot.Before.UserId
+	}
+	return 0
+}
+
+// FromPayload wraps an already encoded event (e.g. one stored in the outbox) to send it as is.
+// Ids are decoded from the payload; if it can't be decoded, they are left zero and the message has no key.
+func FromPayload(payload []byte, traceContext map[string]string) EventMsg {
+	e := &encodedEvent{ByteEncoder: sarama.ByteEncoder(payload), traceContext: traceContext}
+	message := &desc.RequestAPIEvent{}
+	if err := proto.Unmarshal(payload, message); err != nil {
+		log.Warn().Msgf("failed to decode event payload: %v", err)
+		e.decodeErr = err
+		return e
+	}
+	e.message = message
+	e.requestId = message.RequestId
+	if message.After != nil {
+		e.userId = message.After.UserId
+	} else if message.Before != nil {
+		e.userId = message.Before.UserId
+	}
+	return e
+}
+
+type encodedEvent struct {
+	sarama.ByteEncoder
+	requestId    uint64
+	userId       uint64
+	traceContext map[string]string
+	message      *desc.RequestAPIEvent
+	decodeErr    error
+}
+
+func (e *encodedEvent) RequestId() uint64 {
+	return e.requestId
+}
+
+func (e *encodedEvent) UserId() uint64 {
+	return e.userId
+}
+
+func (e *encodedEvent) TraceContext() map[string]string {
+	return e.traceContext
+}
+
+func (e *encodedEvent) Message() (*desc.RequestAPIEvent, error) {
+	return e.message, e.decodeErr
+}
+
+func requestToProto(req *models.Request) *desc.Request {
+	if req == nil {
+		return nil
+	}
+	return &desc.Request{
+		Id:     req.Id,
+		UserId: req.UserId,
+		Type:   req.Type,
+		Text:   req.Text,
+	}
+}
@@ -0,0 +1,64 @@
+package producer_test
+
+import (
+	"context"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/ozoncp/ocp-request-api/internal/identity"
+	"github.com/ozoncp/ocp-request-api/internal/models"
+	"github.com/ozoncp/ocp-request-api/internal/producer"
+	desc "github.com/ozoncp/ocp-request-api/pkg/ocp-request-api"
+	"google.golang.org/protobuf/proto"
+)
+
+var _ = Describe("Event", func() {
+
+	decode := func(msg producer.EventMsg) *desc.RequestAPIEvent {
+		data, err := msg.Encode()
+		Expect(err).ToNot(HaveOccurred())
+		event := &desc.RequestAPIEvent{}
+		Expect(proto.Unmarshal(data, event)).To(Succeed())
+		return event
+	}
+
+	It("Carries request snapshots and caller identity", func() {
+		ctx := identity.NewContext(context.Background(), identity.Identity{Subject: "student"})
+		before := models.NewRequest(1, 10, 100, "before")
+		after := models.NewRequest(1, 10, 100, "after")
+
+		event := decode(producer.NewEvent(
+			ctx, 1, producer.UpdateEvent, producer.Snapshot{Before: &before, After: &after}, nil,
+		))
+
+		Expect(event.RequestId).To(Equal(uint64(1)))
+		Expect(event.Event).To(Equal(desc.RequestAPIEvent_UPDATE))
+		Expect(event.SchemaVersion).To(Equal(uint32(producer.SchemaVersion)))
+		Expect(event.EventId).ToNot(BeEmpty())
+		Expect(event.Timestamp.IsValid()).To(BeTrue())
+		Expect(event.Actor).To(Equal("student"))
+		Expect(event.Before.Text).To(Equal("before"))
+		Expect(event.After.Text).To(Equal("after"))
+	})
+
+	It("Has unique id and no snapshots for failed calls", func() {
+		ctx := context.Background()
+		first := decode(producer.NewEvent(ctx, 0, producer.CreateEvent, producer.NoSnapshot, nil))
+		second := decode(producer.NewEvent(ctx, 0, producer.CreateEvent, producer.NoSnapshot, nil))
+
+		Expect(first.EventId).ToNot(Equal(second.EventId))
+		Expect(first.Actor).To(BeEmpty())
+		Expect(first.Before).To(BeNil())
+		Expect(first.After).To(BeNil())
+	})
+
+	It("Marks replayed events", func() {
+		req := models.NewRequest(1, 10, 100, "text")
+		event := decode(producer.NewReplayEvent(context.Background(), req))
+
+		Expect(event.Event).To(Equal(desc.RequestAPIEvent_CREATE))
+		Expect(event.Replay).To(BeTrue())
+		Expect(event.After.Text).To(Equal("text"))
+		Expect(decode(producer.NewEvent(context.Background(), 1, producer.CreateEvent, producer.NoSnapshot, nil)).Replay).
+			To(BeFalse())
+	})
+})
@@ -0,0 +1,119 @@
+package producer
+
+import (
+	"fmt"
+	desc "github.com/ozoncp/ocp-request-api/pkg/ocp-request-api"
+	"math/rand"
+	"strings"
+)
+
+// Policy decides which events are sent to Kafka
+type Policy struct {
+	// Types of events to send. All types are sent if empty.
+	Types []EventType
+	// ReadSampleRate is a fraction of READ and LIST events to send. Events of failed calls are always sent.
+	ReadSampleRate float64
+	// AggregateList sends a single LIST event per list call instead of a READ event per listed request
+	AggregateList bool
+}
+
+// DefaultPolicy sends all events and reports a list call with a single LIST event
+var DefaultPolicy = Policy{ReadSampleRate: 1, AggregateList: true}
+
+var eventTypeNames = map[string]EventType{
+	"create": CreateEvent,
+	"read":   ReadEvent,
+	"update": UpdateEvent,
+	"delete": DeleteEvent,
+	"list":   ListEvent,
+}
+
+// ParseEventType returns an event type by its name, e.g. "create"
+func ParseEventType(name string) (EventType, error) {
+	eventType, ok := eventTypeNames[strings.ToLower(name)]
+	if !ok {
+		return 0, fmt.Errorf("unknown event type %q, expected create, read, update, delete or list", name)
+	}
+	return eventType, nil
+}
+
+// NewPolicyProducer returns a Producer that applies `policy` to events before passing them to `next`
+func NewPolicyProducer(policy Policy, next Producer) Producer {
+	p := &policyProducer{
+		next:           next,
+		readSampleRate: policy.ReadSampleRate,
+		aggregateList:  policy.AggregateList,
+	}
+	if len(policy.Types) > 0 {
+		p.types = make(map[EventType]bool, len(policy.Types))
+		for _, t := range policy.Types {
+			p.types[t] = true
+		}
+	}
+	return p
+}
+
+type policyProducer struct {
+	next           Producer
+	types          map[EventType]bool // nil if all types are sent
+	readSampleRate float64
+	aggregateList  bool
+}
+
+// Send passes events allowed by the policy to the underlying producer
+func (p *policyProducer) Send(msgs ...EventMsg) error {
+	allowed := make([]EventMsg, 0, len(msgs))
+	for _, m := range msgs {
+		message, err := m.Message()
+		if err != nil {
+			// can't tell what the event is about, let consumers decide
+			allowed = append(allowed, m)
+			continue
+		}
+
+		eventType := eventTypeOf(message.Event)
+		if p.types != nil && !p.types[eventType] {
+			continue
+		}
+
+		expanded := []EventMsg{m}
+		if e, ok := m.(*event); ok && eventType == ListEvent && !p.aggregateList && message.Error == "" {
+			expanded = e.readEvents()
+		}
+
+		for _, em := range expanded {
+			if p.sampledOut(eventType, message.Error) {
+				continue
+			}
+			allowed = append(allowed, em)
+		}
+	}
+	return p.next.Send(allowed...)
+}
+
+func (p *policyProducer) sampledOut(eventType EventType, eventErr string) bool {
+	if eventType != ReadEvent && eventType != ListEvent || eventErr != "" {
+		return false
+	}
+	return rand.Float64() >= p.readSampleRate
+}
+
+// Close closes the underlying producer
+func (p *policyProducer) Close() error {
+	return p.next.Close()
+}
+
+func eventTypeOf(eventType desc.RequestAPIEvent_EventType) EventType {
+	switch eventType {
+	case desc.RequestAPIEvent_READ:
+		return ReadEvent
+	case desc.RequestAPIEvent_UPDATE:
+		return UpdateEvent
+	case desc.RequestAPIEvent_DELETE:
+		return DeleteEvent
+	case desc.RequestAPIEvent_LIST:
+		return ListEvent
+	default:
+		return CreateEvent
+	}
+}
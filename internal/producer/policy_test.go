@@ -0,0 +1,120 @@
+package producer_test
+
+import (
+	"context"
+	"errors"
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/ozoncp/ocp-request-api/internal/mocks"
+	"github.com/ozoncp/ocp-request-api/internal/models"
+	"github.com/ozoncp/ocp-request-api/internal/producer"
+	desc "github.com/ozoncp/ocp-request-api/pkg/ocp-request-api"
+)
+
+var _ = Describe("PolicyProducer", func() {
+
+	var (
+		mockCtrl     *gomock.Controller
+		mockProducer *mocks.MockProducer
+		ctx          context.Context
+		listed       []models.Request
+		sent         []*desc.RequestAPIEvent
+	)
+
+	BeforeEach(func() {
+		mockCtrl = gomock.NewController(GinkgoT())
+		mockProducer = mocks.NewMockProducer(mockCtrl)
+		ctx = context.Background()
+		listed = []models.Request{
+			models.NewRequest(1, 10, 100, "one"),
+			models.NewRequest(2, 20, 200, "two"),
+		}
+		sent = nil
+		mockProducer.EXPECT().
+			Send(gomock.Any()).
+			DoAndReturn(func(msgs ...producer.EventMsg) error {
+				for _, m := range msgs {
+					message, err := m.Message()
+					Expect(err).ToNot(HaveOccurred())
+					sent = append(sent, message)
+				}
+				return nil
+			}).
+			AnyTimes()
+	})
+
+	AfterEach(func() {
+		mockCtrl.Finish()
+	})
+
+	eventTypes := func() []desc.RequestAPIEvent_EventType {
+		types := make([]desc.RequestAPIEvent_EventType, 0, len(sent))
+		for _, message := range sent {
+			types = append(types, message.Event)
+		}
+		return types
+	}
+
+	It("Sends a single event per list call by default", func() {
+		p := producer.NewPolicyProducer(producer.DefaultPolicy, mockProducer)
+		Expect(p.Send(producer.NewListEvent(ctx, listed, nil))).To(Succeed())
+
+		Expect(eventTypes()).To(Equal([]desc.RequestAPIEvent_EventType{desc.RequestAPIEvent_LIST}))
+		Expect(sent[0].Listed).To(HaveLen(2))
+	})
+
+	It("Splits list call into READ events if aggregation is off", func() {
+		p := producer.NewPolicyProducer(producer.Policy{ReadSampleRate: 1}, mockProducer)
+		Expect(p.Send(producer.NewListEvent(ctx, listed, nil))).To(Succeed())
+
+		Expect(eventTypes()).To(Equal([]desc.RequestAPIEvent_EventType{
+			desc.RequestAPIEvent_READ, desc.RequestAPIEvent_READ,
+		}))
+		Expect(sent[0].RequestId).To(Equal(uint64(1)))
+		Expect(sent[1].After.Text).To(Equal("two"))
+		Expect(sent[0].EventId).ToNot(Equal(sent[1].EventId))
+	})
+
+	It("Sends configured event types only", func() {
+		p := producer.NewPolicyProducer(producer.Policy{
+			Types:          []producer.EventType{producer.CreateEvent, producer.DeleteEvent},
+			ReadSampleRate: 1,
+		}, mockProducer)
+		Expect(p.Send(
+			producer.NewEvent(ctx, 1, producer.CreateEvent, producer.Snapshot{After: &listed[0]}, nil),
+			producer.NewEvent(ctx, 1, producer.ReadEvent, producer.Snapshot{After: &listed[0]}, nil),
+			producer.NewEvent(ctx, 1, producer.UpdateEvent, producer.Snapshot{After: &listed[0]}, nil),
+			producer.NewListEvent(ctx, listed, nil),
+			producer.NewEvent(ctx, 1, producer.DeleteEvent, producer.Snapshot{Before: &listed[0]}, nil),
+		)).To(Succeed())
+
+		Expect(eventTypes()).To(Equal([]desc.RequestAPIEvent_EventType{
+			desc.RequestAPIEvent_CREATE, desc.RequestAPIEvent_DELETE,
+		}))
+	})
+
+	It("Samples out reads but keeps failures and changes", func() {
+		p := producer.NewPolicyProducer(producer.Policy{ReadSampleRate: 0, AggregateList: true}, mockProducer)
+		Expect(p.Send(
+			producer.NewEvent(ctx, 1, producer.ReadEvent, producer.Snapshot{After: &listed[0]}, nil),
+			producer.NewListEvent(ctx, listed, nil),
+			producer.NewEvent(ctx, 0, producer.ReadEvent, producer.NoSnapshot, errors.New("not found")),
+			producer.NewEvent(ctx, 1, producer.UpdateEvent, producer.Snapshot{After: &listed[0]}, nil),
+		)).To(Succeed())
+
+		Expect(eventTypes()).To(Equal([]desc.RequestAPIEvent_EventType{
+			desc.RequestAPIEvent_READ, desc.RequestAPIEvent_UPDATE,
+		}))
+		Expect(sent[0].Error).To(Equal("not found"))
+	})
+
+	It("Parses event type names", func() {
+		eventType, err := producer.ParseEventType("List")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(eventType).To(Equal(producer.ListEvent))
+
+		_, err = producer.ParseEventType("search")
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -6,45 +6,64 @@ import (
 )
 
 type Producer interface {
-	Send(msg ...EventMsg)
+	Send(msg ...EventMsg) error
 	Close() error
 }
 
-// NewProducer Returns new kafka producer
-func NewProducer(topic string, kafkaProducer sarama.SyncProducer) Producer {
-	p := &producer{topic: topic, kafkaProducer: kafkaProducer}
+// NewProducer Returns new kafka producer. Messages are keyed and encoded according to `format`.
+func NewProducer(topic string, format Format, kafkaProducer sarama.SyncProducer) Producer {
+	p := &producer{topic: topic, format: format, kafkaProducer: kafkaProducer}
 	return p
 }
 
+// NewClientProducer returns new kafka producer sending messages with `client`.
+// Unlike NewProducer, it checks health of the client the messages are sent with, see Check.
+func NewClientProducer(topic string, format Format, client sarama.Client) (Producer, error) {
+	kafkaProducer, err := sarama.NewSyncProducerFromClient(client)
+	if err != nil {
+		return nil, err
+	}
+	return &producer{topic: topic, format: format, kafkaProducer: kafkaProducer, client: client}, nil
+}
+
 type producer struct {
 	topic         string
+	format        Format
 	kafkaProducer sarama.SyncProducer
+	client        sarama.Client // nil if the producer was not created from a client
 }
 
-// Send sends a batch of message to Kafka broker
-func (p *producer) Send(msgs ...EventMsg) {
+// Send sends a batch of message to Kafka broker.
+// Errors are logged and returned, so a caller may ignore them if delivery is not critical.
+func (p *producer) Send(msgs ...EventMsg) error {
 	if len(msgs) == 0 {
-		return
+		return nil
 	}
 	preped := make([]*sarama.ProducerMessage, 0, len(msgs))
 	for _, m := range msgs {
-		preped = append(
-			preped,
-			&sarama.ProducerMessage{
-				Topic:     p.topic,
-				Partition: -1,
-				Value:     m,
-			},
-		)
+		prepared, err := p.format.producerMessage(p.topic, m)
+		if err != nil {
+			log.Error().Msgf("failed to prepare message for Kafka: %v", err)
+			return err
+		}
+		preped = append(preped, prepared)
 	}
 	err := p.kafkaProducer.SendMessages(preped)
 	if err != nil {
 		log.Error().Msgf("failed to send messages to Kafka: %v", err)
 	}
+	return err
 }
 
 // Close closes makes sure all send requests are completed
 // and closes producer and underlying client.
 func (p *producer) Close() error {
-	return p.kafkaProducer.Close()
+	err := p.kafkaProducer.Close()
+	// a producer created from a client leaves it open
+	if p.client != nil && !p.client.Closed() {
+		if clientErr := p.client.Close(); err == nil {
+			err = clientErr
+		}
+	}
+	return err
 }
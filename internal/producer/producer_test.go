@@ -0,0 +1,98 @@
+package producer_test
+
+import (
+	"context"
+	"errors"
+	"github.com/Shopify/sarama"
+	saramaMocks "github.com/Shopify/sarama/mocks"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/ozoncp/ocp-request-api/internal/models"
+	"github.com/ozoncp/ocp-request-api/internal/producer"
+)
+
+var _ = Describe("Producer", func() {
+
+	var (
+		ctx           context.Context
+		cfg           *sarama.Config
+		kafkaProducer *saramaMocks.SyncProducer
+		req           models.Request
+	)
+
+	expectKey := func(key sarama.Encoder) saramaMocks.MessageChecker {
+		return func(msg *sarama.ProducerMessage) error {
+			if key == nil {
+				Expect(msg.Key).To(BeNil())
+			} else {
+				Expect(msg.Key).To(Equal(key))
+			}
+			return nil
+		}
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		cfg = saramaMocks.NewTestConfig()
+		cfg.Producer.Partitioner = sarama.NewHashPartitioner
+		kafkaProducer = saramaMocks.NewSyncProducer(GinkgoT(), cfg)
+		req = models.NewRequest(1, 10, 100, "text")
+	})
+
+	AfterEach(func() {
+		Expect(kafkaProducer.Close()).To(Succeed())
+	})
+
+	It("Keys messages by request id", func() {
+		kafkaProducer.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(expectKey(sarama.StringEncoder("1")))
+		kafkaProducer.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(expectKey(sarama.StringEncoder("2")))
+
+		p := producer.NewProducer("events", producer.Format{}, kafkaProducer)
+		Expect(p.Send(
+			producer.NewEvent(ctx, 1, producer.CreateEvent, producer.Snapshot{After: &req}, nil),
+			producer.NewEvent(ctx, 2, producer.DeleteEvent, producer.NoSnapshot, nil),
+		)).To(Succeed())
+	})
+
+	It("Keys messages by user id", func() {
+		kafkaProducer.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(expectKey(sarama.StringEncoder("10")))
+		kafkaProducer.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(expectKey(sarama.StringEncoder("10")))
+
+		p := producer.NewProducer("events", producer.Format{KeyBy: producer.KeyByUserId}, kafkaProducer)
+		Expect(p.Send(
+			producer.NewEvent(ctx, 1, producer.CreateEvent, producer.Snapshot{After: &req}, nil),
+			producer.NewEvent(ctx, 1, producer.DeleteEvent, producer.Snapshot{Before: &req}, nil),
+		)).To(Succeed())
+	})
+
+	It("Does not key events of failed calls", func() {
+		kafkaProducer.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(expectKey(nil))
+
+		p := producer.NewProducer("events", producer.Format{KeyBy: producer.KeyByUserId}, kafkaProducer)
+		Expect(p.Send(
+			producer.NewEvent(ctx, 0, producer.CreateEvent, producer.NoSnapshot, errors.New("test")),
+		)).To(Succeed())
+	})
+
+	It("Keys outbox payloads the same way as events", func() {
+		kafkaProducer.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(expectKey(sarama.StringEncoder("10")))
+
+		data, err := producer.NewEvent(ctx, 1, producer.UpdateEvent, producer.Snapshot{Before: &req, After: &req}, nil).Encode()
+		Expect(err).ToNot(HaveOccurred())
+
+		p := producer.NewProducer("events", producer.Format{KeyBy: producer.KeyByUserId}, kafkaProducer)
+		Expect(p.Send(producer.FromPayload(data, nil))).To(Succeed())
+	})
+})
+
+var _ = Describe("ParsePartitionKey", func() {
+
+	It("Accepts known keys only", func() {
+		key, err := producer.ParsePartitionKey("user_id")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(key).To(Equal(producer.KeyByUserId))
+
+		_, err = producer.ParsePartitionKey("type")
+		Expect(err).To(HaveOccurred())
+	})
+})
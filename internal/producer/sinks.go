@@ -0,0 +1,150 @@
+package producer
+
+import (
+	"encoding/json"
+	desc "github.com/ozoncp/ocp-request-api/pkg/ocp-request-api"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/protobuf/encoding/protojson"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+)
+
+// NewNoopProducer returns a Producer that discards all events
+func NewNoopProducer() Producer {
+	return noopProducer{}
+}
+
+type noopProducer struct{}
+
+func (n noopProducer) Send(msgs ...EventMsg) error {
+	return nil
+}
+
+func (n noopProducer) Close() error {
+	return nil
+}
+
+// NewWriterProducer returns a Producer that writes events to `out` as JSON lines. Close does not close `out`.
+func NewWriterProducer(out io.Writer) Producer {
+	return &writerProducer{out: out}
+}
+
+// NewFileProducer returns a Producer that appends events to a file at `path` as JSON lines
+func NewFileProducer(path string) (Producer, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &writerProducer{out: file, closer: file}, nil
+}
+
+type writerProducer struct {
+	lock   sync.Mutex
+	out    io.Writer
+	closer io.Closer // nil if the producer does not own `out`
+}
+
+func (w *writerProducer) Send(msgs ...EventMsg) error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	for _, m := range msgs {
+		message, err := m.Message()
+		if err != nil {
+			return err
+		}
+		line, err := protojson.Marshal(message)
+		if err != nil {
+			return err
+		}
+		if _, err := w.out.Write(append(line, '\n')); err != nil {
+			log.Error().Msgf("failed to write event: %v", err)
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *writerProducer) Close() error {
+	if w.closer == nil {
+		return nil
+	}
+	return w.closer.Close()
+}
+
+// MemoryProducer keeps the latest events in memory
+type MemoryProducer interface {
+	Producer
+	// Events returns kept events from the oldest to the newest
+	Events() []*desc.RequestAPIEvent
+}
+
+// NewMemoryProducer returns a Producer that keeps up to `capacity` latest events in a ring buffer
+func NewMemoryProducer(capacity int) MemoryProducer {
+	return &memoryProducer{events: make([]*desc.RequestAPIEvent, 0, capacity), capacity: capacity}
+}
+
+type memoryProducer struct {
+	lock     sync.Mutex
+	events   []*desc.RequestAPIEvent
+	capacity int
+	next     int // position of the oldest event once the buffer is full
+}
+
+func (m *memoryProducer) Send(msgs ...EventMsg) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	for _, msg := range msgs {
+		message, err := msg.Message()
+		if err != nil {
+			return err
+		}
+		if m.capacity == 0 {
+			continue
+		}
+		if len(m.events) < m.capacity {
+			m.events = append(m.events, message)
+			continue
+		}
+		m.events[m.next] = message
+		m.next = (m.next + 1) % m.capacity
+	}
+	return nil
+}
+
+func (m *memoryProducer) Events() []*desc.RequestAPIEvent {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	events := make([]*desc.RequestAPIEvent, 0, len(m.events))
+	events = append(events, m.events[m.next:]...)
+	return append(events, m.events[:m.next]...)
+}
+
+func (m *memoryProducer) Close() error {
+	return nil
+}
+
+// NewMemoryHandler returns an HTTP handler responding with a JSON array of events kept by `producer`
+func NewMemoryHandler(producer MemoryProducer) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		events := producer.Events()
+		encoded := make([]json.RawMessage, 0, len(events))
+		for _, event := range events {
+			data, err := protojson.Marshal(event)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			encoded = append(encoded, data)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(encoded); err != nil {
+			log.Error().Msgf("failed to write events: %v", err)
+		}
+	})
+}
@@ -0,0 +1,87 @@
+package producer_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/ozoncp/ocp-request-api/internal/producer"
+	desc "github.com/ozoncp/ocp-request-api/pkg/ocp-request-api"
+	"google.golang.org/protobuf/encoding/protojson"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+)
+
+var _ = Describe("Sinks", func() {
+
+	var ctx context.Context
+
+	readEvents := func(ids ...uint64) []producer.EventMsg {
+		msgs := make([]producer.EventMsg, 0, len(ids))
+		for _, id := range ids {
+			msgs = append(msgs, producer.NewEvent(ctx, id, producer.ReadEvent, producer.NoSnapshot, nil))
+		}
+		return msgs
+	}
+
+	requestIds := func(events []*desc.RequestAPIEvent) []uint64 {
+		ids := make([]uint64, 0, len(events))
+		for _, event := range events {
+			ids = append(ids, event.RequestId)
+		}
+		return ids
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+	})
+
+	It("Writes events as JSON lines", func() {
+		out := &bytes.Buffer{}
+		p := producer.NewWriterProducer(out)
+		Expect(p.Send(readEvents(1, 2)...)).To(Succeed())
+		Expect(p.Close()).To(Succeed())
+
+		lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+		Expect(lines).To(HaveLen(2))
+		event := &desc.RequestAPIEvent{}
+		Expect(protojson.Unmarshal([]byte(lines[1]), event)).To(Succeed())
+		Expect(event.RequestId).To(Equal(uint64(2)))
+	})
+
+	It("Keeps the latest events in memory", func() {
+		p := producer.NewMemoryProducer(3)
+		Expect(p.Send(readEvents(1, 2)...)).To(Succeed())
+		Expect(requestIds(p.Events())).To(Equal([]uint64{1, 2}))
+
+		Expect(p.Send(readEvents(3, 4, 5)...)).To(Succeed())
+		Expect(requestIds(p.Events())).To(Equal([]uint64{3, 4, 5}))
+
+		Expect(p.Send(readEvents(6)...)).To(Succeed())
+		Expect(requestIds(p.Events())).To(Equal([]uint64{4, 5, 6}))
+	})
+
+	It("Serves events kept in memory", func() {
+		p := producer.NewMemoryProducer(10)
+		Expect(p.Send(readEvents(1, 2)...)).To(Succeed())
+
+		recorder := httptest.NewRecorder()
+		producer.NewMemoryHandler(p).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/debug/events", nil))
+
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+		var served []json.RawMessage
+		Expect(json.Unmarshal(recorder.Body.Bytes(), &served)).To(Succeed())
+		Expect(served).To(HaveLen(2))
+		event := &desc.RequestAPIEvent{}
+		Expect(protojson.Unmarshal(served[0], event)).To(Succeed())
+		Expect(event.RequestId).To(Equal(uint64(1)))
+	})
+
+	It("Discards events", func() {
+		p := producer.NewNoopProducer()
+		Expect(p.Send(readEvents(1)...)).To(Succeed())
+		Expect(p.Close()).To(Succeed())
+	})
+})
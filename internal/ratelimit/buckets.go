@@ -0,0 +1,66 @@
+package ratelimit
+
+import (
+	"golang.org/x/time/rate"
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often buckets of idle callers are removed
+const sweepInterval = time.Minute
+
+// buckets keeps a token bucket per caller and method
+type buckets struct {
+	lock      sync.Mutex
+	byKey     map[string]*bucket
+	lastSweep time.Time
+}
+
+type bucket struct {
+	limiter  *rate.Limiter
+	fullAt   time.Time // when the bucket refills completely if not used
+	interval time.Duration
+}
+
+func newBuckets() *buckets {
+	return &buckets{byKey: make(map[string]*bucket)}
+}
+
+// take takes a token from a bucket of `key`. Returns false and time to wait for a token if the bucket is empty.
+func (b *buckets) take(key string, limit Limit, now time.Time) (time.Duration, bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.sweep(now)
+
+	bk, ok := b.byKey[key]
+	if !ok {
+		bk = &bucket{
+			limiter:  rate.NewLimiter(rate.Limit(limit.Rate), limit.burst()),
+			interval: time.Duration(float64(limit.burst()) / limit.Rate * float64(time.Second)),
+		}
+		b.byKey[key] = bk
+	}
+
+	// a single token never exceeds the burst, so the reservation is always OK
+	reservation := bk.limiter.ReserveN(now, 1)
+	if delay := reservation.DelayFrom(now); delay > 0 {
+		reservation.CancelAt(now)
+		return delay, false
+	}
+	bk.fullAt = now.Add(bk.interval)
+	return 0, true
+}
+
+// sweep removes full buckets, they are no different from new ones
+func (b *buckets) sweep(now time.Time) {
+	if now.Sub(b.lastSweep) < sweepInterval {
+		return
+	}
+	b.lastSweep = now
+	for key, bk := range b.byKey {
+		if !now.Before(bk.fullAt) {
+			delete(b.byKey, key)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// quota counts requests created per user within a UTC day
+type quota struct {
+	lock  sync.Mutex
+	limit uint64
+	day   time.Time // start of the current day
+	used  map[uint64]uint64
+}
+
+func newQuota(limit uint64) *quota {
+	return &quota{limit: limit, used: make(map[uint64]uint64)}
+}
+
+// reserve counts `created` requests per user if every user stays within the quota.
+// Returns false and time until the quota is reset otherwise.
+func (q *quota) reserve(created map[uint64]uint64, now time.Time) (time.Duration, bool) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	q.resetOnNewDay(now)
+	for userId, count := range created {
+		if q.used[userId]+count > q.limit {
+			return q.day.AddDate(0, 0, 1).Sub(now), false
+		}
+	}
+	for userId, count := range created {
+		q.used[userId] += count
+	}
+	return 0, true
+}
+
+// refund returns `created` requests reserved earlier to the quota
+func (q *quota) refund(created map[uint64]uint64, now time.Time) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if q.resetOnNewDay(now) {
+		return
+	}
+	for userId, count := range created {
+		if q.used[userId] <= count {
+			delete(q.used, userId)
+		} else {
+			q.used[userId] -= count
+		}
+	}
+}
+
+// resetOnNewDay forgets used quota if a new day has started. Returns true if it was reset.
+func (q *quota) resetOnNewDay(now time.Time) bool {
+	day := now.UTC().Truncate(24 * time.Hour)
+	if day.Equal(q.day) {
+		return false
+	}
+	q.day = day
+	q.used = make(map[uint64]uint64)
+	return true
+}
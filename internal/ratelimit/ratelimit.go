@@ -0,0 +1,205 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"github.com/ozoncp/ocp-request-api/internal/idempotency"
+	"github.com/ozoncp/ocp-request-api/internal/identity"
+	desc "github.com/ozoncp/ocp-request-api/pkg/ocp-request-api"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"math"
+	"net"
+	"path"
+	"strings"
+	"time"
+)
+
+// RetryAfterMetadataKey is a response header metadata key with a number of seconds to wait before retrying a rejected call.
+// The gateway returns it as Grpc-Metadata-Retry-After HTTP header.
+const RetryAfterMetadataKey = "retry-after"
+
+// Limit is a token bucket allowing `Rate` calls per second on average and bursts of up to `Burst` calls
+type Limit struct {
+	Rate  float64 `mapstructure:"rate"`
+	Burst int     `mapstructure:"burst"` // at least 1
+}
+
+// Unlimited returns true if the limit does not restrict calls
+func (l Limit) Unlimited() bool {
+	return l.Rate <= 0
+}
+
+func (l Limit) burst() int {
+	if l.Burst < 1 {
+		return 1
+	}
+	return l.Burst
+}
+
+// Config defines limits of API calls
+type Config struct {
+	// Default limits calls of every caller to methods not listed in Methods
+	Default Limit
+	// Methods limits calls of every caller by method name, e.g. MultiCreateRequestV1. Names are case insensitive.
+	Methods map[string]Limit
+	// DailyCreateQuota is a max number of requests created for a user per UTC day. Zero means no quota.
+	DailyCreateQuota uint64
+	// Keys tells repeated create calls with an idempotency key apart, they create nothing and do not spend the quota.
+	// Every create call spends the quota if nil.
+	Keys idempotency.Store
+}
+
+// UnaryServerInterceptor rejects calls exceeding rate limits or daily quota with ResourceExhausted code.
+// Callers are told apart by identity or, for anonymous calls, by IP address. Limits are kept in memory
+// of a single service instance.
+func UnaryServerInterceptor(cfg Config) grpc.UnaryServerInterceptor {
+	l := newLimiter(cfg, time.Now)
+	return l.intercept
+}
+
+type limiter struct {
+	defaultLimit Limit
+	methods      map[string]Limit
+	buckets      *buckets
+	quota        *quota // nil if there is no quota
+	keys         idempotency.Store
+	now          func() time.Time
+}
+
+func newLimiter(cfg Config, now func() time.Time) *limiter {
+	l := &limiter{
+		defaultLimit: cfg.Default,
+		methods:      make(map[string]Limit, len(cfg.Methods)),
+		buckets:      newBuckets(),
+		keys:         cfg.Keys,
+		now:          now,
+	}
+	for method, limit := range cfg.Methods {
+		l.methods[strings.ToLower(method)] = limit
+	}
+	if cfg.DailyCreateQuota > 0 {
+		l.quota = newQuota(cfg.DailyCreateQuota)
+	}
+	return l
+}
+
+func (l *limiter) intercept(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	method := path.Base(info.FullMethod)
+	now := l.now()
+
+	limit, ok := l.methods[strings.ToLower(method)]
+	if !ok {
+		limit = l.defaultLimit
+	}
+	if !limit.Unlimited() {
+		if delay, allowed := l.buckets.take(method+" "+callerKey(ctx), limit, now); !allowed {
+			return nil, exhausted(ctx, delay, "rate limit of %v exceeded", method)
+		}
+	}
+
+	if l.quota == nil {
+		return handler(ctx, req)
+	}
+	created := createdPerUser(req)
+	if len(created) == 0 || l.repeated(ctx, method, req, created) {
+		return handler(ctx, req)
+	}
+	if delay, allowed := l.quota.reserve(created, now); !allowed {
+		return nil, exhausted(ctx, delay, "daily quota of created requests exceeded")
+	}
+	resp, err := handler(ctx, req)
+	if err != nil {
+		// nothing is created, most likely
+		l.quota.refund(created, now)
+	}
+	return resp, err
+}
+
+// exhausted returns ResourceExhausted error and sets retry-after header of the response
+func exhausted(ctx context.Context, retryAfter time.Duration, format string, args ...interface{}) error {
+	seconds := int64(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	// fails only if the call is not a gRPC one, the error is still returned then
+	_ = grpc.SetHeader(ctx, metadata.Pairs(RetryAfterMetadataKey, fmt.Sprint(seconds)))
+	return status.Errorf(codes.ResourceExhausted, format+", retry after %vs", append(args, seconds)...)
+}
+
+// repeated returns true if `req` repeats a create call with a known idempotency key
+func (l *limiter) repeated(ctx context.Context, method string, req interface{}, created map[uint64]uint64) bool {
+	if l.keys == nil {
+		return false
+	}
+	var field string
+	switch r := req.(type) {
+	case *desc.CreateRequestV1Request:
+		field = r.IdempotencyKey
+	case *desc.MultiCreateRequestV1Request:
+		field = r.IdempotencyKey
+	}
+	value := idempotency.KeyFromRequest(ctx, field)
+	if value == "" {
+		return false
+	}
+
+	userIds := make([]uint64, 0, len(created))
+	for userId := range created {
+		userIds = append(userIds, userId)
+	}
+	_, found, err := l.keys.Get(ctx, idempotency.NewKey(ctx, method, value, userIds...))
+	if err != nil {
+		// the call is charged then, the quota is refunded if it fails
+		log.Ctx(ctx).Warn().Err(err).Msg("Failed to check idempotency key before spending the quota")
+		return false
+	}
+	return found
+}
+
+// callerKey tells callers apart by identity or IP address of anonymous callers
+func callerKey(ctx context.Context) string {
+	if caller, ok := identity.FromContext(ctx); ok {
+		return "sub:" + caller.Subject
+	}
+
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return "unknown"
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		host = p.Addr.String()
+	}
+	// the gateway runs alongside the service and appends address of its client to X-Forwarded-For.
+	// Entries before it are passed by the client and can not be trusted.
+	if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			if forwarded := md.Get("x-forwarded-for"); len(forwarded) > 0 {
+				entries := strings.Split(forwarded[len(forwarded)-1], ",")
+				return "ip:" + strings.TrimSpace(entries[len(entries)-1])
+			}
+		}
+	}
+	return "ip:" + host
+}
+
+// createdPerUser returns number of requests a call creates per user id
+func createdPerUser(req interface{}) map[uint64]uint64 {
+	switch r := req.(type) {
+	case *desc.CreateRequestV1Request:
+		return map[uint64]uint64{r.UserId: 1}
+	case *desc.MultiCreateRequestV1Request:
+		created := make(map[uint64]uint64)
+		for _, item := range r.Requests {
+			created[item.UserId]++
+		}
+		return created
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,161 @@
+package ratelimit
+
+import (
+	"context"
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/ozoncp/ocp-request-api/internal/idempotency"
+	"github.com/ozoncp/ocp-request-api/internal/identity"
+	"github.com/ozoncp/ocp-request-api/internal/mocks"
+	desc "github.com/ozoncp/ocp-request-api/pkg/ocp-request-api"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"net"
+	"time"
+)
+
+// headerStream records response headers set by the interceptor
+type headerStream struct {
+	grpc.ServerTransportStream
+	header metadata.MD
+}
+
+func (s *headerStream) SetHeader(md metadata.MD) error {
+	s.header = metadata.Join(s.header, md)
+	return nil
+}
+
+var _ = Describe("Limiter", func() {
+
+	var (
+		now     time.Time
+		stream  *headerStream
+		handled int
+		failing bool
+	)
+
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		handled++
+		if failing {
+			return nil, status.Error(codes.Internal, "failed")
+		}
+		return "ok", nil
+	}
+
+	call := func(l *limiter, caller, method string, req interface{}) error {
+		ctx := identity.NewContext(context.Background(), identity.Identity{Subject: caller})
+		ctx = grpc.NewContextWithServerTransportStream(ctx, stream)
+		_, err := l.intercept(ctx, req, &grpc.UnaryServerInfo{FullMethod: "/ocp.request.api.OcpRequestApi/" + method}, handler)
+		return err
+	}
+
+	multiCreate := func(userIds ...uint64) *desc.MultiCreateRequestV1Request {
+		req := &desc.MultiCreateRequestV1Request{}
+		for _, userId := range userIds {
+			req.Requests = append(req.Requests, &desc.CreateRequestV1Request{UserId: userId})
+		}
+		return req
+	}
+
+	BeforeEach(func() {
+		now = time.Date(2021, 9, 1, 23, 59, 0, 0, time.UTC)
+		stream = &headerStream{}
+		handled = 0
+		failing = false
+	})
+
+	clock := func() time.Time { return now }
+
+	It("Limits calls of every caller", func() {
+		l := newLimiter(Config{Default: Limit{Rate: 1, Burst: 2}}, clock)
+
+		Expect(call(l, "alice", "ListRequestV1", nil)).To(Succeed())
+		Expect(call(l, "alice", "ListRequestV1", nil)).To(Succeed())
+		err := call(l, "alice", "ListRequestV1", nil)
+		Expect(status.Code(err)).To(Equal(codes.ResourceExhausted))
+		Expect(stream.header.Get(RetryAfterMetadataKey)).To(Equal([]string{"1"}))
+
+		Expect(call(l, "bob", "ListRequestV1", nil)).To(Succeed())
+
+		now = now.Add(time.Second)
+		Expect(call(l, "alice", "ListRequestV1", nil)).To(Succeed())
+		Expect(handled).To(Equal(4))
+	})
+
+	It("Applies limits of a method", func() {
+		l := newLimiter(Config{Methods: map[string]Limit{"multicreaterequestv1": {Rate: 0.1, Burst: 1}}}, clock)
+
+		Expect(call(l, "alice", "MultiCreateRequestV1", multiCreate(1))).To(Succeed())
+		err := call(l, "alice", "MultiCreateRequestV1", multiCreate(1))
+		Expect(status.Code(err)).To(Equal(codes.ResourceExhausted))
+		Expect(stream.header.Get(RetryAfterMetadataKey)).To(Equal([]string{"10"}))
+
+		// default limit is not set
+		for i := 0; i < 10; i++ {
+			Expect(call(l, "alice", "ListRequestV1", nil)).To(Succeed())
+		}
+	})
+
+	It("Limits requests created per user a day", func() {
+		l := newLimiter(Config{DailyCreateQuota: 3}, clock)
+
+		Expect(call(l, "alice", "MultiCreateRequestV1", multiCreate(5, 5))).To(Succeed())
+		err := call(l, "alice", "MultiCreateRequestV1", multiCreate(5, 5, 6))
+		Expect(status.Code(err)).To(Equal(codes.ResourceExhausted))
+		// retry when the next day starts
+		Expect(stream.header.Get(RetryAfterMetadataKey)).To(Equal([]string{"60"}))
+
+		failing = true
+		Expect(call(l, "alice", "CreateRequestV1", &desc.CreateRequestV1Request{UserId: 5})).ToNot(Succeed())
+		failing = false
+		Expect(call(l, "alice", "CreateRequestV1", &desc.CreateRequestV1Request{UserId: 5})).To(Succeed())
+		err = call(l, "bob", "CreateRequestV1", &desc.CreateRequestV1Request{UserId: 5})
+		Expect(status.Code(err)).To(Equal(codes.ResourceExhausted))
+
+		now = now.Add(time.Minute)
+		Expect(call(l, "alice", "MultiCreateRequestV1", multiCreate(5, 5, 5))).To(Succeed())
+	})
+
+	It("Does not spend the quota on repeated calls with a known idempotency key", func() {
+		mockCtrl := gomock.NewController(GinkgoT())
+		defer mockCtrl.Finish()
+		keys := mocks.NewMockStore(mockCtrl)
+		l := newLimiter(Config{DailyCreateQuota: 2, Keys: keys}, clock)
+
+		keys.EXPECT().
+			Get(gomock.Any(), idempotency.Key{Method: "MultiCreateRequestV1", Caller: "sub:alice", Value: "retry-1"}).
+			Return(nil, false, nil)
+		keys.EXPECT().
+			Get(gomock.Any(), idempotency.Key{Method: "MultiCreateRequestV1", Caller: "sub:alice", Value: "retry-1"}).
+			Return([]uint64{1, 2}, true, nil).
+			Times(2)
+
+		req := multiCreate(5, 5)
+		req.IdempotencyKey = "retry-1"
+		Expect(call(l, "alice", "MultiCreateRequestV1", req)).To(Succeed())
+		Expect(call(l, "alice", "MultiCreateRequestV1", req)).To(Succeed())
+		Expect(call(l, "alice", "MultiCreateRequestV1", req)).To(Succeed())
+
+		err := call(l, "alice", "CreateRequestV1", &desc.CreateRequestV1Request{UserId: 5})
+		Expect(status.Code(err)).To(Equal(codes.ResourceExhausted))
+		Expect(handled).To(Equal(3))
+	})
+
+	It("Tells anonymous callers apart by the address appended by the gateway", func() {
+		gateway := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 40000}
+		ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: gateway})
+		Expect(callerKey(ctx)).To(Equal("ip:127.0.0.1"))
+
+		// the first entry is set by the client
+		ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("x-forwarded-for", "10.0.0.1, 192.0.2.7"))
+		Expect(callerKey(ctx)).To(Equal("ip:192.0.2.7"))
+
+		remote := &net.TCPAddr{IP: net.IPv4(192, 0, 2, 8), Port: 40000}
+		ctx = peer.NewContext(ctx, &peer.Peer{Addr: remote})
+		Expect(callerKey(ctx)).To(Equal("ip:192.0.2.8"))
+	})
+})
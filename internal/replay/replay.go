@@ -0,0 +1,97 @@
+package replay
+
+import (
+	"context"
+	"github.com/ozoncp/ocp-request-api/internal/producer"
+	"github.com/ozoncp/ocp-request-api/internal/repo"
+	"time"
+)
+
+// Replayer publishes the current state of stored requests as CREATE events with replay marker,
+// so that a new consumer can build its state from scratch.
+type Replayer interface {
+	// Replay publishes events of requests matching `filter` ordered by id. Returns a number of published events.
+	Replay(ctx context.Context, filter repo.Filter) (uint64, error)
+}
+
+// NewReplayer creates a Replayer that reads requests by `batchSize` and publishes up to `rate` events per second.
+// Zero `rate` means no limit, events of a batch are sent at once then. Otherwise events are sent one by one.
+func NewReplayer(repo repo.Repo, producer producer.Producer, batchSize uint64, rate uint) Replayer {
+	r := &replayer{
+		repo:      repo,
+		producer:  producer,
+		batchSize: batchSize,
+	}
+	if rate > 0 {
+		r.interval = time.Second / time.Duration(rate)
+	}
+	return r
+}
+
+type replayer struct {
+	repo      repo.Repo
+	producer  producer.Producer
+	batchSize uint64
+	interval  time.Duration // minimal interval between events, 0 if not limited
+}
+
+func (r *replayer) Replay(ctx context.Context, filter repo.Filter) (uint64, error) {
+	var (
+		replayed uint64
+		lastId   uint64
+		nextSend = time.Now()
+	)
+
+	for {
+		requests, err := r.repo.ListAfter(ctx, lastId, filter, r.batchSize)
+		if err != nil {
+			return replayed, err
+		}
+		if len(requests) == 0 {
+			return replayed, nil
+		}
+
+		msgs := make([]producer.EventMsg, 0, len(requests))
+		for _, req := range requests {
+			msgs = append(msgs, producer.NewReplayEvent(ctx, req))
+		}
+		if r.interval == 0 {
+			if err := r.producer.Send(msgs...); err != nil {
+				return replayed, err
+			}
+			replayed += uint64(len(msgs))
+		} else {
+			for _, msg := range msgs {
+				if err := r.waitUntil(ctx, nextSend); err != nil {
+					return replayed, err
+				}
+				nextSend = time.Now().Add(r.interval)
+				if err := r.producer.Send(msg); err != nil {
+					return replayed, err
+				}
+				replayed++
+			}
+		}
+
+		lastId = requests[len(requests)-1].Id
+		if uint64(len(requests)) < r.batchSize {
+			return replayed, nil
+		}
+	}
+}
+
+func (r *replayer) waitUntil(ctx context.Context, t time.Time) error {
+	delay := time.Until(t)
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,127 @@
+package replay_test
+
+import (
+	"context"
+	"errors"
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/ozoncp/ocp-request-api/internal/mocks"
+	"github.com/ozoncp/ocp-request-api/internal/models"
+	"github.com/ozoncp/ocp-request-api/internal/producer"
+	"github.com/ozoncp/ocp-request-api/internal/replay"
+	"github.com/ozoncp/ocp-request-api/internal/repo"
+	"time"
+)
+
+// timedProducer records when every Send is called and how many events it is passed
+type timedProducer struct {
+	sends []time.Time
+	sizes []int
+}
+
+func (p *timedProducer) Send(msgs ...producer.EventMsg) error {
+	p.sends = append(p.sends, time.Now())
+	p.sizes = append(p.sizes, len(msgs))
+	return nil
+}
+
+func (p *timedProducer) Close() error {
+	return nil
+}
+
+var _ = Describe("Replayer", func() {
+
+	var (
+		mockCtrl *gomock.Controller
+		mockRepo *mocks.MockRepo
+		sink     producer.MemoryProducer
+		ctx      context.Context
+		filter   repo.Filter
+	)
+
+	ctxType := gomock.Any()
+
+	BeforeEach(func() {
+		mockCtrl = gomock.NewController(GinkgoT())
+		mockRepo = mocks.NewMockRepo(mockCtrl)
+		sink = producer.NewMemoryProducer(10)
+		ctx = context.Background()
+		filter = repo.Filter{UserId: 5}
+	})
+
+	AfterEach(func() {
+		mockCtrl.Finish()
+	})
+
+	It("Publishes all matching requests page by page", func() {
+		gomock.InOrder(
+			mockRepo.EXPECT().
+				ListAfter(ctxType, uint64(0), filter, uint64(2)).
+				Return([]models.Request{models.NewRequest(1, 5, 1, "one"), models.NewRequest(3, 5, 1, "three")}, nil),
+			mockRepo.EXPECT().
+				ListAfter(ctxType, uint64(3), filter, uint64(2)).
+				Return([]models.Request{models.NewRequest(4, 5, 1, "four")}, nil),
+		)
+
+		replayed, err := replay.NewReplayer(mockRepo, sink, 2, 0).Replay(ctx, filter)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(replayed).To(Equal(uint64(3)))
+
+		events := sink.Events()
+		Expect(events).To(HaveLen(3))
+		for ix, id := range []uint64{1, 3, 4} {
+			Expect(events[ix].RequestId).To(Equal(id))
+			Expect(events[ix].Replay).To(BeTrue())
+			Expect(events[ix].After.UserId).To(Equal(uint64(5)))
+		}
+	})
+
+	It("Stops on an empty page", func() {
+		gomock.InOrder(
+			mockRepo.EXPECT().
+				ListAfter(ctxType, uint64(0), filter, uint64(2)).
+				Return([]models.Request{models.NewRequest(1, 5, 1, "one"), models.NewRequest(2, 5, 1, "two")}, nil),
+			mockRepo.EXPECT().
+				ListAfter(ctxType, uint64(2), filter, uint64(2)).
+				Return([]models.Request{}, nil),
+		)
+
+		replayed, err := replay.NewReplayer(mockRepo, sink, 2, 0).Replay(ctx, filter)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(replayed).To(Equal(uint64(2)))
+	})
+
+	It("Limits publishing rate", func() {
+		gomock.InOrder(
+			mockRepo.EXPECT().
+				ListAfter(ctxType, uint64(0), filter, uint64(2)).
+				Return([]models.Request{models.NewRequest(1, 5, 1, "one"), models.NewRequest(2, 5, 1, "two")}, nil),
+			mockRepo.EXPECT().
+				ListAfter(ctxType, uint64(2), filter, uint64(2)).
+				Return([]models.Request{models.NewRequest(3, 5, 1, "three")}, nil),
+		)
+
+		timed := &timedProducer{}
+		replayed, err := replay.NewReplayer(mockRepo, timed, 2, 10).Replay(ctx, filter)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(replayed).To(Equal(uint64(3)))
+
+		// events are sent one by one 100ms apart at 10 events per second, including events of a batch
+		Expect(timed.sizes).To(Equal([]int{1, 1, 1}))
+		for ix := 1; ix < len(timed.sends); ix++ {
+			Expect(timed.sends[ix].Sub(timed.sends[ix-1])).To(BeNumerically(">=", 100*time.Millisecond))
+		}
+	})
+
+	It("Reports repository errors", func() {
+		repoErr := errors.New("connection refused")
+		mockRepo.EXPECT().
+			ListAfter(ctxType, uint64(0), filter, uint64(2)).
+			Return(nil, repoErr)
+
+		_, err := replay.NewReplayer(mockRepo, sink, 2, 0).Replay(ctx, filter)
+		Expect(err).To(Equal(repoErr))
+		Expect(sink.Events()).To(BeEmpty())
+	})
+})
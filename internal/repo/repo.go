@@ -2,52 +2,82 @@ package repo
 
 import (
 	"context"
+	stdsql "database/sql"
+	"encoding/json"
 	"errors"
 	sq "github.com/Masterminds/squirrel"
 	sql "github.com/jmoiron/sqlx"
+	"github.com/ozoncp/ocp-request-api/internal/authz"
+	"github.com/ozoncp/ocp-request-api/internal/idempotency"
 	"github.com/ozoncp/ocp-request-api/internal/models"
+	"github.com/ozoncp/ocp-request-api/internal/producer"
+	"github.com/ozoncp/ocp-request-api/internal/utils"
 )
 
 var NotFound = errors.New("request does not exist")
 
-// Repo is a Requests storage
+// Repo is a Requests storage.
+// Every change made with Add, AddMany, Update and Remove is recorded as an event in the outbox table
+// within the same transaction, so the event is published if and only if the change is committed.
+// Reads, updates and removals are limited to requests of the caller's user if the caller is restricted
+// to own requests (see authz.RestrictedTo), other requests are reported as NotFound.
 type Repo interface {
 	Add(ctx context.Context, request models.Request) (uint64, error)
 	AddMany(ctx context.Context, request []models.Request) ([]uint64, error)
+	AddOnce(ctx context.Context, key idempotency.Key, requests []models.Request, batchSize uint) ([]uint64, bool, error)
 	List(ctx context.Context, limit, offset uint64) ([]models.Request, error)
+	ListAfter(ctx context.Context, afterId uint64, filter Filter, limit uint64) ([]models.Request, error)
 	Describe(ctx context.Context, id uint64) (*models.Request, error)
 	Remove(ctx context.Context, id uint64) error
 	Update(ctx context.Context, id models.Request) error
 }
 
-// NewRepo builds a new Repo from a given db connection
-func NewRepo(db *sql.DB) Repo {
+// Filter narrows down requests returned by ListAfter. Zero fields do not filter anything.
+type Filter struct {
+	FromId uint64 // the lowest id, inclusive
+	ToId   uint64 // the highest id, inclusive
+	UserId uint64
+}
+
+// NewRepo builds a new Repo from a given db connection. Idempotency keys of AddOnce are kept in `keys`.
+func NewRepo(db *sql.DB, keys idempotency.Store) Repo {
 	stmtCache := sq.NewStmtCache(db)
 
 	return &repo{
+		db:         db,
 		stmBuilder: sq.StatementBuilder.PlaceholderFormat(sq.Dollar).RunWith(stmtCache),
+		keys:       keys,
 	}
 }
 
+type txBeginner interface {
+	BeginTx(ctx context.Context, opts *stdsql.TxOptions) (*stdsql.Tx, error)
+}
+
 type repo struct {
+	db         txBeginner
 	stmBuilder sq.StatementBuilderType
+	keys       idempotency.Store
 }
 
 // Add stores a single Request and returns its ID
 func (r *repo) Add(ctx context.Context, request models.Request) (uint64, error) {
-	query := r.stmBuilder.Insert("requests").
-		Columns("user_id", "type", "text").
-		Suffix("RETURNING id").
-		Values(request.UserId, request.Type, request.Text)
 	newTaskId := uint64(0)
 
-	rows, err := query.QueryContext(ctx)
+	err := r.inTx(ctx, func(tx sq.StatementBuilderType) error {
+		query := tx.Insert("requests").
+			Columns("user_id", "type", "text").
+			Suffix("RETURNING id").
+			Values(request.UserId, request.Type, request.Text)
+
+		if err := query.QueryRowContext(ctx).Scan(&newTaskId); err != nil {
+			return err
+		}
+		created := models.NewRequest(newTaskId, request.UserId, request.Type, request.Text)
+		return addToOutbox(ctx, tx, producer.CreateEvent, producer.Snapshot{After: &created})
+	})
 	if err != nil {
-		return newTaskId, err
-	}
-	rows.Next()
-	if err := rows.Scan(&newTaskId); err != nil {
-		return newTaskId, err
+		return 0, err
 	}
 
 	return newTaskId, nil
@@ -55,7 +85,57 @@ func (r *repo) Add(ctx context.Context, request models.Request) (uint64, error)
 
 // AddMany stores a batch of Requests with a single database query
 func (r *repo) AddMany(ctx context.Context, requests []models.Request) ([]uint64, error) {
-	query := r.stmBuilder.Insert("requests").
+	var newIds []uint64
+
+	err := r.inTx(ctx, func(tx sq.StatementBuilderType) error {
+		var err error
+		newIds, err = addMany(ctx, tx, requests)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return newIds, nil
+}
+
+// AddOnce stores a batch of Requests unless they were already stored with the same idempotency `key`.
+// The key is reserved within the same transaction, so concurrent calls with a key store requests once.
+// Requests are inserted by queries of `batchSize` requests, so that a query does not exceed the limit of parameters.
+// Returns ids of stored requests and true, or ids of requests stored with the key earlier and false.
+func (r *repo) AddOnce(
+	ctx context.Context, key idempotency.Key, requests []models.Request, batchSize uint,
+) ([]uint64, bool, error) {
+	var (
+		ids     []uint64
+		created bool
+	)
+
+	err := r.inTx(ctx, func(tx sq.StatementBuilderType) error {
+		var err error
+		ids, created, err = r.keys.Reserve(ctx, tx, key)
+		if err != nil || !created {
+			return err
+		}
+
+		ids = make([]uint64, 0, len(requests))
+		for _, batch := range utils.SplitToBulks(requests, batchSize) {
+			batchIds, err := addMany(ctx, tx, batch)
+			if err != nil {
+				return err
+			}
+			ids = append(ids, batchIds...)
+		}
+		return r.keys.Complete(ctx, tx, key, ids)
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return ids, created, nil
+}
+
+// addMany stores a batch of Requests with a single query within transaction `tx` and returns their ids
+func addMany(ctx context.Context, tx sq.StatementBuilderType, requests []models.Request) ([]uint64, error) {
+	query := tx.Insert("requests").
 		Columns("user_id", "type", "text").
 		Suffix("RETURNING id")
 
@@ -70,10 +150,22 @@ func (r *repo) AddMany(ctx context.Context, requests []models.Request) ([]uint64
 	newIds := make([]uint64, 0, len(requests))
 	for rows.Next() {
 		id := uint64(0)
-		rows.Scan(&id)
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
 		newIds = append(newIds, id)
 	}
-	return newIds, nil
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+
+	snapshots := make([]producer.Snapshot, 0, len(newIds))
+	for ix, id := range newIds {
+		created := models.NewRequest(id, requests[ix].UserId, requests[ix].Type, requests[ix].Text)
+		snapshots = append(snapshots, producer.Snapshot{After: &created})
+	}
+	return newIds, addToOutbox(ctx, tx, producer.CreateEvent, snapshots...)
 }
 
 // List returns a list of stored Requests
@@ -82,6 +174,9 @@ func (r *repo) List(ctx context.Context, limit, offset uint64) ([]models.Request
 		From("requests").
 		Offset(offset). //not the fastest approach but will keep as is in favor of simplicity (ability to remove objects makes it a bit complex)
 		Limit(limit)
+	if userId, restricted := authz.RestrictedTo(ctx); restricted {
+		query = query.Where(sq.Eq{"user_id": userId})
+	}
 
 	rows, err := query.QueryContext(ctx)
 	if err != nil {
@@ -99,11 +194,50 @@ func (r *repo) List(ctx context.Context, limit, offset uint64) ([]models.Request
 	return requests, nil
 }
 
+// ListAfter returns up to `limit` requests matching `filter` with ids greater than `afterId` ordered by id.
+// Unlike List, it is cheap to walk through the whole table page by page this way.
+func (r *repo) ListAfter(ctx context.Context, afterId uint64, filter Filter, limit uint64) ([]models.Request, error) {
+	conditions := sq.And{sq.Gt{"id": afterId}}
+	if filter.FromId > 0 {
+		conditions = append(conditions, sq.GtOrEq{"id": filter.FromId})
+	}
+	if filter.ToId > 0 {
+		conditions = append(conditions, sq.LtOrEq{"id": filter.ToId})
+	}
+	if filter.UserId > 0 {
+		conditions = append(conditions, sq.Eq{"user_id": filter.UserId})
+	}
+	if userId, restricted := authz.RestrictedTo(ctx); restricted {
+		conditions = append(conditions, sq.Eq{"user_id": userId})
+	}
+
+	rows, err := r.stmBuilder.Select("id, user_id, type, text").
+		From("requests").
+		Where(conditions).
+		OrderBy("id").
+		Limit(limit).
+		QueryContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	requests := make([]models.Request, 0, limit)
+	for rows.Next() {
+		req := models.Request{}
+		if err := rows.Scan(&req.Id, &req.UserId, &req.Type, &req.Text); err != nil {
+			return nil, err
+		}
+		requests = append(requests, req)
+	}
+	return requests, rows.Err()
+}
+
 // Describe returns a single Request by its ID
 func (r *repo) Describe(ctx context.Context, id uint64) (*models.Request, error) {
 	query := r.stmBuilder.Select("id, user_id, type, text").
 		From("requests").
-		Where("id = ?", id)
+		Where(accessible(ctx, id))
 	row, err := query.QueryContext(ctx)
 	if err != nil {
 		return nil, err
@@ -121,53 +255,129 @@ func (r *repo) Describe(ctx context.Context, id uint64) (*models.Request, error)
 
 // Remove deletes Request with a given ID from the database. Returns NotFound if Request doesn't exist.
 func (r *repo) Remove(ctx context.Context, id uint64) error {
-	query := r.stmBuilder.Delete("requests").
-		Where("id = ?", id)
-	ret, err := query.ExecContext(ctx)
-	if err != nil {
-		return err
-	}
+	return r.inTx(ctx, func(tx sq.StatementBuilderType) error {
+		query := tx.Delete("requests").
+			Where(accessible(ctx, id)).
+			Suffix("RETURNING id, user_id, type, text")
 
-	rowsDeleted, err := ret.RowsAffected()
-	if err != nil {
-		return err
-	} else if rowsDeleted == 0 {
-		return NotFound
-	}
-
-	return nil
+		removed, err := scanRequest(query.QueryRowContext(ctx))
+		if err != nil {
+			return err
+		}
 
+		return addToOutbox(ctx, tx, producer.DeleteEvent, producer.Snapshot{Before: removed})
+	})
 }
 
 // Update updates existing request.Returns NotFound error if request doesn't exist,
 func (r *repo) Update(ctx context.Context, request models.Request) error {
-	query := r.stmBuilder.
-		Update("requests")
+	return r.inTx(ctx, func(tx sq.StatementBuilderType) error {
+		before, err := scanRequest(
+			tx.Select("id, user_id, type, text").
+				From("requests").
+				Where(accessible(ctx, request.Id)).
+				Suffix("FOR UPDATE").
+				QueryRowContext(ctx),
+		)
+		if err != nil {
+			return err
+		}
 
-	if request.UserId != 0 {
-		query = query.Set("user_id", request.UserId)
-	}
+		query := tx.
+			Update("requests")
 
-	if request.Type != 0 {
-		query = query.Set("type", request.Type)
-	}
+		if request.UserId != 0 {
+			query = query.Set("user_id", request.UserId)
+		}
+
+		if request.Type != 0 {
+			query = query.Set("type", request.Type)
+		}
+
+		if request.Text != "" {
+			query = query.Set("text", request.Text)
+		}
+
+		query = query.Where(accessible(ctx, request.Id)).
+			Suffix("RETURNING id, user_id, type, text")
 
-	if request.Text != "" {
-		query = query.Set("text", request.Text)
+		after, err := scanRequest(query.QueryRowContext(ctx))
+		if err != nil {
+			return err
+		}
+
+		return addToOutbox(ctx, tx, producer.UpdateEvent, producer.Snapshot{Before: before, After: after})
+	})
+}
+
+// accessible returns a condition selecting a request by `id` if the caller is allowed to access it
+func accessible(ctx context.Context, id uint64) sq.Eq {
+	condition := sq.Eq{"id": id}
+	if userId, restricted := authz.RestrictedTo(ctx); restricted {
+		condition["user_id"] = userId
 	}
+	return condition
+}
 
-	query = query.Where("id = ?", request.Id)
-	ret, err := query.ExecContext(ctx)
+// scanRequest reads a single Request from a query result. Returns NotFound if the result is empty.
+func scanRequest(row sq.RowScanner) (*models.Request, error) {
+	req := models.Request{}
+	err := row.Scan(&req.Id, &req.UserId, &req.Type, &req.Text)
+	if errors.Is(err, stdsql.ErrNoRows) {
+		return nil, NotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return &req, nil
+}
 
+// inTx runs `fn` within a transaction. The transaction is committed if `fn` succeeds and rolled back otherwise.
+func (r *repo) inTx(ctx context.Context, fn func(tx sq.StatementBuilderType) error) error {
+	tx, err := r.db.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
 
-	rowsUpdated, err := ret.RowsAffected()
-	if err != nil {
+	if err := fn(sq.StatementBuilder.PlaceholderFormat(sq.Dollar).RunWith(tx)); err != nil {
+		tx.Rollback()
 		return err
-	} else if rowsUpdated == 0 {
-		return NotFound
 	}
-	return nil
+	return tx.Commit()
+}
+
+// addToOutbox stores events of a given type into the outbox table, an event per snapshot
+func addToOutbox(ctx context.Context, tx sq.StatementBuilderType, eventType producer.EventType, snapshots ...producer.Snapshot) error {
+	if len(snapshots) == 0 {
+		return nil
+	}
+
+	query := tx.Insert("outbox").
+		Columns("request_id", "payload", "trace_context")
+
+	for _, snapshot := range snapshots {
+		changed := snapshot.After
+		if changed == nil {
+			changed = snapshot.Before
+		}
+
+		event := producer.NewEvent(ctx, changed.Id, eventType, snapshot, nil)
+		payload, err := event.Encode()
+		if err != nil {
+			return err
+		}
+
+		// relay sends trace context in message headers, keep it aside of the payload
+		var traceContext interface{}
+		if len(event.TraceContext()) > 0 {
+			data, err := json.Marshal(event.TraceContext())
+			if err != nil {
+				return err
+			}
+			traceContext = string(data)
+		}
+		query = query.Values(changed.Id, payload, traceContext)
+	}
+
+	_, err := query.ExecContext(ctx)
+	return err
 }
@@ -10,9 +10,70 @@ import (
 	"github.com/golang/mock/gomock"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"github.com/ozoncp/ocp-request-api/internal/idempotency"
+	"github.com/ozoncp/ocp-request-api/internal/identity"
 	"github.com/ozoncp/ocp-request-api/internal/models"
+	desc "github.com/ozoncp/ocp-request-api/pkg/ocp-request-api"
+	"google.golang.org/protobuf/proto"
 )
 
+// eventPayload matches encoded RequestAPIEvent of a given type with given Request snapshots
+type eventPayload struct {
+	event  desc.RequestAPIEvent_EventType
+	before *models.Request
+	after  *models.Request
+}
+
+func (e eventPayload) Match(v driver.Value) bool {
+	payload, ok := v.([]byte)
+	if !ok {
+		return false
+	}
+	event := &desc.RequestAPIEvent{}
+	if err := proto.Unmarshal(payload, event); err != nil {
+		return false
+	}
+	return event.Event == e.event &&
+		event.EventId != "" &&
+		sameRequest(event.Before, e.before) &&
+		sameRequest(event.After, e.after)
+}
+
+func sameRequest(actual *desc.Request, expected *models.Request) bool {
+	if actual == nil || expected == nil {
+		return actual == nil && expected == nil
+	}
+	return actual.Id == expected.Id &&
+		actual.UserId == expected.UserId &&
+		actual.Type == expected.Type &&
+		actual.Text == expected.Text
+}
+
+// fakeKeys is an idempotency.Store knowing keys of `stored` requests, it runs no queries
+type fakeKeys struct {
+	stored    map[idempotency.Key][]uint64
+	completed map[idempotency.Key][]uint64
+}
+
+func (k *fakeKeys) Get(_ context.Context, key idempotency.Key) ([]uint64, bool, error) {
+	ids, ok := k.stored[key]
+	return ids, ok, nil
+}
+
+func (k *fakeKeys) Reserve(_ context.Context, _ sq.StatementBuilderType, key idempotency.Key) ([]uint64, bool, error) {
+	ids, ok := k.stored[key]
+	return ids, !ok, nil
+}
+
+func (k *fakeKeys) Complete(_ context.Context, _ sq.StatementBuilderType, key idempotency.Key, ids []uint64) error {
+	k.completed[key] = ids
+	return nil
+}
+
+func (k *fakeKeys) Purge(context.Context) (int64, error) {
+	return 0, nil
+}
+
 var _ = Describe("Repo", func() {
 
 	var (
@@ -21,11 +82,16 @@ var _ = Describe("Repo", func() {
 		mockCtrl *gomock.Controller
 		ctx      context.Context
 		db       *sql.DB
+		keys     *fakeKeys
 	)
 
 	BeforeEach(func() {
 		mockCtrl = gomock.NewController(GinkgoT())
 		ctx = context.Background()
+		keys = &fakeKeys{
+			stored:    map[idempotency.Key][]uint64{},
+			completed: map[idempotency.Key][]uint64{},
+		}
 	})
 
 	AfterEach(func() {
@@ -45,7 +111,9 @@ var _ = Describe("Repo", func() {
 			stmtCache := sq.NewStmtCache(db)
 
 			rep = &repo{
+				db:         db,
 				stmBuilder: sq.StatementBuilder.PlaceholderFormat(sq.Dollar).RunWith(stmtCache),
+				keys:       keys,
 			}
 
 		})
@@ -59,12 +127,20 @@ var _ = Describe("Repo", func() {
 			}
 			expectedNewId := uint64(1)
 			returnRows := sqlmock.NewRows([]string{"id"}).AddRow(expectedNewId)
-			dbMock.ExpectPrepare(
+			dbMock.ExpectBegin()
+			dbMock.ExpectQuery(
 				"INSERT INTO requests \\(user_id,type,text\\) VALUES \\(\\$1,\\$2,\\$3\\) RETURNING id",
 			).
-				ExpectQuery().
 				WithArgs(newReq.UserId, newReq.Type, newReq.Text).
 				WillReturnRows(returnRows)
+			dbMock.ExpectExec(
+				"INSERT INTO outbox \\(request_id,payload,trace_context\\) VALUES \\(\\$1,\\$2,\\$3\\)",
+			).
+				WithArgs(expectedNewId, eventPayload{desc.RequestAPIEvent_CREATE, nil, &models.Request{
+					Id: expectedNewId, UserId: newReq.UserId, Type: newReq.Type, Text: newReq.Text,
+				}}, nil).
+				WillReturnResult(sqlmock.NewResult(1, 1))
+			dbMock.ExpectCommit()
 
 			newId, err := rep.Add(ctx, newReq)
 			Expect(err).ToNot(HaveOccurred())
@@ -97,15 +173,25 @@ var _ = Describe("Repo", func() {
 			}
 			expctedNewIds := []uint64{1, 2, 3}
 
-			dbMock.ExpectPrepare(
+			dbMock.ExpectBegin()
+			dbMock.ExpectQuery(
 				"INSERT INTO requests \\(user_id,type,text\\) VALUES \\(\\$1,\\$2,\\$3\\),\\(\\$4,\\$5,\\$6\\),\\(\\$7,\\$8,\\$9\\) RETURNING id",
 			).
-				ExpectQuery().
 				WithArgs(expectedQueryArgs...).
 				WillReturnRows(sqlmock.NewRows([]string{"id"}).
 					AddRow(1).
 					AddRow(2).
 					AddRow(3))
+			dbMock.ExpectExec(
+				"INSERT INTO outbox \\(request_id,payload,trace_context\\) VALUES \\(\\$1,\\$2,\\$3\\),\\(\\$4,\\$5,\\$6\\),\\(\\$7,\\$8,\\$9\\)",
+			).
+				WithArgs(
+					uint64(1), sqlmock.AnyArg(), nil,
+					uint64(2), sqlmock.AnyArg(), nil,
+					uint64(3), sqlmock.AnyArg(), nil,
+				).
+				WillReturnResult(sqlmock.NewResult(3, 3))
+			dbMock.ExpectCommit()
 
 			newIds, err := rep.AddMany(ctx, requests)
 			Expect(err).ToNot(HaveOccurred())
@@ -113,6 +199,53 @@ var _ = Describe("Repo", func() {
 
 		})
 
+		It("Add requests once with a reserved idempotency key", func() {
+			key := idempotency.Key{Method: "MultiCreateRequestV1", Caller: "sub:alice", Value: "abc"}
+
+			// requests are inserted by batches within a single transaction
+			dbMock.ExpectBegin()
+			dbMock.ExpectQuery(
+				"INSERT INTO requests \\(user_id,type,text\\) VALUES \\(\\$1,\\$2,\\$3\\),\\(\\$4,\\$5,\\$6\\) RETURNING id",
+			).
+				WithArgs(uint64(10), uint64(100), "one", uint64(20), uint64(200), "two").
+				WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(5).AddRow(6))
+			dbMock.ExpectExec("INSERT INTO outbox").
+				WillReturnResult(sqlmock.NewResult(2, 2))
+			dbMock.ExpectQuery(
+				"INSERT INTO requests \\(user_id,type,text\\) VALUES \\(\\$1,\\$2,\\$3\\) RETURNING id",
+			).
+				WithArgs(uint64(30), uint64(300), "three").
+				WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+			dbMock.ExpectExec("INSERT INTO outbox").
+				WillReturnResult(sqlmock.NewResult(1, 1))
+			dbMock.ExpectCommit()
+
+			ids, created, err := rep.AddOnce(ctx, key, []models.Request{
+				models.NewRequest(0, 10, 100, "one"),
+				models.NewRequest(0, 20, 200, "two"),
+				models.NewRequest(0, 30, 300, "three"),
+			}, 2)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(created).To(BeTrue())
+			Expect(ids).To(Equal([]uint64{5, 6, 7}))
+			Expect(keys.completed).To(Equal(map[idempotency.Key][]uint64{key: {5, 6, 7}}))
+		})
+
+		It("Return requests added with the same idempotency key earlier", func() {
+			key := idempotency.Key{Method: "MultiCreateRequestV1", Caller: "sub:alice", Value: "abc"}
+			keys.stored[key] = []uint64{3, 4}
+
+			// nothing is inserted, the reservation is all the transaction does
+			dbMock.ExpectBegin()
+			dbMock.ExpectCommit()
+
+			ids, created, err := rep.AddOnce(ctx, key, []models.Request{models.NewRequest(0, 10, 100, "one")}, 2)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(created).To(BeFalse())
+			Expect(ids).To(Equal([]uint64{3, 4}))
+			Expect(keys.completed).To(BeEmpty())
+		})
+
 		It("Fetch requests from database", func() {
 			dbRows := [][]driver.Value{
 				{uint64(1), uint64(10), uint64(100), "one"},
@@ -145,16 +278,44 @@ var _ = Describe("Repo", func() {
 			Expect(actualRequests).To(Equal(expectedRequests))
 		})
 
+		It("Fetch requests page by page", func() {
+			returnRows := sqlmock.NewRows([]string{"id", "user_id", "type", "text"}).
+				AddRow(uint64(11), uint64(5), uint64(100), "one").
+				AddRow(uint64(12), uint64(5), uint64(200), "two")
+
+			dbMock.ExpectPrepare(
+				"SELECT id, user_id, type, text FROM requests "+
+					"WHERE \\(id > \\$1 AND id >= \\$2 AND id <= \\$3 AND user_id = \\$4\\) ORDER BY id LIMIT 2",
+			).
+				ExpectQuery().
+				WithArgs(uint64(10), uint64(1), uint64(100), uint64(5)).
+				WillReturnRows(returnRows)
+
+			actualRequests, err := rep.ListAfter(ctx, 10, Filter{FromId: 1, ToId: 100, UserId: 5}, 2)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(actualRequests).To(Equal([]models.Request{
+				models.NewRequest(11, 5, 100, "one"),
+				models.NewRequest(12, 5, 200, "two"),
+			}))
+		})
+
 		It("Remove request that is exists", func() {
 			reqId := uint64(100)
-			res := sqlmock.NewResult(0, 1)
+			removed := models.NewRequest(reqId, 10, 100, "one")
 
-			dbMock.ExpectPrepare(
-				"DELETE FROM requests WHERE id = \\$1",
+			dbMock.ExpectBegin()
+			dbMock.ExpectQuery(
+				"DELETE FROM requests WHERE id = \\$1 RETURNING id, user_id, type, text",
 			).
-				ExpectExec().
 				WithArgs(reqId).
-				WillReturnResult(res)
+				WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "type", "text"}).
+					AddRow(removed.Id, removed.UserId, removed.Type, removed.Text))
+			dbMock.ExpectExec(
+				"INSERT INTO outbox \\(request_id,payload,trace_context\\) VALUES \\(\\$1,\\$2,\\$3\\)",
+			).
+				WithArgs(reqId, eventPayload{desc.RequestAPIEvent_DELETE, &removed, nil}, nil).
+				WillReturnResult(sqlmock.NewResult(1, 1))
+			dbMock.ExpectCommit()
 
 			err := rep.Remove(ctx, reqId)
 			Expect(err).ToNot(HaveOccurred())
@@ -162,14 +323,14 @@ var _ = Describe("Repo", func() {
 
 		It("Remove request that is not exists", func() {
 			reqId := uint64(100)
-			res := sqlmock.NewResult(0, 0)
 
-			dbMock.ExpectPrepare(
-				"DELETE FROM requests WHERE id = \\$1",
+			dbMock.ExpectBegin()
+			dbMock.ExpectQuery(
+				"DELETE FROM requests WHERE id = \\$1 RETURNING id, user_id, type, text",
 			).
-				ExpectExec().
 				WithArgs(reqId).
-				WillReturnResult(res)
+				WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "type", "text"}))
+			dbMock.ExpectRollback()
 
 			err := rep.Remove(ctx, reqId)
 			Expect(err).To(Equal(NotFound))
@@ -235,12 +396,13 @@ var _ = Describe("Repo", func() {
 				Text:   "one",
 			}
 			expectedError := errors.New("test")
-			dbMock.ExpectPrepare(
+			dbMock.ExpectBegin()
+			dbMock.ExpectQuery(
 				"INSERT INTO requests \\(user_id,type,text\\) VALUES \\(\\$1,\\$2,\\$3\\) RETURNING id",
 			).
-				ExpectQuery().
 				WithArgs(newReq.UserId, newReq.Type, newReq.Text).
 				WillReturnError(expectedError)
+			dbMock.ExpectRollback()
 
 			newId, err := rep.Add(ctx, newReq)
 			Expect(err).To(Equal(expectedError))
@@ -257,12 +419,13 @@ var _ = Describe("Repo", func() {
 				Text:   "one",
 			}
 			expectedError := errors.New("test")
-			dbMock.ExpectPrepare(
+			dbMock.ExpectBegin()
+			dbMock.ExpectQuery(
 				"INSERT INTO requests \\(user_id,type,text\\) VALUES \\(\\$1,\\$2,\\$3\\) RETURNING id",
 			).
-				ExpectQuery().
 				WithArgs(newReq.UserId, newReq.Type, newReq.Text).
 				WillReturnError(expectedError)
+			dbMock.ExpectRollback()
 
 			_, err := rep.AddMany(ctx, []models.Request{newReq})
 			Expect(err).To(Equal(expectedError))
@@ -273,12 +436,13 @@ var _ = Describe("Repo", func() {
 
 			reqId := uint64(100)
 			expectedError := errors.New("test")
-			dbMock.ExpectPrepare(
-				"DELETE FROM requests WHERE id = \\$1",
+			dbMock.ExpectBegin()
+			dbMock.ExpectQuery(
+				"DELETE FROM requests WHERE id = \\$1 RETURNING id, user_id, type, text",
 			).
-				ExpectExec().
 				WithArgs(reqId).
 				WillReturnError(expectedError)
+			dbMock.ExpectRollback()
 
 			err := rep.Remove(ctx, reqId)
 			Expect(err).To(Equal(expectedError))
@@ -286,34 +450,83 @@ var _ = Describe("Repo", func() {
 
 		It("Update request that is exists", func() {
 			req := models.NewRequest(1, 10, 100, "one")
-			res := sqlmock.NewResult(0, 1)
+			before := models.NewRequest(1, 20, 200, "two")
 
-			dbMock.ExpectPrepare(
-				"UPDATE requests SET user_id = \\$1, type = \\$2, text = \\$3 WHERE id = \\$4",
+			dbMock.ExpectBegin()
+			dbMock.ExpectQuery(
+				"SELECT id, user_id, type, text FROM requests WHERE id = \\$1 FOR UPDATE",
+			).
+				WithArgs(req.Id).
+				WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "type", "text"}).
+					AddRow(before.Id, before.UserId, before.Type, before.Text))
+			dbMock.ExpectQuery(
+				"UPDATE requests SET user_id = \\$1, type = \\$2, text = \\$3 WHERE id = \\$4 RETURNING id, user_id, type, text",
 			).
-				ExpectExec().
 				WithArgs(req.UserId, req.Type, req.Text, req.Id).
-				WillReturnResult(res)
+				WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "type", "text"}).
+					AddRow(req.Id, req.UserId, req.Type, req.Text))
+			dbMock.ExpectExec(
+				"INSERT INTO outbox \\(request_id,payload,trace_context\\) VALUES \\(\\$1,\\$2,\\$3\\)",
+			).
+				WithArgs(req.Id, eventPayload{desc.RequestAPIEvent_UPDATE, &before, &req}, nil).
+				WillReturnResult(sqlmock.NewResult(1, 1))
+			dbMock.ExpectCommit()
 
 			err := rep.Update(ctx, req)
-			Expect(err).ToNot(Equal(NotFound))
+			Expect(err).ToNot(HaveOccurred())
 		})
 
 		It("Update request that is not exists", func() {
 			req := models.NewRequest(1, 10, 100, "one")
-			res := sqlmock.NewResult(0, 0)
 
-			dbMock.ExpectPrepare(
-				"UPDATE requests SET user_id = \\$1, type = \\$2, text = \\$3 WHERE id = \\$4",
+			dbMock.ExpectBegin()
+			dbMock.ExpectQuery(
+				"SELECT id, user_id, type, text FROM requests WHERE id = \\$1 FOR UPDATE",
 			).
-				ExpectExec().
-				WithArgs(req.UserId, req.Type, req.Text, req.Id).
-				WillReturnResult(res)
+				WithArgs(req.Id).
+				WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "type", "text"}))
+			dbMock.ExpectRollback()
 
 			err := rep.Update(ctx, req)
 			Expect(err).To(Equal(NotFound))
 		})
 
+		It("Limit students to own requests", func() {
+			ctx = identity.NewContext(ctx, identity.Identity{Subject: "s", Role: identity.Student, UserId: 10})
+
+			dbMock.ExpectPrepare(
+				"SELECT id, user_id, type, text FROM requests WHERE user_id = \\$1 LIMIT 5 OFFSET 0",
+			).
+				ExpectQuery().
+				WithArgs(uint64(10)).
+				WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "type", "text"}))
+			_, err := rep.List(ctx, 5, 0)
+			Expect(err).ToNot(HaveOccurred())
+
+			dbMock.ExpectPrepare(
+				"SELECT id, user_id, type, text FROM requests WHERE id = \\$1 AND user_id = \\$2",
+			).
+				ExpectQuery().
+				WithArgs(uint64(1), uint64(10)).
+				WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "type", "text"}))
+			_, err = rep.Describe(ctx, 1)
+			Expect(err).To(Equal(NotFound))
+		})
+
+		It("Do not limit operators", func() {
+			ctx = identity.NewContext(ctx, identity.Identity{Subject: "o", Role: identity.Operator})
+
+			dbMock.ExpectPrepare(
+				"SELECT id, user_id, type, text FROM requests WHERE id = \\$1",
+			).
+				ExpectQuery().
+				WithArgs(uint64(1)).
+				WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "type", "text"}).AddRow(1, 20, 200, "two"))
+			actualReq, err := rep.Describe(ctx, 1)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(actualReq.UserId).To(Equal(uint64(20)))
+		})
+
 	})
 
 })
@@ -3,9 +3,11 @@ package saver
 import (
 	"context"
 	"github.com/ozoncp/ocp-request-api/internal/flusher"
+	"github.com/ozoncp/ocp-request-api/internal/metrics"
 	"github.com/ozoncp/ocp-request-api/internal/models"
 	"log"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -14,50 +16,91 @@ const (
 	closed = 0b10
 )
 
+// maxFlushAttempts limits how many times a Request is passed to the flusher before it is dropped
+const maxFlushAttempts = 3
+
 // Saver instance saves Request into underlying storage.
 // User must call Init() before using an instance.
 // And Close() to ensure all pending item are stored.
 // Closed instance cannot be used.
+// All methods are safe for concurrent use.
 type Saver interface {
 	Save(entity models.Request)
 	Init()
-	Close()
+	Close(ctx context.Context) error
+	// Backlog returns a number of requests waiting to be flushed
+	Backlog() int
 }
 
 // NewSaver creates a new Saver instance.
 // It asynchronously collects save Requests into internally slice with given `capacity`.
 // It flushes Requests into underlying `flusher` with `flushEvery` periodicity.
-func NewSaver(capacity uint, flusher flusher.Flusher, flushEvery time.Duration) Saver {
+// Requests failed to flush are retried with the next flushes, up to maxFlushAttempts in total,
+// then they are logged and dropped. Requests failed to flush on Close are dropped at once.
+func NewSaver(
+	capacity uint,
+	flusher flusher.Flusher,
+	flushEvery time.Duration,
+	metricsReporter metrics.FlushMetricsReporter,
+) Saver {
+	flushCtx, cancelFlush := context.WithCancel(context.Background())
 	s := &saver{
-		capacity:   capacity,
-		flusher:    flusher,
-		flushQueue: make(chan models.Request, capacity),
-		wait:       &sync.WaitGroup{},
-		flushEvery: flushEvery,
+		capacity:    capacity,
+		flusher:     flusher,
+		flushQueue:  make(chan models.Request, capacity),
+		closing:     make(chan struct{}),
+		wait:        &sync.WaitGroup{},
+		flushEvery:  flushEvery,
+		metrics:     metricsReporter,
+		flushCtx:    flushCtx,
+		cancelFlush: cancelFlush,
 	}
 	s.Init()
 	return s
 }
 
+// pendingRequest is a Request waiting to be flushed with a number of failed attempts to flush it
+type pendingRequest struct {
+	request  models.Request
+	attempts int
+}
+
 type saver struct {
 	capacity   uint
 	flusher    flusher.Flusher
 	flushQueue chan models.Request
+	closing    chan struct{} // closed once Close is called, releases saves waiting for a full queue
+	closeOnce  sync.Once
 	wait       *sync.WaitGroup
-	state      int8 // to check if it's closed or inited
+	stateLock  sync.RWMutex // guards state and flushQueue closing
+	state      int8         // to check if it's closed or inited
 	flushEvery time.Duration
+	metrics    metrics.FlushMetricsReporter
+	backlog    int64 // accessed atomically
+	// flushCtx is cancelled when Close gives up waiting, so that a hung storage does not block the flush forever
+	flushCtx    context.Context
+	cancelFlush context.CancelFunc
 }
 
-// Save Request into underlying storage
+// Save Request into underlying storage. Panics if the Saver is closed, including while Save waits for a full queue.
 func (s *saver) Save(request models.Request) {
+	s.stateLock.RLock()
+	defer s.stateLock.RUnlock()
+
 	s.mustNotBeClosed()
 	s.mustBeInitialized()
-
-	s.flushQueue <- request
+	select {
+	case s.flushQueue <- request:
+	case <-s.closing:
+		panic("Saver instance is closed")
+	}
 }
 
 // Init initiates saver so it's ready to Save Requests
 func (s *saver) Init() {
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+
 	s.mustNotBeClosed()
 	if s.isInited() {
 		return
@@ -65,48 +108,136 @@ func (s *saver) Init() {
 
 	ticker := time.NewTicker(s.flushEvery)
 	s.wait.Add(1)
-
 	go func() {
-		requests := make([]models.Request, 0, s.capacity)
+		requests := make([]pendingRequest, 0, s.capacity)
 		defer s.wait.Done()
+		defer ticker.Stop()
+
 		for {
 			select {
 			case req, ok := <-s.flushQueue:
 				if !ok {
-					s.flush(requests)
-					requests = requests[:0]
+					if failed := s.flush(requests); len(failed) > 0 {
+						log.Printf("dropped %v requests failed to flush on close", len(failed))
+					}
+					s.setBacklog(0)
 					return
 				} else {
-					requests = append(requests, req)
+					requests = append(requests, pendingRequest{request: req})
 				}
 			case <-ticker.C:
-				s.flush(requests)
-				requests = requests[:0]
+				requests = append(requests[:0], s.retries(s.flush(requests))...)
 			}
+			s.setBacklog(len(requests) + len(s.flushQueue))
 		}
-
 	}()
 	s.state |= inited
 }
 
 // Close ensures all Requests object are processed. Closed Saver does not accept new saves.
-func (s *saver) Close() {
+// It waits for pending Requests to be flushed until `ctx` is done and returns ctx.Err() in that case.
+// A flush in progress is cancelled then.
+func (s *saver) Close(ctx context.Context) error {
+	s.stateLock.RLock()
 	s.mustBeInitialized()
-	if s.isClosed() {
-		return
+	s.stateLock.RUnlock()
+
+	done := make(chan struct{})
+	go func() {
+		if s.markClosed() {
+			close(s.flushQueue)
+		}
+		s.wait.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		s.cancelFlush()
+		return ctx.Err()
 	}
+}
+
+func (s *saver) Backlog() int {
+	return int(atomic.LoadInt64(&s.backlog))
+}
 
+func (s *saver) setBacklog(backlog int) {
+	atomic.StoreInt64(&s.backlog, int64(backlog))
+	s.metrics.SetQueueDepth(backlog)
+}
+
+// markClosed switches the saver into closed state. Returns false if it's already closed.
+// Save() holds a read lock while writing to the queue, so no writes are in progress once it returns.
+// Saves waiting for a full queue are released first, so that the lock is not held up by them.
+func (s *saver) markClosed() bool {
+	s.closeOnce.Do(func() {
+		close(s.closing)
+	})
+
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+
+	if s.isClosed() {
+		return false
+	}
 	s.state |= closed
-	close(s.flushQueue)
-	s.wait.Wait()
+	return true
+}
+
+// flushes a slice of pending Requests. Returns Requests that are failed to flush with their attempts counted.
+func (s *saver) flush(pending []pendingRequest) []pendingRequest {
+	if len(pending) == 0 {
+		return nil
+	}
+	if err := s.flushCtx.Err(); err != nil {
+		log.Printf("dropped %v requests, Close gave up waiting for them to flush: %v", len(pending), err)
+		return nil
+	}
+	requests := make([]models.Request, 0, len(pending))
+	for _, p := range pending {
+		requests = append(requests, p.request)
+	}
+
+	failedToFlushReq, err := s.flusher.Flush(s.flushCtx, requests)
+	if err == nil {
+		return nil
+	}
+	log.Printf("failed to save %v requests: %v", len(failedToFlushReq), err)
+
+	// the flusher keeps the order of requests, so the failed ones are matched in a single pass
+	failed := make([]pendingRequest, 0, len(failedToFlushReq))
+	ix := 0
+	for _, request := range failedToFlushReq {
+		for ix < len(pending) && pending[ix].request != request {
+			ix++
+		}
+		if ix == len(pending) {
+			break
+		}
+		failed = append(failed, pendingRequest{request: request, attempts: pending[ix].attempts + 1})
+		ix++
+	}
+	return failed
 }
 
-// flushes a slice of Requests
-func (s *saver) flush(requests []models.Request) {
-	ctx := context.Background()
-	if failedToFlushReq, err := s.flusher.Flush(ctx, requests); err != nil {
-		log.Printf("failed to save %v requests: %v", len(failedToFlushReq), err)
+// retries returns failed Requests which are to be flushed again, the rest are dropped
+func (s *saver) retries(failed []pendingRequest) []pendingRequest {
+	retries := failed[:0]
+	for _, p := range failed {
+		if p.attempts < maxFlushAttempts {
+			retries = append(retries, p)
+		}
+	}
+	if dropped := len(failed) - len(retries); dropped > 0 {
+		log.Printf("dropped %v requests failed to flush %v times", dropped, maxFlushAttempts)
+	}
+	if len(retries) > 0 {
+		s.metrics.IncRetried(uint(len(retries)))
 	}
+	return retries
 }
 
 func (s *saver) mustNotBeClosed() {
@@ -2,6 +2,7 @@ package saver
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/golang/mock/gomock"
 	. "github.com/onsi/ginkgo"
@@ -9,6 +10,7 @@ import (
 	"github.com/ozoncp/ocp-request-api/internal/mocks"
 	"github.com/ozoncp/ocp-request-api/internal/models"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -29,6 +31,7 @@ var _ = Describe("Saver", func() {
 	var (
 		sav         Saver
 		mockFlusher *mocks.MockFlusher
+		mockMetrics *mocks.MockFlushMetricsReporter
 		mockCtrl    *gomock.Controller
 		requests    []models.Request
 		ctx         context.Context
@@ -38,6 +41,8 @@ var _ = Describe("Saver", func() {
 		ctx = context.Background()
 		mockCtrl = gomock.NewController(GinkgoT())
 		mockFlusher = mocks.NewMockFlusher(mockCtrl)
+		mockMetrics = mocks.NewMockFlushMetricsReporter(mockCtrl)
+		mockMetrics.EXPECT().SetQueueDepth(gomock.Any()).AnyTimes()
 	})
 
 	AfterEach(func() {
@@ -46,15 +51,15 @@ var _ = Describe("Saver", func() {
 
 	Context("Saver test", func() {
 		JustBeforeEach(func() {
-			sav = NewSaver(10, mockFlusher, time.Second)
+			sav = NewSaver(10, mockFlusher, time.Second, mockMetrics)
 			requests = makeRequests(10)
 		})
 
 		It("All items are saved after Close()", func() {
-			defer sav.Close()
+			defer sav.Close(ctx)
 
 			mockFlusher.EXPECT().
-				Flush(ctx, requests).
+				Flush(gomock.Any(), requests).
 				Return(nil, nil).
 				MaxTimes(1).
 				MinTimes(1)
@@ -66,9 +71,9 @@ var _ = Describe("Saver", func() {
 		})
 
 		It("Saver flushes with a one call because we wrote data quickly", func() {
-			defer sav.Close()
+			defer sav.Close(ctx)
 			mockFlusher.EXPECT().
-				Flush(ctx, requests).
+				Flush(gomock.Any(), requests).
 				Return(nil, nil).
 				MaxTimes(1).
 				MinTimes(1)
@@ -83,22 +88,22 @@ var _ = Describe("Saver", func() {
 		})
 
 		It("Saver flushes with a two calls because of a long pause between saves", func() {
-			defer sav.Close()
+			defer sav.Close(ctx)
 			mockFlusher.EXPECT().
-				Flush(ctx, requests[:5]).
+				Flush(gomock.Any(), requests[:5]).
 				Return(nil, nil).
 				MaxTimes(1).
 				MinTimes(1)
 
 			mockFlusher.EXPECT().
-				Flush(ctx, requests[5:]).
+				Flush(gomock.Any(), requests[5:]).
 				Return(nil, nil).
 				MaxTimes(1).
 				MinTimes(1)
 
 			// we may pass an empty requests while sleeping
 			mockFlusher.EXPECT().
-				Flush(ctx, []models.Request{}).
+				Flush(gomock.Any(), []models.Request{}).
 				Return(nil, nil).
 				MinTimes(0).
 				MaxTimes(3)
@@ -118,12 +123,17 @@ var _ = Describe("Saver", func() {
 
 	Context("Saver state assertions test", func() {
 		JustBeforeEach(func() {
+			flushCtx, cancelFlush := context.WithCancel(ctx)
 			sav = &saver{
-				capacity:   10,
-				flusher:    mockFlusher,
-				flushQueue: make(chan models.Request, 1),
-				wait:       &sync.WaitGroup{},
-				flushEvery: time.Second,
+				capacity:    10,
+				flusher:     mockFlusher,
+				flushQueue:  make(chan models.Request, 1),
+				closing:     make(chan struct{}),
+				wait:        &sync.WaitGroup{},
+				flushEvery:  time.Second,
+				metrics:     mockMetrics,
+				flushCtx:    flushCtx,
+				cancelFlush: cancelFlush,
 			}
 		})
 
@@ -136,12 +146,12 @@ var _ = Describe("Saver", func() {
 
 		It("Cannot Save() after Close()", func() {
 			mockFlusher.EXPECT().
-				Flush(ctx, []models.Request{}).
+				Flush(gomock.Any(), []models.Request{}).
 				Return(nil, nil).
 				MaxTimes(1)
 
 			sav.Init()
-			sav.Close()
+			Expect(sav.Close(ctx)).To(Succeed())
 			Expect(func() {
 				sav.Save(models.Request{})
 			}).To(PanicWith("Saver instance is closed"))
@@ -149,12 +159,12 @@ var _ = Describe("Saver", func() {
 
 		It("Cannot init() after Close()", func() {
 			mockFlusher.EXPECT().
-				Flush(ctx, []models.Request{}).
+				Flush(gomock.Any(), []models.Request{}).
 				Return(nil, nil).
 				MaxTimes(1)
 
 			sav.Init()
-			sav.Close()
+			Expect(sav.Close(ctx)).To(Succeed())
 			Expect(func() {
 				sav.Init()
 			}).To(PanicWith("Saver instance is closed"))
@@ -162,9 +172,156 @@ var _ = Describe("Saver", func() {
 
 		It("Cannot Close() before init()", func() {
 			Expect(func() {
-				sav.Close()
+				sav.Close(ctx)
 			}).To(PanicWith("Saver instance is not init()-ed"))
 		})
 
 	})
+
+	Context("Saver lifecycle under concurrent use", func() {
+		JustBeforeEach(func() {
+			sav = NewSaver(10, mockFlusher, time.Millisecond, mockMetrics)
+		})
+
+		It("Close() waits for all accepted items while Save() is called concurrently", func() {
+			var flushed int64
+			mockFlusher.EXPECT().
+				Flush(gomock.Any(), gomock.Any()).
+				DoAndReturn(func(_ context.Context, requests []models.Request) ([]models.Request, error) {
+					atomic.AddInt64(&flushed, int64(len(requests)))
+					return nil, nil
+				}).
+				AnyTimes()
+
+			var saved int64
+			wg := sync.WaitGroup{}
+			for i := 0; i < 50; i++ {
+				wg.Add(1)
+				go func() {
+					defer GinkgoRecover()
+					defer wg.Done()
+					defer func() {
+						// saves after Close() are rejected, any other panic is an error
+						if r := recover(); r != nil {
+							Expect(r).To(Equal("Saver instance is closed"))
+						}
+					}()
+					for _, req := range makeRequests(100) {
+						sav.Save(req)
+						atomic.AddInt64(&saved, 1)
+					}
+				}()
+			}
+
+			time.Sleep(time.Millisecond * 5)
+			closeWg := sync.WaitGroup{}
+			for i := 0; i < 5; i++ {
+				closeWg.Add(1)
+				go func() {
+					defer GinkgoRecover()
+					defer closeWg.Done()
+					Expect(sav.Close(ctx)).To(Succeed())
+				}()
+			}
+			closeWg.Wait()
+			wg.Wait()
+
+			Expect(atomic.LoadInt64(&flushed)).To(Equal(atomic.LoadInt64(&saved)))
+		})
+
+		It("Close() honours context deadline and cancels a hung flush", func() {
+			cancelled := make(chan struct{})
+			mockFlusher.EXPECT().
+				Flush(gomock.Any(), gomock.Any()).
+				DoAndReturn(func(flushCtx context.Context, requests []models.Request) ([]models.Request, error) {
+					<-flushCtx.Done()
+					close(cancelled)
+					return requests, flushCtx.Err()
+				}).
+				MinTimes(1).
+				MaxTimes(1)
+			mockMetrics.EXPECT().IncRetried(uint(1)).AnyTimes()
+
+			sav.Save(models.NewRequest(1, 1, 1, "slow"))
+
+			closeCtx, cancel := context.WithTimeout(ctx, time.Millisecond*50)
+			defer cancel()
+			Expect(sav.Close(closeCtx)).To(MatchError(context.DeadlineExceeded))
+			Eventually(cancelled).Should(BeClosed())
+		})
+
+		It("Saver retries failed requests a limited number of times", func() {
+			var lock sync.Mutex
+			attempts := map[uint64]int{}
+			mockFlusher.EXPECT().
+				Flush(gomock.Any(), gomock.Any()).
+				DoAndReturn(func(_ context.Context, requests []models.Request) ([]models.Request, error) {
+					lock.Lock()
+					defer lock.Unlock()
+					for _, req := range requests {
+						attempts[req.Id]++
+					}
+					return requests, errors.New("failed to flush")
+				}).
+				AnyTimes()
+
+			var retried uint64
+			mockMetrics.EXPECT().
+				IncRetried(gomock.Any()).
+				Do(func(v uint) {
+					atomic.AddUint64(&retried, uint64(v))
+				}).
+				AnyTimes()
+
+			for _, req := range makeRequests(10) {
+				sav.Save(req)
+			}
+			Eventually(func() uint64 {
+				return atomic.LoadUint64(&retried)
+			}).Should(Equal(uint64(10 * (maxFlushAttempts - 1))))
+			Eventually(sav.Backlog).Should(BeZero())
+			Expect(sav.Close(ctx)).To(Succeed())
+
+			lock.Lock()
+			defer lock.Unlock()
+			Expect(attempts).To(HaveLen(10))
+			for _, n := range attempts {
+				Expect(n).To(Equal(maxFlushAttempts))
+			}
+		})
+
+		It("Close() releases saves waiting for a full queue", func() {
+			release := make(chan struct{})
+			mockFlusher.EXPECT().
+				Flush(gomock.Any(), gomock.Any()).
+				DoAndReturn(func(_ context.Context, requests []models.Request) ([]models.Request, error) {
+					<-release
+					return nil, nil
+				}).
+				AnyTimes()
+
+			// the first flush hangs, so the queue is filled up and the next save waits
+			sav.Save(models.NewRequest(1, 1, 1, "slow"))
+			time.Sleep(time.Millisecond * 10)
+			for _, req := range makeRequests(10) {
+				sav.Save(req)
+			}
+			waiting := make(chan interface{})
+			go func() {
+				defer func() {
+					waiting <- recover()
+				}()
+				sav.Save(models.NewRequest(11, 1, 1, "waiting"))
+			}()
+			Consistently(waiting, "20ms").ShouldNot(Receive())
+
+			closed := make(chan error)
+			go func() {
+				closed <- sav.Close(ctx)
+			}()
+			Eventually(waiting).Should(Receive(Equal("Saver instance is closed")))
+			close(release)
+			Eventually(closed).Should(Receive(BeNil()))
+		})
+	})
 })
@@ -4,6 +4,7 @@ import (
 	"context"
 	sq "github.com/Masterminds/squirrel"
 	sql "github.com/jmoiron/sqlx"
+	"github.com/ozoncp/ocp-request-api/internal/authz"
 	"github.com/ozoncp/ocp-request-api/internal/models"
 )
 
@@ -25,7 +26,8 @@ type searcher struct {
 	stmBuilder sq.StatementBuilderType
 }
 
-// Search searches for Request by a given `query`. Requests are ordered by a similarity "score"
+// Search searches for Request by a given `query`. Requests are ordered by a similarity "score".
+// Callers restricted to own requests find requests of their user only.
 func (s *searcher) Search(ctx context.Context, query string, limit, offset uint64) ([]models.Request, error) {
 	q := s.stmBuilder.Select("id, user_id, type, text").
 		From("requests").
@@ -33,6 +35,9 @@ func (s *searcher) Search(ctx context.Context, query string, limit, offset uint6
 		OrderByClause("ts_rank(to_tsvector('russian', text), to_tsquery(?)) desc", query).
 		Offset(offset).
 		Limit(limit)
+	if userId, restricted := authz.RestrictedTo(ctx); restricted {
+		q = q.Where(sq.Eq{"user_id": userId})
+	}
 
 	rows, err := q.QueryContext(ctx)
 	if err != nil {
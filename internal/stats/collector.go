@@ -0,0 +1,129 @@
+package stats
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	sq "github.com/Masterminds/squirrel"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/rs/zerolog/log"
+	"strconv"
+	"time"
+)
+
+// Collector periodically counts stored requests by type and exports the numbers as Prometheus gauges.
+// Requests have no status, so they are not counted by it.
+type Collector interface {
+	Run(ctx context.Context)
+}
+
+type txBeginner interface {
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+}
+
+// NewCollector creates a Collector that refreshes the numbers every `refreshEvery`.
+// A counting query taking longer than `statementTimeout` is cancelled by the database, the previous numbers are kept then.
+func NewCollector(db txBeginner, refreshEvery, statementTimeout time.Duration) Collector {
+	return &collector{
+		db:               db,
+		refreshEvery:     refreshEvery,
+		statementTimeout: statementTimeout,
+		requestsGauge: promauto.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "requests_stored",
+			Help: "The number of stored requests by type",
+		}, []string{"type"}),
+	}
+}
+
+type collector struct {
+	db               txBeginner
+	refreshEvery     time.Duration
+	statementTimeout time.Duration
+	requestsGauge    *prometheus.GaugeVec
+	exported         map[uint64]struct{} // types with exported numbers
+}
+
+// Run refreshes the numbers right away and then periodically until `ctx` is done
+func (c *collector) Run(ctx context.Context) {
+	ticker := time.NewTicker(c.refreshEvery)
+	defer ticker.Stop()
+
+	for {
+		if err := c.refresh(ctx); err != nil && ctx.Err() == nil {
+			log.Error().Err(err).Msg("failed to count stored requests")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// refresh replaces exported numbers with the current ones
+func (c *collector) refresh(ctx context.Context) error {
+	counts, err := c.countByType(ctx)
+	if err != nil {
+		return err
+	}
+
+	// gauges are updated in place rather than reset, so a scrape never sees missing numbers
+	for requestType, count := range counts {
+		c.requestsGauge.With(typeLabels(requestType)).Set(float64(count))
+	}
+	for requestType := range c.exported {
+		if _, ok := counts[requestType]; !ok {
+			c.requestsGauge.Delete(typeLabels(requestType))
+		}
+	}
+
+	c.exported = make(map[uint64]struct{}, len(counts))
+	for requestType := range counts {
+		c.exported[requestType] = struct{}{}
+	}
+	return nil
+}
+
+func typeLabels(requestType uint64) prometheus.Labels {
+	return prometheus.Labels{"type": strconv.FormatUint(requestType, 10)}
+}
+
+// countByType returns numbers of stored requests by type.
+// The query runs in a read-only transaction with statement_timeout set, so it can't load the database for long.
+func (c *collector) countByType(ctx context.Context) (map[uint64]uint64, error) {
+	tx, err := c.db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// SET does not accept placeholders
+	timeout := fmt.Sprintf("SET LOCAL statement_timeout = %d", c.statementTimeout.Milliseconds())
+	if _, err := tx.ExecContext(ctx, timeout); err != nil {
+		return nil, err
+	}
+
+	rows, err := sq.Select("type", "count(*)").
+		From("requests").
+		GroupBy("type").
+		RunWith(tx).
+		QueryContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := map[uint64]uint64{}
+	for rows.Next() {
+		var requestType, count uint64
+		if err := rows.Scan(&requestType, &count); err != nil {
+			return nil, err
+		}
+		counts[requestType] = count
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return counts, tx.Commit()
+}
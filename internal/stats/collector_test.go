@@ -0,0 +1,108 @@
+package stats
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/DATA-DOG/go-sqlmock"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"time"
+)
+
+var _ = Describe("Collector", func() {
+
+	var (
+		dbMock sqlmock.Sqlmock
+		db     *sql.DB
+		ctx    context.Context
+	)
+
+	// metrics are registered globally, so the collector is created once
+	col := NewCollector(nil, time.Millisecond, 1500*time.Millisecond).(*collector)
+
+	countQuery := "SELECT type, count\\(\\*\\) FROM requests GROUP BY type"
+
+	stored := func(requestType string) float64 {
+		return testutil.ToFloat64(col.requestsGauge.WithLabelValues(requestType))
+	}
+
+	BeforeEach(func() {
+		var err error
+		ctx = context.Background()
+		db, dbMock, err = sqlmock.New()
+		Expect(err).ToNot(HaveOccurred())
+		col.db = db
+		col.requestsGauge.Reset()
+		col.exported = nil
+	})
+
+	AfterEach(func() {
+		defer db.Close()
+		Expect(dbMock.ExpectationsWereMet()).To(Succeed())
+	})
+
+	It("Exports numbers of requests by type counted with a statement timeout", func() {
+		dbMock.ExpectBegin()
+		dbMock.ExpectExec("SET LOCAL statement_timeout = 1500").WillReturnResult(sqlmock.NewResult(0, 0))
+		dbMock.ExpectQuery(countQuery).
+			WillReturnRows(sqlmock.NewRows([]string{"type", "count"}).AddRow(1, 10).AddRow(2, 3))
+		dbMock.ExpectCommit()
+
+		Expect(col.refresh(ctx)).To(Succeed())
+		Expect(stored("1")).To(Equal(10.0))
+		Expect(stored("2")).To(Equal(3.0))
+	})
+
+	It("Updates numbers in place and drops types that have no requests anymore", func() {
+		for _, rows := range []*sqlmock.Rows{
+			sqlmock.NewRows([]string{"type", "count"}).AddRow(1, 10).AddRow(2, 1),
+			sqlmock.NewRows([]string{"type", "count"}).AddRow(2, 3),
+		} {
+			dbMock.ExpectBegin()
+			dbMock.ExpectExec("SET LOCAL statement_timeout").WillReturnResult(sqlmock.NewResult(0, 0))
+			dbMock.ExpectQuery(countQuery).WillReturnRows(rows)
+			dbMock.ExpectCommit()
+		}
+
+		Expect(col.refresh(ctx)).To(Succeed())
+		Expect(testutil.CollectAndCount(col.requestsGauge)).To(Equal(2))
+		gauge := col.requestsGauge.WithLabelValues("2")
+
+		Expect(col.refresh(ctx)).To(Succeed())
+		Expect(testutil.CollectAndCount(col.requestsGauge)).To(Equal(1))
+		Expect(stored("2")).To(Equal(3.0))
+		Expect(col.requestsGauge.WithLabelValues("2")).To(BeIdenticalTo(gauge))
+	})
+
+	It("Keeps previous numbers if counting fails", func() {
+		col.requestsGauge.WithLabelValues("1").Set(10)
+		dbMock.ExpectBegin()
+		dbMock.ExpectExec("SET LOCAL statement_timeout").WillReturnResult(sqlmock.NewResult(0, 0))
+		dbMock.ExpectQuery(countQuery).WillReturnError(errors.New("canceling statement due to statement timeout"))
+		dbMock.ExpectRollback()
+
+		Expect(col.refresh(ctx)).ToNot(Succeed())
+		Expect(stored("1")).To(Equal(10.0))
+	})
+
+	It("Refreshes numbers periodically until stopped", func() {
+		for i := 0; i < 2; i++ {
+			dbMock.ExpectBegin()
+			dbMock.ExpectExec("SET LOCAL statement_timeout").WillReturnResult(sqlmock.NewResult(0, 0))
+			dbMock.ExpectQuery(countQuery).WillReturnRows(sqlmock.NewRows([]string{"type", "count"}).AddRow(1, i))
+			dbMock.ExpectCommit()
+		}
+
+		runCtx, stop := context.WithCancel(ctx)
+		stopped := make(chan struct{})
+		go func() {
+			defer close(stopped)
+			col.Run(runCtx)
+		}()
+		Eventually(func() float64 { return stored("1") }).Should(Equal(1.0))
+		stop()
+		Eventually(stopped).Should(BeClosed())
+	})
+})
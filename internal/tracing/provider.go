@@ -0,0 +1,80 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.10.0"
+	"os"
+)
+
+// InstrumentationName names tracers of the service
+const InstrumentationName = "github.com/ozoncp/ocp-request-api"
+
+// Exporter defines where finished spans are sent
+type Exporter string
+
+const (
+	// ExporterOTLP sends spans to an OpenTelemetry collector (or Jaeger) over OTLP/gRPC
+	ExporterOTLP Exporter = "otlp"
+	// ExporterStdout writes spans to stdout as JSON lines
+	ExporterStdout Exporter = "stdout"
+	// ExporterNone drops spans, trace context is still propagated
+	ExporterNone Exporter = "none"
+)
+
+// ParseExporter parses an exporter name
+func ParseExporter(name string) (Exporter, error) {
+	switch exporter := Exporter(name); exporter {
+	case ExporterOTLP, ExporterStdout, ExporterNone:
+		return exporter, nil
+	default:
+		return "", fmt.Errorf("unknown tracing exporter %q, expected otlp, stdout or none", name)
+	}
+}
+
+// Config is a configuration of a tracer provider
+type Config struct {
+	ServiceName  string
+	Exporter     Exporter
+	OTLPEndpoint string // host:port of OTLP/gRPC receiver
+	OTLPInsecure bool   // disables TLS of OTLP connection
+	SampleRatio  float64
+}
+
+// NewProvider returns a tracer provider sampling `cfg.SampleRatio` of new traces and sending spans to `cfg.Exporter`.
+// Traces started by clients are sampled the way clients decided. Shutdown the provider to flush pending spans.
+func NewProvider(ctx context.Context, cfg Config) (*sdktrace.TracerProvider, error) {
+	opts := []sdktrace.TracerProviderOption{
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+		sdktrace.WithResource(resource.NewWithAttributes(
+			semconv.SchemaURL, semconv.ServiceNameKey.String(cfg.ServiceName),
+		)),
+	}
+
+	switch cfg.Exporter {
+	case ExporterOTLP:
+		clientOpts := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(cfg.OTLPEndpoint)}
+		if cfg.OTLPInsecure {
+			clientOpts = append(clientOpts, otlptracegrpc.WithInsecure())
+		}
+		exporter, err := otlptracegrpc.New(ctx, clientOpts...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to start OTLP exporter: %w", err)
+		}
+		opts = append(opts, sdktrace.WithBatcher(exporter))
+	case ExporterStdout:
+		exporter, err := stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+		if err != nil {
+			return nil, fmt.Errorf("failed to start stdout exporter: %w", err)
+		}
+		opts = append(opts, sdktrace.WithBatcher(exporter))
+	case ExporterNone:
+	default:
+		return nil, fmt.Errorf("unknown tracing exporter %q", cfg.Exporter)
+	}
+	return sdktrace.NewTracerProvider(opts...), nil
+}
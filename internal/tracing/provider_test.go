@@ -0,0 +1,58 @@
+package tracing_test
+
+import (
+	"context"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/ozoncp/ocp-request-api/internal/tracing"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+var _ = Describe("Provider", func() {
+
+	newProvider := func(exporter tracing.Exporter, ratio float64) *sdktrace.TracerProvider {
+		provider, err := tracing.NewProvider(context.Background(), tracing.Config{
+			ServiceName: "test",
+			Exporter:    exporter,
+			SampleRatio: ratio,
+		})
+		Expect(err).ToNot(HaveOccurred())
+		return provider
+	}
+
+	It("Samples a configured ratio of new traces", func() {
+		_, sampled := newProvider(tracing.ExporterNone, 1).Tracer("test").Start(context.Background(), "span")
+		Expect(sampled.SpanContext().IsSampled()).To(BeTrue())
+
+		_, dropped := newProvider(tracing.ExporterNone, 0).Tracer("test").Start(context.Background(), "span")
+		Expect(dropped.SpanContext().IsSampled()).To(BeFalse())
+		Expect(dropped.SpanContext().IsValid()).To(BeTrue())
+	})
+
+	It("Follows sampling decision of the parent", func() {
+		ctx, parent := newProvider(tracing.ExporterNone, 1).Tracer("test").Start(context.Background(), "parent")
+		_, child := newProvider(tracing.ExporterNone, 0).Tracer("test").Start(ctx, "child")
+		Expect(child.SpanContext().IsSampled()).To(BeTrue())
+		Expect(child.SpanContext().TraceID()).To(Equal(parent.SpanContext().TraceID()))
+	})
+
+	It("Writes spans to stdout", func() {
+		provider := newProvider(tracing.ExporterStdout, 1)
+		_, span := provider.Tracer("test").Start(context.Background(), "span")
+		span.End()
+		Expect(provider.Shutdown(context.Background())).To(Succeed())
+	})
+
+	It("Parses exporter names", func() {
+		for _, name := range []string{"otlp", "stdout", "none"} {
+			exporter, err := tracing.ParseExporter(name)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(exporter).To(Equal(tracing.Exporter(name)))
+		}
+		_, err := tracing.ParseExporter("jaeger")
+		Expect(err).To(HaveOccurred())
+
+		_, err = tracing.NewProvider(context.Background(), tracing.Config{Exporter: "jaeger"})
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,83 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// TraceParentHeader is a W3C Trace Context header name (https://www.w3.org/TR/trace-context/)
+const TraceParentHeader = "traceparent"
+
+// UberTraceIdHeader is a header jaeger clients pass their trace context with
+const UberTraceIdHeader = "uber-trace-id"
+
+// PropagationHeaders are HTTP headers a caller may pass its trace context with:
+// W3C traceparent and uber-trace-id of jaeger clients
+var PropagationHeaders = []string{TraceParentHeader, UberTraceIdHeader}
+
+// NewPropagator returns a propagator reading trace context from both traceparent and uber-trace-id,
+// traceparent wins if both are present. Only traceparent is written, so Kafka events can be read by any tool.
+func NewPropagator() propagation.TextMapPropagator {
+	return propagation.NewCompositeTextMapPropagator(JaegerPropagator{}, propagation.TraceContext{})
+}
+
+// JaegerPropagator extracts span contexts from `uber-trace-id` header of jaeger clients
+// (https://www.jaegertracing.io/docs/1.21/client-libraries/#propagation-format). It never injects one.
+type JaegerPropagator struct{}
+
+// Inject implements propagation.TextMapPropagator. Trace context is written by propagation.TraceContext only.
+func (p JaegerPropagator) Inject(context.Context, propagation.TextMapCarrier) {}
+
+// Extract implements propagation.TextMapPropagator
+func (p JaegerPropagator) Extract(ctx context.Context, carrier propagation.TextMapCarrier) context.Context {
+	value := carrier.Get(UberTraceIdHeader)
+	if value == "" {
+		return ctx
+	}
+	sc, err := parseUberTraceId(value)
+	if err != nil {
+		return ctx
+	}
+	return trace.ContextWithRemoteSpanContext(ctx, sc)
+}
+
+// Fields implements propagation.TextMapPropagator
+func (p JaegerPropagator) Fields() []string {
+	return []string{UberTraceIdHeader}
+}
+
+// parseUberTraceId parses `trace-id:span-id:parent-span-id:flags` string, which may be URL-encoded
+func parseUberTraceId(value string) (trace.SpanContext, error) {
+	if unescaped, err := url.QueryUnescape(value); err == nil {
+		value = unescaped
+	}
+	parts := strings.Split(strings.TrimSpace(value), ":")
+	if len(parts) != 4 || len(parts[0]) == 0 || len(parts[0]) > 32 || len(parts[1]) == 0 || len(parts[1]) > 16 {
+		return trace.SpanContext{}, fmt.Errorf("malformed %v: %q", UberTraceIdHeader, value)
+	}
+
+	// jaeger clients omit leading zeros of ids
+	traceId, errTrace := trace.TraceIDFromHex(leftPad(parts[0], 32))
+	spanId, errSpan := trace.SpanIDFromHex(leftPad(parts[1], 16))
+	flags, errFlags := strconv.ParseUint(parts[3], 16, 8)
+	for _, err := range []error{errTrace, errSpan, errFlags} {
+		if err != nil {
+			return trace.SpanContext{}, fmt.Errorf("malformed %v %q: %w", UberTraceIdHeader, value, err)
+		}
+	}
+
+	cfg := trace.SpanContextConfig{TraceID: traceId, SpanID: spanId, Remote: true}
+	if flags&1 == 1 {
+		cfg.TraceFlags = trace.FlagsSampled
+	}
+	return trace.NewSpanContext(cfg), nil
+}
+
+func leftPad(hex string, length int) string {
+	return strings.Repeat("0", length-len(hex)) + hex
+}
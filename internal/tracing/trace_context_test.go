@@ -0,0 +1,81 @@
+package tracing_test
+
+import (
+	"context"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/ozoncp/ocp-request-api/internal/tracing"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var _ = Describe("Propagator", func() {
+
+	var (
+		propagator = tracing.NewPropagator()
+		traceId    = trace.TraceID{15: 2, 7: 1}
+		spanId     = trace.SpanID{7: 3}
+	)
+
+	remoteSpanContext := func(carrier propagation.MapCarrier) trace.SpanContext {
+		return trace.SpanContextFromContext(propagator.Extract(context.Background(), carrier))
+	}
+
+	It("Writes traceparent header only", func() {
+		sc := trace.NewSpanContext(trace.SpanContextConfig{TraceID: traceId, SpanID: spanId, TraceFlags: trace.FlagsSampled})
+		carrier := propagation.MapCarrier{}
+
+		propagator.Inject(trace.ContextWithSpanContext(context.Background(), sc), carrier)
+		Expect(carrier).To(Equal(propagation.MapCarrier{
+			tracing.TraceParentHeader: "00-00000000000000010000000000000002-0000000000000003-01",
+		}))
+	})
+
+	It("Reads back injected span context", func() {
+		sc := trace.NewSpanContext(trace.SpanContextConfig{TraceID: traceId, SpanID: spanId})
+		carrier := propagation.MapCarrier{}
+		propagator.Inject(trace.ContextWithSpanContext(context.Background(), sc), carrier)
+
+		extracted := remoteSpanContext(carrier)
+		Expect(extracted.TraceID()).To(Equal(traceId))
+		Expect(extracted.SpanID()).To(Equal(spanId))
+		Expect(extracted.IsSampled()).To(BeFalse())
+		Expect(extracted.IsRemote()).To(BeTrue())
+	})
+
+	It("Reads uber-trace-id of jaeger clients", func() {
+		for _, value := range []string{"1000000000000002:3:0:1", "1000000000000002%3A3%3A0%3A1"} {
+			extracted := remoteSpanContext(propagation.MapCarrier{tracing.UberTraceIdHeader: value})
+			Expect(extracted.TraceID()).To(Equal(trace.TraceID{8: 0x10, 15: 2}), value)
+			Expect(extracted.SpanID()).To(Equal(spanId), value)
+			Expect(extracted.IsSampled()).To(BeTrue(), value)
+		}
+	})
+
+	It("Prefers traceparent to uber-trace-id", func() {
+		extracted := remoteSpanContext(propagation.MapCarrier{
+			tracing.UberTraceIdHeader: "abc:def:0:1",
+			tracing.TraceParentHeader: "00-00000000000000010000000000000002-0000000000000003-01",
+		})
+		Expect(extracted.TraceID()).To(Equal(traceId))
+	})
+
+	It("Ignores missing and malformed headers", func() {
+		Expect(remoteSpanContext(propagation.MapCarrier{}).IsValid()).To(BeFalse())
+
+		for _, value := range []string{
+			"garbage",
+			"ff-00000000000000010000000000000002-0000000000000003-01",
+			"00-00000000000000000000000000000000-0000000000000003-01",
+			"00-00000000000000010000000000000002-0000000000000000-01",
+			"00-0000000000000001000000000000000x-0000000000000003-01",
+		} {
+			extracted := remoteSpanContext(propagation.MapCarrier{tracing.TraceParentHeader: value})
+			Expect(extracted.IsValid()).To(BeFalse(), value)
+		}
+		for _, value := range []string{"garbage", "0:3:0:1", "1:0:0:1", "x:3:0:1", "1:3:0", "1:3:0:x"} {
+			extracted := remoteSpanContext(propagation.MapCarrier{tracing.UberTraceIdHeader: value})
+			Expect(extracted.IsValid()).To(BeFalse(), value)
+		}
+	})
+})
@@ -221,6 +221,13 @@ func (m *MultiCreateRequestV1Request) Validate() error {
 
 	}
 
+	if utf8.RuneCountInString(m.GetIdempotencyKey()) > 128 {
+		return MultiCreateRequestV1RequestValidationError{
+			field:  "IdempotencyKey",
+			reason: "value length must be at most 128 runes",
+		}
+	}
+
 	return nil
 }
 
@@ -520,6 +527,13 @@ func (m *CreateRequestV1Request) Validate() error {
 
 	// no validation rules for Text
 
+	if utf8.RuneCountInString(m.GetIdempotencyKey()) > 128 {
+		return CreateRequestV1RequestValidationError{
+			field:  "IdempotencyKey",
+			reason: "value length must be at most 128 runes",
+		}
+	}
+
 	return nil
 }
 
@@ -1028,6 +1042,59 @@ func (m *RequestAPIEvent) Validate() error {
 
 	// no validation rules for TraceSpan
 
+	// no validation rules for EventId
+
+	// no validation rules for SchemaVersion
+
+	if v, ok := interface{}(m.GetTimestamp()).(interface{ Validate() error }); ok {
+		if err := v.Validate(); err != nil {
+			return RequestAPIEventValidationError{
+				field:  "Timestamp",
+				reason: "embedded message failed validation",
+				cause:  err,
+			}
+		}
+	}
+
+	// no validation rules for Actor
+
+	if v, ok := interface{}(m.GetBefore()).(interface{ Validate() error }); ok {
+		if err := v.Validate(); err != nil {
+			return RequestAPIEventValidationError{
+				field:  "Before",
+				reason: "embedded message failed validation",
+				cause:  err,
+			}
+		}
+	}
+
+	if v, ok := interface{}(m.GetAfter()).(interface{ Validate() error }); ok {
+		if err := v.Validate(); err != nil {
+			return RequestAPIEventValidationError{
+				field:  "After",
+				reason: "embedded message failed validation",
+				cause:  err,
+			}
+		}
+	}
+
+	for idx, item := range m.GetListed() {
+		_, _ = idx, item
+
+		if v, ok := interface{}(item).(interface{ Validate() error }); ok {
+			if err := v.Validate(); err != nil {
+				return RequestAPIEventValidationError{
+					field:  fmt.Sprintf("Listed[%v]", idx),
+					reason: "embedded message failed validation",
+					cause:  err,
+				}
+			}
+		}
+
+	}
+
+	// no validation rules for Replay
+
 	return nil
 }
 
@@ -0,0 +1,19 @@
+-- +goose Up
+CREATE TABLE outbox
+(
+    id           BIGSERIAL PRIMARY KEY,
+    request_id   BIGINT NOT NULL,
+    payload      BYTEA NOT NULL,
+    created_at   TIMESTAMP NOT NULL DEFAULT now(),
+    delivered_at TIMESTAMP
+);
+
+CREATE INDEX outbox_undelivered_idx ON outbox (id) WHERE delivered_at IS NULL;
+
+-- +goose StatementBegin
+-- +goose StatementEnd
+
+-- +goose Down
+DROP TABLE IF EXISTS outbox;
+-- +goose StatementBegin
+-- +goose StatementEnd
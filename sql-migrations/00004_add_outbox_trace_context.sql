@@ -0,0 +1,10 @@
+-- +goose Up
+ALTER TABLE outbox ADD COLUMN trace_context JSONB;
+
+-- +goose StatementBegin
+-- +goose StatementEnd
+
+-- +goose Down
+ALTER TABLE outbox DROP COLUMN IF EXISTS trace_context;
+-- +goose StatementBegin
+-- +goose StatementEnd
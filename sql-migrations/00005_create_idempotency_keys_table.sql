@@ -0,0 +1,19 @@
+-- +goose Up
+CREATE TABLE idempotency_keys
+(
+    method      TEXT NOT NULL,
+    key         TEXT NOT NULL,
+    request_ids JSONB NOT NULL,
+    created_at  TIMESTAMP NOT NULL DEFAULT now(),
+    PRIMARY KEY (method, key)
+);
+
+CREATE INDEX idempotency_keys_created_at_idx ON idempotency_keys (created_at);
+
+-- +goose StatementBegin
+-- +goose StatementEnd
+
+-- +goose Down
+DROP TABLE IF EXISTS idempotency_keys;
+-- +goose StatementBegin
+-- +goose StatementEnd
@@ -0,0 +1,13 @@
+-- +goose Up
+DELETE FROM outbox WHERE delivered_at IS NOT NULL;
+DROP INDEX IF EXISTS outbox_undelivered_idx;
+ALTER TABLE outbox DROP COLUMN delivered_at;
+
+-- +goose StatementBegin
+-- +goose StatementEnd
+
+-- +goose Down
+ALTER TABLE outbox ADD COLUMN delivered_at TIMESTAMP;
+CREATE INDEX outbox_undelivered_idx ON outbox (id) WHERE delivered_at IS NULL;
+-- +goose StatementBegin
+-- +goose StatementEnd
@@ -0,0 +1,16 @@
+-- +goose Up
+ALTER TABLE idempotency_keys ADD COLUMN caller TEXT NOT NULL DEFAULT '';
+ALTER TABLE idempotency_keys DROP CONSTRAINT idempotency_keys_pkey;
+ALTER TABLE idempotency_keys ADD PRIMARY KEY (caller, method, key);
+
+-- +goose StatementBegin
+-- +goose StatementEnd
+
+-- +goose Down
+-- keys of different callers may clash without the caller, they are short-lived anyway
+DELETE FROM idempotency_keys;
+ALTER TABLE idempotency_keys DROP CONSTRAINT idempotency_keys_pkey;
+ALTER TABLE idempotency_keys DROP COLUMN caller;
+ALTER TABLE idempotency_keys ADD PRIMARY KEY (method, key);
+-- +goose StatementBegin
+-- +goose StatementEnd